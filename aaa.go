@@ -1,386 +1,1501 @@
-package main
-
-import (
-	"flag"
-	"fmt"
-	"io"
-	"log"
-	"net/http"
-	"os"
-	"strconv"
-	"sync"
-)
-
-// Downloader is a struct that represents a concurrent file downloader
-type Downloader struct {
-	url         string  // the url of the file to download
-	output      string  // the output filename
-	concurrency int     // the number of goroutines to use
-	size        int64   // the size of the file in bytes
-	ranges      [][2]int64 // the ranges of bytes to download by each goroutine
-}
-
-// NewDownloader creates a new Downloader with the given url, output and concurrency
-func NewDownloader(url, output string, concurrency int) *Downloader {
-	return &Downloader{
-		url:         url,
-		output:      output,
-		concurrency: concurrency,
-	}
-}
-
-// checkSupportRange checks if the server supports partial requests
-func (d *Downloader) checkSupportRange() error {
-	resp, err := http.Head(d.url)
-	if err != nil {
-		return err
-	}
-	if resp.StatusCode == http.StatusOK && resp.Header.Get("Accept-Ranges") == "bytes" {
-		d.size = resp.ContentLength
-		return nil
-	}
-	return fmt.Errorf("server does not support range requests")
-}
-
-// calculateRanges calculates the ranges of bytes to download by each goroutine
-func (d *Downloader) calculateRanges() {
-	chunkSize := d.size / int64(d.concurrency)
-	for i := 0; i < d.concurrency; i++ {
-		start := int64(i) * chunkSize
-		end := start + chunkSize - 1
-		if i == d.concurrency-1 {
-			end = d.size - 1
-		}
-		d.ranges = append(d.ranges, [2]int64{start, end})
-	}
-}
-
-// downloadChunk downloads a chunk of the file and writes it to a temporary file
-func (d *Downloader) downloadChunk(filename string, r [2]int64) error {
-	req, err := http.NewRequest("GET", d.url, nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r[0], r[1]))
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-	if _, err = io.Copy(file, resp.Body); err != nil {
-		return err
-	}
-	return nil
-}
-
-// mergeFiles merges the temporary files into one output file and deletes them
-func (d *Downloader) mergeFiles() error {
-	outputFile, err := os.Create(d.output)
-	if err != nil {
-		return err
-	}
-	defer outputFile.Close()
-	for i := 0; i < d.concurrency; i++ {
-		tempFile, err := os.Open(strconv.Itoa(i))
-		if err != nil {
-			return err
-		}
-		defer tempFile.Close()
-		if _, err = io.Copy(outputFile, tempFile); err != nil {
-			return err
-		}
-		os.Remove(strconv.Itoa(i))
-	}
-	return nil
-}
-
-// Download downloads the file concurrently and saves it to the output file
-func (d *Downloader) Download() error {
-	log.Println("Checking server support for range requests...")
-	if err := d.checkSupportRange(); err != nil {
-		return err
-	}
-	log.Printf("The size of the file is %d bytes\n", d.size)
-	d.calculateRanges()
-	log.Println("The ranges are:", d.ranges)
-
-	var wg sync.WaitGroup
-
-	for i, r := range d.ranges {
-		wg.Add(1)
-		go func(i int, r [2]int64) {
-			defer wg.Done()
-			filename := strconv.Itoa(i)
-			log.Printf("Downloading %s range %v\n", filename, r)
-			err := d.downloadChunk(filename, r)
-			if err != nil {
-				log.Printf("Error downloading %s: %v\n", filename, err)
-			} else {
-				log.Printf("Finished downloading %s\n", filename)
-			}
-			
-		}(i, r)
-		
-			
-		
-			
-		
-			
-		
-			
-		
-			
-		
-			
-		
-			
-		
-			
-		
-			
-		
-			
-		
-			
-		
-			
-		
-			
-		
-			
-		
-			
-		
-			
-		
-			
-		
-			
-		
-			
-		
-			
-		
-			
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-		
-		
-	
-		
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-			
-
-			
-
-		
-	
-		
-	
-		
-	
-		
-	
-		
-	
-		
-	
-		
-	
-		
-	
-		
-	
-		
-	
-		
-	
-		
-	
-		
-
-		
-	
-
-	wg.Wait()
-
-	log.Println("Merging files...")
-	err := d.mergeFiles()
-	if err != nil {
-		return err
-	}
-	log.Println("Download completed")
-	return nil
-	
-}
-
-func main() {
-
-	urlFlag := flag.String("url", "", "The url of the file to download")
-	outputFlag := flag.String("output", "", "The output filename")
-	concurrencyFlag := flag.Int("concurrency", 10, "The number of goroutines to use")
-
-	flag.Parse()
-
-	if *urlFlag == "" || *outputFlag == "" {
-        log.Fatal("url and output are required")
-    }
-
-	downloader := NewDownloader(*urlFlag, *outputFlag, *concurrencyFlag)
-
-	err := downloader.Download()
-	if err != nil {
-        log.Fatal(err)
-    }
-}
-
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Downloader is a struct that represents a concurrent file downloader
+type Downloader struct {
+	url             string                                      // the url of the file to download
+	output          string                                      // the output filename
+	concurrency     int                                         // the number of goroutines to use
+	size            int64                                       // the size of the file in bytes
+	ranges          [][2]int64                                  // the ranges of bytes to download by each goroutine
+	client          *http.Client                                // the client used for every request
+	transport       transportOptions                            // the settings of the client built by NewDownloader
+	middleware      []func(http.RoundTripper) http.RoundTripper // wrappers around the transport of the client, innermost first
+	headers         http.Header                                 // extra headers sent with every request
+	method          string                                      // the method of the requests for the file instead of GET, empty for GET
+	body            []byte                                      // the body sent with them
+	checksum        string                                      // the expected checksum of the output as algo:hex, empty to skip
+	rewriter        URLRewriter                                 // rewrites the url before each request, nil to keep it
+	verbose         bool                                        // whether to log debugging details
+	split           int                                         // the number of permanent part files to keep instead of merging, 0 to merge
+	decompress      bool                                        // whether the single stream fallback decodes gzip and deflate responses
+	reprobe         bool                                        // whether a missing or zero Content-Length is confirmed with a ranged GET
+	rangeProbe      bool                                        // whether a HEAD silent about ranges is followed by a ranged GET
+	quota           int64                                       // the most bytes that may be transferred, 0 for no limit
+	transferred     int64                                       // the bytes read from the network so far, accessed atomically
+	memBudget       int64                                       // the most bytes in-memory modes may buffer, 0 for no budget
+	pieceSize       int64                                       // the piece size of the piece hash sidecar, 0 to skip it
+	pieceAlgo       string                                      // the hash algorithm of the piece hash sidecar
+	etag            string                                      // the ETag reported by the probe
+	contentMD5      string                                      // the Content-MD5 reported by the probe
+	probeTimeout    time.Duration                               // the longest the probe for range support may take, 0 for no limit
+	cache           *Cache                                      // skips outputs checked within its TTL, nil for none
+	cached          bool                                        // whether the download was skipped for a fresh cache entry
+	ipc             *IPC                                        // receives the events of the download, nil for none
+	stallTimeout    time.Duration                               // how long a chunk response may deliver no data, 0 for no limit
+	stallRecovery   bool                                        // whether a stalled chunk continues on a fresh connection
+	started         time.Time                                   // when the download started
+	ended           time.Time                                   // when the download completed
+	kept            int64                                       // the bytes of the output kept from an earlier run
+	speedBasis      SpeedBasis                                  // the average speed the summary shows, useful when empty
+	scheduler       *Scheduler                                  // admits the chunk requests, DefaultScheduler when nil
+	resumable       int64                                       // the bytes of the output a failed download left to continue
+	wroteOutput     bool                                        // whether this run created or appended to the output file
+	extraOutputs    []string                                    // the paths the output is copied to once complete
+	spotChecks      int                                         // the random ranges downloaded again to check the output, 0 for none
+	spotSize        int64                                       // the size of the spot check ranges, 0 for the default
+	noContentMD5    bool                                        // whether the Content-MD5 of a single stream download is not checked
+	modified        string                                      // the Last-Modified reported by the probe
+	contentType     string                                      // the Content-Type reported by the probe
+	cont            bool                                        // whether an existing partial output is continued
+	resumeVerify    ResumeVerify                                // how much of a partial output is checked before it is continued
+	resumePieces    string                                      // the piece hashes a partial output is checked against, empty for none
+	segment         int64                                       // the size of the segments queued for the workers, 0 for one range per worker
+	rampDown        int                                         // the queued segments each worker needs in the tail to keep running, 0 to keep all
+	retired         int32                                       // the workers retired by the ramp-down, accessed atomically
+	tailStart       time.Time                                   // when the first worker was retired
+	finished        time.Time                                   // when the last chunk finished
+	resolved        string                                      // the url the probe was redirected to, used by the later requests
+	foreign         bool                                        // whether redirects resolved the url to another origin, so requests to it leave out the credentials
+	chunkRedirects  bool                                        // whether chunk requests may follow redirects that lead to a file of the same size
+	trustRedirects  bool                                        // whether credentials are sent on to the other origins redirects lead to
+	maxRedirects    int                                         // the most redirects a request may follow, 0 for the default
+	redirects       int32                                       // the redirects followed by all requests, accessed atomically
+	redirectChain   int32                                       // the most redirects one request followed, accessed atomically
+	active          int32                                       // the chunk requests in flight, accessed atomically
+	samples         string                                      // the file receiving throughput samples, empty for none
+	sampleEvery     time.Duration                               // the interval between throughput samples
+	strategy        Strategy                                    // how the chunks are assembled into the output
+	expectSize      int64                                       // the size the server must report, -1 to accept any
+	sizeGuard       bool                                        // whether a completed output must hold the reported size
+	outBytes        int64                                       // the bytes written to a streamed output
+	pause           PauseGate                                   // pauses this download
+	hold            PauseGate                                   // holds the workers while the adaptive stream switch measures, apart from pause
+	sharedPause     *PauseGate                                  // pauses a group of downloads, nil for none
+	workers         []*workerStats                              // the accounting of the chunk workers
+	onSegment       func(SegmentEvent)                          // receives the segment lifecycle events, nil for none
+	segments        []segmentState                              // the state of every range across attempts
+	symlinks        SymlinkPolicy                               // what happens when the output is a symbolic link
+	confirmFn       func(URLInfo) (bool, error)                 // decides after the probe whether to download, nil to always
+	declined        bool                                        // whether confirmFn declined the download
+	autoSuffix      bool                                        // whether an existing output is kept by saving to a suffixed name
+	lock            *Lockfile                                   // the lockfile pinning the size and checksum, nil for none
+	updateLock      bool                                        // whether the lockfile is updated instead of enforced
+	xattr           bool                                        // whether provenance is recorded in extended attributes of the output
+	adaptWindow     time.Duration                               // how long the parallel workers run before a single stream is compared, 0 to never compare
+	outHash         hash.Hash                                   // the running checksum of an output that cannot be read back
+	writerAt        io.WriterAt                                 // the shared output the file is written into, nil to write the output file
+	baseOffset      int64                                       // the offset of the file in writerAt
+	readAhead       int                                         // the segments fetched ahead of the one being written to a streamed output, 0 for the concurrency
+	splitter        Splitter                                    // splits the file into ranges, nil for the default
+	optionErr       error                                       // the first invalid option, returned by Download before any request
+	retry           RetryPolicy                                 // how failed chunk requests are retried
+	retries         int32                                       // the chunk requests retried
+	waited          int64                                       // nanoseconds spent in retry backoff
+	merged          int64                                       // nanoseconds spent merging temporary files into the output
+	mergeBufSize    int                                         // the size of the merge buffer, 0 for defaultMergeBuffer
+	sleepFunc       func(time.Duration)                         // waits between retries instead of a timer, nil for the timer
+	clock           Clock                                       // the time of the speeds, samples and timers, realClock unless WithClock
+	tracer          Tracer                                      // starts the spans of the download, nil for none
+	span            Span                                        // the download span while tracing
+	filenameHeader  string                                      // the response header naming an output left empty, "" for Content-Disposition and the url
+	directoryPolicy DirectoryPolicy                             // what an output named after a directory url does, DirectoryError when empty
+	logUnchanged    bool                                        // whether Watch logs the checks that find no change
+	smallFile       int64                                       // the size up to which a file is fetched over one connection, 0 to always split
+	onDiskFull      DiskFullAction                              // what happens when the disk is full, fail by default
+	fakeDisk        func(io.Writer) io.Writer                   // wraps the writes to the disk, for tests filling it up
+	tempPool        bool                                        // whether the tempfiles strategy keeps the segments in one file per worker
+	traceTiming     bool                                        // whether requests are traced for their latency breakdown
+	timing          timingCounters                              // the latency breakdown of the traced requests
+	errorPage       ErrorPageAction                             // what to do about content that looks like an error page, "" to not check
+	adaptive        *AdaptiveConcurrency                        // tunes adding and removing workers during the download, nil for a fixed concurrency
+	sink            func(size int64) (io.Writer, error)         // opens the writer a streamed output goes to, nil to write the output file
+
+	limiter        *rateLimiter // shared bandwidth limiter, nil when unlimited
+	bandwidthShare float64      // the share of the measured bandwidth the download may use, 0 for no probe
+	hostLimits     hostLimits   // the limiters of hosts with limits of their own, nil for none
+	limitProbes    bool         // whether probe requests count against the rate limit
+	limitAux       bool         // whether auxiliary downloads count against the rate limit
+}
+
+// Option configures optional behaviour of a Downloader
+type Option func(*Downloader)
+
+// WithReprobe sets whether a HEAD response with a missing or zero
+// Content-Length is confirmed with a GET for the first byte before the file
+// is treated as empty. Enabled by default
+func WithReprobe(enabled bool) Option {
+	return func(d *Downloader) {
+		d.reprobe = enabled
+	}
+}
+
+// WithVerbose enables logging of debugging details
+func WithVerbose(verbose bool) Option {
+	return func(d *Downloader) {
+		d.verbose = verbose
+	}
+}
+
+// NewDownloader creates a new Downloader with the given url, output and concurrency
+func NewDownloader(url, output string, concurrency int, opts ...Option) *Downloader {
+	d := &Downloader{
+		url:         url,
+		output:      output,
+		concurrency: concurrency,
+		limitAux:    true,
+		reprobe:     true,
+		rangeProbe:  true,
+		strategy:    StrategyWriteAt,
+		expectSize:  -1,
+		sizeGuard:   true,
+		symlinks:    SymlinkRefuse,
+		clock:       realClock{},
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.client == nil {
+		d.client = d.transport.newClient(d.debugf)
+	}
+	if len(d.middleware) > 0 {
+		d.client = wrapClient(d.client, d.middleware)
+	}
+	d.client = d.withRedirectCheck(d.client)
+	return d
+}
+
+// debugf logs only in verbose mode
+func (d *Downloader) debugf(format string, v ...interface{}) {
+	if d.verbose {
+		log.Printf(format, v...)
+	}
+}
+
+// newRequest creates a request for the file carrying the configured headers.
+// Once the probe resolved the url, requests go straight to the resolved one.
+// A GET becomes a request with the method and body of WithMethod
+func (d *Downloader) newRequest(ctx context.Context, method string) (*http.Request, error) {
+	var req *http.Request
+	var err error
+	if d.resolved != "" {
+		req, err = d.buildRequest(ctx, method, d.resolved)
+		if err == nil && d.foreign {
+			stripCredentials(req.Header)
+		}
+	} else {
+		req, err = d.newRequestURL(ctx, method, d.url)
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.overrideMethod(req)
+	return req, nil
+}
+
+// newRequestURL is like newRequest for another url, e.g. of an auxiliary download
+func (d *Downloader) newRequestURL(ctx context.Context, method, rawURL string) (*http.Request, error) {
+	url, err := d.rewriteURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return d.buildRequest(ctx, method, url)
+}
+
+// buildRequest creates a request for url carrying the configured headers
+func (d *Downloader) buildRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	if d.traceTiming {
+		ctx = d.traced(ctx)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range d.headers {
+		req.Header[k] = append([]string(nil), v...)
+	}
+	return req, nil
+}
+
+// ErrRangeNotSupported is returned when the server does not accept range requests
+var ErrRangeNotSupported = errors.New("server does not support range requests")
+
+// StatusError is returned when the server answers with a status code the
+// request cannot use, e.g. a 403 or a 503
+type StatusError struct {
+	Code   int    // the status code, e.g. 403
+	Status string // the status line, e.g. "403 Forbidden"
+}
+
+func (e *StatusError) Error() string {
+	return "unexpected status " + e.Status
+}
+
+// statusError returns the StatusError for resp
+func statusError(resp *http.Response) error {
+	return &StatusError{Code: resp.StatusCode, Status: resp.Status}
+}
+
+// probeSupport checks if the server supports partial requests
+func (d *Downloader) probeSupport(ctx context.Context) error {
+	if d.method != "" {
+		// a HEAD says nothing about how the endpoint answers the method
+		d.debugf("Probing ranges with a ranged %s\n", d.method)
+		return d.probeRange(ctx)
+	}
+	req, err := d.newRequest(ctx, "HEAD")
+	if err != nil {
+		return err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer d.wrapBody(resp.Body, probeRequest).Close()
+	if resp.StatusCode == http.StatusOK {
+		d.etag = resp.Header.Get("ETag")
+		d.modified = resp.Header.Get("Last-Modified")
+		d.contentType = resp.Header.Get("Content-Type")
+		d.contentMD5 = resp.Header.Get("Content-MD5")
+		// pin the url the redirects led to, so that the chunks are fetched
+		// from the resource whose size was probed
+		d.resolved = resp.Request.URL.String()
+		d.foreign = !d.trustRedirects && !sameOrigin(req.URL, resp.Request.URL)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ErrRangeNotSupported
+	}
+	switch parseAcceptRanges(resp.Header) {
+	case rangesNone:
+		return ErrRangeNotSupported
+	case rangesUnknown:
+		if !d.rangeProbe {
+			return ErrRangeNotSupported
+		}
+		d.debugf("HEAD does not tell whether ranges are supported, probing with a ranged GET\n")
+		return d.probeRange(ctx)
+	}
+	d.size = resp.ContentLength
+	if d.size <= 0 && d.reprobe {
+		// some servers answer HEAD with a zero or missing length for
+		// non-empty resources, ask for the first byte to be sure
+		d.debugf("HEAD reported Content-Length %d, probing with a ranged GET\n", d.size)
+		return d.probeRange(ctx)
+	}
+	return nil
+}
+
+// probeRange determines the size of the file from the Content-Range of a
+// GET for its first byte. A 416 response means the file is empty
+func (d *Downloader) probeRange(ctx context.Context) error {
+	req, err := d.newRequest(ctx, "GET")
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	body := d.wrapBody(resp.Body, probeRequest)
+	defer body.Close()
+	io.Copy(io.Discard, io.LimitReader(body, 1))
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		cr, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return err
+		}
+		if cr.Size < 0 {
+			return ErrRangeNotSupported
+		}
+		d.size = cr.Size
+		return nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		d.size = 0
+		return nil
+	}
+	return ErrRangeNotSupported
+}
+
+// calculateRanges calculates the ranges of bytes to download with the
+// splitter, by default one range per worker or, with a segment size, ranges
+// of that size
+func (d *Downloader) calculateRanges() error {
+	splitter := d.splitter
+	if splitter == nil {
+		splitter = EqualSplitter{}
+		if d.segment > 0 && d.split == 0 {
+			splitter = FixedSplitter{Size: d.segment}
+		}
+	}
+	ranges := splitter.Split(d.size, d.concurrency)
+	if err := checkRanges(ranges, d.size); err != nil {
+		return fmt.Errorf("invalid ranges: %w", err)
+	}
+	d.ranges = ranges
+	return nil
+}
+
+// fixedRanges splits size bytes into ranges of chunkSize bytes, the last
+// one holding the rest
+func fixedRanges(size, chunkSize int64) [][2]int64 {
+	return splitRanges(size, chunkSize, int((size+chunkSize-1)/chunkSize))
+}
+
+// splitRanges splits size bytes into n ranges of chunkSize bytes, the last
+// range extending to the end
+func splitRanges(size, chunkSize int64, n int) [][2]int64 {
+	var ranges [][2]int64
+	for i := 0; i < n; i++ {
+		start := int64(i) * chunkSize
+		end := start + chunkSize - 1
+		if i == n-1 {
+			end = size - 1
+		}
+		ranges = append(ranges, [2]int64{start, end})
+	}
+	return ranges
+}
+
+// downloadChunk downloads a chunk of the file and writes it to a temporary file
+func (d *Downloader) downloadChunk(ctx context.Context, filename string, r [2]int64) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return d.fetchRange(ctx, r, file)
+}
+
+// requestRange requests a range of the file and copies the response body to w
+func (d *Downloader) requestRange(ctx context.Context, r [2]int64, w io.Writer) error {
+	scheduler := d.scheduler
+	if scheduler == nil {
+		scheduler = DefaultScheduler
+	}
+	// wait for a pause to end before taking a slot, so the queued chunks
+	// of a paused download do not hold connections other downloads of the
+	// scheduler could use, and give the slot back when paused meanwhile
+	var release func()
+	for {
+		if err := d.waitResumed(ctx); err != nil {
+			return err
+		}
+		var err error
+		if release, err = scheduler.acquire(ctx, d); err != nil {
+			return err
+		}
+		if !d.paused() {
+			break
+		}
+		release()
+	}
+	defer release()
+	progress := func() {}
+	if d.stallTimeout > 0 {
+		var stop context.CancelFunc
+		ctx, progress, stop = d.watchStall(ctx)
+		defer stop()
+	}
+	req, err := d.newRequest(ctx, "GET")
+	if err != nil {
+		return err
+	}
+	if !d.chunkRedirects {
+		req = req.WithContext(withoutRedirects(req.Context()))
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r[0], r[1]))
+	atomic.AddInt32(&d.active, 1)
+	defer atomic.AddInt32(&d.active, -1)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return d.stalled(ctx, err)
+	}
+	defer resp.Body.Close()
+	progress()
+	if resp.Request.URL.String() != req.URL.String() {
+		// before the status, a redirect to a smaller file answers 416
+		if err := d.revalidate(resp); err != nil {
+			return err
+		}
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		// e.g. a 403 of a session-gated server that did not get its cookie
+		return fmt.Errorf("%w for range %v", statusError(resp), r)
+	}
+	if err := checkResponseRange(resp.Header.Get("Content-Range"), r); err != nil {
+		return err
+	}
+	body := &progressReader{ReadCloser: resp.Body, progress: progress}
+	if _, err = io.Copy(d.diskFull(ctx, w), d.pausable(ctx, reportSegment(ctx, countWorker(ctx, d.wrapBody(body, chunkRequest))))); err != nil {
+		return d.stalled(ctx, err)
+	}
+	return nil
+}
+
+// runChunks calls fn for every range from a pool of at most concurrency
+// workers taking the ranges in order from a queue. The first failure
+// cancels the context of the others and is returned
+func (d *Downloader) runChunks(ctx context.Context, fn func(ctx context.Context, i int, r [2]int64) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	queue := make(chan int, len(d.ranges))
+	for i := range d.ranges {
+		queue <- i
+	}
+	close(queue)
+	workers := d.concurrency
+	if workers > len(d.ranges) {
+		workers = len(d.ranges)
+	}
+	active := int32(workers)
+	// the adaptive controller may start more workers, in the free slots
+	slots := workers
+	if d.adaptive != nil && slots < d.adaptive.Max {
+		slots = d.adaptive.Max
+		if slots > len(d.ranges) {
+			slots = len(d.ranges)
+		}
+	}
+	target := int32(workers)
+	free := make(chan int, slots)
+	for w := workers; w < slots; w++ {
+		free <- w
+	}
+	d.workers = make([]*workerStats, slots)
+	for w := range d.workers {
+		d.workers[w] = &workerStats{}
+	}
+
+	if len(d.segments) != len(d.ranges) {
+		d.segments = make([]segmentState, len(d.ranges))
+	}
+
+	spawn := func(worker int) {
+		wg.Add(1)
+		ws := d.workers[worker]
+		ctx := context.WithValue(context.WithValue(ctx, workerKey{}, ws), workerSlotKey{}, worker)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				r := d.ranges[i]
+				log.Printf("Downloading chunk %d range %v\n", i, r)
+				start := d.clock.Now()
+				err := d.runSegment(ctx, i, worker, fn)
+				atomic.AddInt64(&ws.active, int64(d.clock.Now().Sub(start)))
+				if err != nil {
+					log.Printf("Error downloading chunk %d: %v\n", i, err)
+					// the first failure aborts the other chunks
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+				atomic.AddInt32(&ws.chunks, 1)
+				log.Printf("Finished downloading chunk %d\n", i)
+				if d.retire(&active, len(queue)) || shed(&active, &target) {
+					free <- worker
+					return
+				}
+			}
+		}()
+	}
+	for w := 0; w < workers; w++ {
+		spawn(w)
+	}
+	if d.adaptive != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.controlConcurrency(ctx, queue, &active, &target, free, spawn)
+		}()
+	}
+
+	wg.Wait()
+	d.finished = d.clock.Now()
+	return firstErr
+}
+
+// chunkFile returns the name of the file holding the i-th chunk, a hidden
+// .output.chunk<i> next to the output, so downloads running side by side in
+// one directory never share one. The name of the output is shortened to
+// keep it within maxNameBytes
+func (d *Downloader) chunkFile(i int) string {
+	if d.split > 0 {
+		return d.output + ".part" + strconv.Itoa(i)
+	}
+	suffix := ".chunk" + strconv.Itoa(i)
+	return filepath.Join(filepath.Dir(d.output), "."+truncateUTF8(filepath.Base(d.output), maxNameBytes-1-len(suffix))+suffix)
+}
+
+// mergeFiles merges the temporary files into one output file and deletes them.
+// Every temporary file must hold its whole range and be copied completely,
+// and the output must end up with the size of the file, so that a chunk
+// truncated on disk or a short copy cannot pass as a complete download
+func (d *Downloader) mergeFiles(ctx context.Context) error {
+	// check every chunk before the first one is merged and removed
+	for i, r := range d.ranges {
+		info, err := os.Stat(d.chunkFile(i))
+		if err != nil {
+			return err
+		}
+		if want := r[1] - r[0] + 1; info.Size() != want {
+			return fmt.Errorf("chunk %d holds %d bytes, expected %d", i, info.Size(), want)
+		}
+	}
+	outputFile, err := os.Create(d.output)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+	start := d.clock.Now()
+	buf := d.mergeBuffer()
+	var total int64
+	for i, r := range d.ranges {
+		n, err := appendFile(d.diskFull(ctx, outputFile), d.chunkFile(i), buf)
+		if err != nil {
+			return err
+		}
+		if want := r[1] - r[0] + 1; n != want {
+			return fmt.Errorf("merged %d of the %d bytes of chunk %d", n, want, i)
+		}
+		total += n
+		os.Remove(d.chunkFile(i))
+	}
+	if err := outputFile.Close(); err != nil {
+		return err
+	}
+	info, err := os.Stat(d.output)
+	if err != nil {
+		return err
+	}
+	if info.Size() != total || total != d.size {
+		return fmt.Errorf("merged output holds %d bytes, the chunks %d and the file %d", info.Size(), total, d.size)
+	}
+	d.timeMerge(start, total)
+	return nil
+}
+
+// appendFile copies the content of the file at path to w through buf, a
+// buffer of its own if buf is nil
+func appendFile(w io.Writer, path string, buf []byte) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return copyBuffer(w, file, buf)
+}
+
+// Download downloads the file concurrently and saves it to the output file
+func (d *Downloader) Download() error {
+	return d.DownloadContext(context.Background())
+}
+
+// DownloadContext is like Download but aborts the requests when ctx is done
+func (d *Downloader) DownloadContext(ctx context.Context) (err error) {
+	d.started = d.clock.Now()
+	if d.optionErr != nil {
+		return d.optionErr
+	}
+	if d.ipc != nil {
+		done := d.ipc.track(d)
+		defer func() { done(err) }()
+	}
+	ctx, endTrace := d.startTrace(ctx)
+	defer func() { endTrace(err) }()
+	if d.samples != "" {
+		stop, err := d.startSampling()
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+	if d.output == "" && d.fileOutput() {
+		if err := d.deriveOutput(ctx); err != nil {
+			return err
+		}
+	}
+	if d.fileOutput() {
+		if err := d.checkOutputName(); err != nil {
+			return err
+		}
+		if err := d.checkSymlink(); err != nil {
+			return err
+		}
+	}
+	if d.cache != nil && d.fileOutput() && d.split == 0 && d.cache.fresh(d.url, d.output) {
+		log.Printf("%s was checked within the cache TTL, skipping\n", d.output)
+		d.cached = true
+		return nil
+	}
+	if d.autoSuffix && d.fileOutput() {
+		if d.cont {
+			return errors.New("cannot pick a new output name when continuing the output")
+		}
+		output, err := reserveOutput(d.output)
+		if err != nil {
+			return err
+		}
+		if output != d.output {
+			log.Printf("%s exists, saving to %s\n", d.output, output)
+		}
+		d.output = output
+	}
+	if d.lock != nil && !d.updateLock {
+		if err := d.applyLock(); err != nil {
+			return err
+		}
+	}
+	var copies []*outputCopy
+	if len(d.extraOutputs) > 0 {
+		if !d.fileOutput() || d.split > 0 {
+			return errors.New("extra outputs need the output to be a single file")
+		}
+		if copies, err = d.createCopies(); err != nil {
+			return err
+		}
+		defer removeCopies(copies)
+	}
+	if err := d.download(ctx); err != nil {
+		if d.autoSuffix {
+			if info, statErr := os.Stat(d.output); statErr == nil && info.Size() == 0 {
+				os.Remove(d.output)
+			}
+		}
+		if errors.Is(err, ErrDiskFull) && d.fileOutput() && !d.cont && d.split == 0 {
+			d.removePartial()
+		}
+		if err == errDeclined {
+			log.Println("Download declined")
+			d.declined = true
+			return nil
+		}
+		d.noteResumable(err)
+		return err
+	}
+	if d.spotChecks > 0 && d.fileOutput() && d.split == 0 {
+		if err := d.spotCheck(ctx); err != nil {
+			return err
+		}
+	}
+	if d.lock != nil && d.updateLock {
+		if err := d.recordLock(); err != nil {
+			return err
+		}
+	}
+	if d.xattr && d.fileOutput() && d.split == 0 {
+		d.writeProvenance()
+	}
+	if err := d.writeCopies(copies); err != nil {
+		return err
+	}
+	if d.cache != nil && d.fileOutput() && d.split == 0 {
+		d.cache.record(d.url, d.output, d.etag, d.modified)
+	}
+	d.ended = d.clock.Now()
+	res := d.Result()
+	basis := d.speedBasis
+	if basis == "" {
+		basis = SpeedUseful
+	}
+	log.Printf("Transferred %d bytes for a %d byte file (%.2fx) in %v, %.2f MiB/s %s\n", res.Transferred, res.Size, res.Overhead(), res.Elapsed.Round(time.Millisecond), res.rate(basis)/(1<<20), basis)
+	if res.Retries > 0 {
+		log.Printf("Retried %d chunk requests, waiting %v in total\n", res.Retries, res.Waited.Round(time.Millisecond))
+	}
+	if res.Redirects > 0 {
+		log.Printf("Followed %d redirects, at most %d for one request\n", res.Redirects, res.RedirectChain)
+	}
+	if res.Retired > 0 {
+		log.Printf("Ramp-down retired %d workers, the tail took %v\n", res.Retired, res.Tail)
+	}
+	if d.verbose {
+		logConnections(res.Connections)
+	}
+	if d.traceTiming {
+		logTiming(res.Timing, res.Connections)
+	}
+	return nil
+}
+
+// download runs the probe, the transfer and the verification
+func (d *Downloader) download(ctx context.Context) error {
+	var sum *checksum
+	if d.checksum != "" {
+		var err error
+		if sum, err = parseChecksum(d.checksum); err != nil {
+			return err
+		}
+	}
+	if d.streamed() {
+		if d.split > 0 || d.cont {
+			return errors.New("cannot split or continue a streamed output")
+		}
+		if d.strategy != StrategyTempFiles {
+			d.strategy = StrategyStream
+		}
+	}
+	if d.writerAt != nil {
+		if d.split > 0 || d.cont {
+			return errors.New("cannot split or continue a region of a shared output")
+		}
+		d.strategy = StrategyWriteAt
+	}
+	if d.split > 0 {
+		d.strategy = StrategyTempFiles
+	}
+	if d.cont && d.split == 0 {
+		if info, err := os.Stat(d.output); err == nil && info.Size() > 0 {
+			if err := d.continueOutput(ctx, info.Size()); err != nil {
+				return err
+			}
+			return d.verifyOutput(sum)
+		}
+	}
+	log.Println("Checking server support for range requests...")
+	if err := d.checkSupportRange(ctx); err == ErrRangeNotSupported && d.split == 0 {
+		log.Println("Server does not support range requests, downloading with a single stream...")
+		if err := d.confirm(false); err != nil {
+			return err
+		}
+		if err := d.downloadStream(ctx, sum); err != nil {
+			return err
+		}
+		return d.verifyOutput(sum)
+	} else if err != nil {
+		return err
+	}
+	log.Printf("The size of the file is %d bytes\n", d.size)
+	d.traceSizeKnown()
+	d.reportContentMD5()
+	if err := d.checkExpectedSize(d.size); err != nil {
+		return err
+	}
+	if err := d.confirm(true); err != nil {
+		return err
+	}
+	if d.errorPage != "" && d.size > 0 && !d.expectsText() {
+		if err := d.sniffStart(ctx); err != nil {
+			return err
+		}
+	}
+	if d.size == 0 && d.split == 0 {
+		file, err := d.createOutput(sum, 0)
+		if err != nil {
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		return d.verifyOutput(sum)
+	}
+	if d.smallFile > 0 && d.size <= d.smallFile && d.concurrency != 1 {
+		d.debugf("Fetching the file of %d bytes over a single connection\n", d.size)
+		d.concurrency = 1
+	}
+	if d.concurrency <= 0 {
+		n, err := d.estimateConcurrency(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("Estimated concurrency: %d\n", n)
+		d.concurrency = n
+	}
+	if d.bandwidthShare > 0 {
+		if err := d.probeBandwidth(ctx); err != nil {
+			return err
+		}
+	}
+	if (d.strategy == StrategyStream || d.streamed()) && d.segment == 0 {
+		d.segment = defaultStreamSegment
+	}
+	if d.adaptive != nil && d.segment == 0 && d.splitter == nil && d.split == 0 {
+		// workers are only added and removed between segments
+		d.segment = defaultStreamSegment
+	}
+	if err := d.calculateRanges(); err != nil {
+		return err
+	}
+	log.Println("The ranges are:", d.ranges)
+
+	if d.adaptWindow > 0 && d.strategy != StrategyWriteAt {
+		log.Printf("Adaptive stream switching does not apply to the %s strategy\n", d.strategy)
+	}
+	if d.adaptive != nil && d.strategy == StrategyStream {
+		log.Println("Adaptive concurrency does not apply to the stream strategy")
+	}
+	switch d.strategy {
+	case StrategyWriteAt:
+		if err := d.downloadWriteAt(ctx); err != nil {
+			return err
+		}
+		return d.verifyOutput(sum)
+	case StrategyStream:
+		if err := d.downloadOrdered(ctx, sum); err != nil {
+			return err
+		}
+		return d.verifyOutput(sum)
+	}
+
+	if d.streamed() {
+		if err := d.downloadOrderedFiles(ctx, sum); err != nil {
+			return err
+		}
+		return d.verifyOutput(sum)
+	}
+
+	if d.tempPool && d.split == 0 {
+		// runChunks uses fewer worker slots than ranges
+		pool := newTempPool(len(d.ranges), len(d.ranges))
+		defer d.removePool(pool)
+		err := d.runChunks(ctx, func(ctx context.Context, i int, r [2]int64) error {
+			return d.downloadSegment(ctx, pool, i, r)
+		})
+		if err != nil {
+			return err
+		}
+		log.Println("Merging files...")
+		if err := d.mergePool(ctx, pool); err != nil {
+			return err
+		}
+		return d.verifyOutput(sum)
+	}
+
+	err := d.runChunks(ctx, func(ctx context.Context, i int, r [2]int64) error {
+		return d.downloadChunk(ctx, d.chunkFile(i), r)
+	})
+	if err != nil {
+		if d.split == 0 {
+			for i := range d.ranges {
+				os.Remove(d.chunkFile(i))
+			}
+		}
+		return err
+	}
+
+	if d.split > 0 {
+		return d.finishSplit(sum)
+	}
+
+	log.Println("Merging files...")
+	if err := d.mergeFiles(ctx); err != nil {
+		return err
+	}
+	return d.verifyOutput(sum)
+}
+
+// verifyOutput checks the output against the expected checksum, if any
+func (d *Downloader) verifyOutput(sum *checksum) error {
+	if err := d.checkOutputSize(); err != nil {
+		return err
+	}
+	if d.writerAt != nil {
+		return d.verifyRegion(sum)
+	}
+	if d.streamed() {
+		// a streamed output cannot be read back, it was hashed while written
+		if sum != nil {
+			log.Printf("Verifying %s checksum...\n", sum.algo)
+			if err := sum.verify(d.outHash.Sum(nil)); err != nil {
+				return err
+			}
+		}
+		if d.pieceSize > 0 {
+			log.Println("Piece hashes are not written for a streamed output")
+		}
+		log.Println("Download completed")
+		return nil
+	}
+	if sum != nil {
+		log.Printf("Verifying %s checksum...\n", sum.algo)
+		if err := sum.verifyFile(d.output); err != nil {
+			return err
+		}
+	}
+	if d.pieceSize > 0 {
+		if err := d.writePieceHashes(); err != nil {
+			return err
+		}
+	}
+	log.Println("Download completed")
+	return nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Print(err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// run parses the flags and performs the requested operation
+func run() error {
+
+	urlFlag := flag.String("url", "", "The url of the file to download: http, https, file:///path or a data: url")
+	outputFlag := flag.String("output", "", "The output filename, - for standard output, empty to name it after the server or the url")
+	retriesFlag := flag.Int("retries", 0, "Retry a chunk failing with a network error or a 408, 429 or 5xx status up to this many times")
+	retryBackoffFlag := flag.Duration("retry-backoff", defaultBackoff, "The wait before the first retry, doubling for every further one")
+	maxBackoffFlag := flag.Duration("max-backoff", 30*time.Second, "The longest wait between two attempts of a chunk, 0 for no limit")
+	watchFlag := flag.Duration("watch", 0, "Keep -output a copy of the url, checking this often whether it changed, until interrupted")
+	watchLogFlag := flag.Bool("watch-log-unchanged", false, "With -watch, log every check that finds the url unchanged, not only with -verbose")
+	filenameHeaderFlag := flag.String("filename-header", "", "Without -output, name the output after this response header, e.g. X-Filename, before Content-Disposition and the url")
+	concurrencyFlag := flag.Int("concurrency", 10, "The number of goroutines to use, 0 to estimate it from a short measurement")
+	limitRateFlag := flag.String("limit-rate", "", "Cap the total download rate, e.g. 500K or 2M bytes per second")
+	bandwidthShareFlag := flag.Float64("bandwidth-share", 0, "Measure the bandwidth before downloading and cap the rate at this share of it, e.g. 0.5, at the cost of up to 16MB fetched in one second")
+	limitProbesFlag := flag.Bool("limit-probes", false, "Count probe requests against -limit-rate")
+	limitAuxFlag := flag.Bool("limit-aux", true, "Count auxiliary downloads (checksum, signature files) against -limit-rate")
+	checksumFlag := flag.String("checksum", "", "Verify the output against a checksum given as algo:hex, e.g. sha256:ab12...")
+	batchFlag := flag.String("batch", "", "Download every entry of a JSON batch manifest instead of a single url")
+	smallFileFlag := flag.String("small-file", "", "Fetch files up to this size, e.g. 1M, over a single connection, 1M by default for -batch and -index, 0 to always split")
+	diskFullFlag := flag.String("on-disk-full", "fail", "When the disk fills up: fail, removing the partial output, or pause until space is freed")
+	mergeBufferFlag := flag.Int("merge-buffer", defaultMergeBuffer, "With -strategy tempfiles, the size in bytes of the buffer the temporary files are merged through")
+	tempPoolFlag := flag.Bool("temp-pool", false, "With -strategy tempfiles, keep the segments in one temporary file per worker instead of one per segment")
+	traceTimingFlag := flag.Bool("trace-timing", false, "Trace the DNS, connect, TLS and first byte time of every request and report the breakdown")
+	errorPageFlag := flag.String("detect-error-page", "", "Sniff the start of the file for an HTML, XML or JSON error page served as the file: warn or fail")
+	cacheFlag := flag.String("cache", "", "Remember what was downloaded in this file and skip outputs checked within -cache-ttl without asking the server")
+	cacheTTLFlag := flag.Duration("cache-ttl", time.Hour, "How long an output recorded in the -cache is trusted to be unchanged")
+	revalidateFlag := flag.Bool("revalidate", false, "Ask the server about every output even if the -cache holds a fresh entry for it")
+	stallTimeoutFlag := flag.Duration("stall-timeout", 0, "Abandon a chunk response that delivers no data for this long, 0 to wait forever")
+	stallReopenFlag := flag.Bool("stall-reopen", true, "With -stall-timeout, continue a stalled chunk on a fresh connection before failing it")
+	speedFlag := flag.String("speed", string(SpeedUseful), "The average speed the summary shows: useful counts the bytes of the file, raw every byte transferred including retries")
+	interfaceFlag := flag.String("interface", "", "Send the requests from the address of this network interface, as name or name@address")
+	recoveryHintFlag := flag.Bool("recovery-hint", true, "When a download fails, log how much of it was kept and the command continuing it")
+	noDelayFlag := flag.Bool("tcp-nodelay", true, "Disable Nagle's algorithm on the connections")
+	recvBufferFlag := flag.String("recv-buffer", "", "The receive buffer of every connection, e.g. 4M for a link with a high bandwidth delay product, empty for the system default")
+	sendBufferFlag := flag.String("send-buffer", "", "The send buffer of every connection, empty for the system default")
+	maxDNSFlag := flag.Int("max-dns-concurrency", 0, "Run at most this many DNS lookups at a time, e.g. for a -batch from many hosts, 0 for no limit")
+	spotCheckFlag := flag.Int("spot-check", 0, "Download this many random ranges again once complete and fail if any differs from the output")
+	spotSizeFlag := flag.String("spot-check-size", "64K", "The size of the -spot-check ranges")
+	ipcFlag := flag.String("ipc", "", "Send the progress and result as line-delimited JSON to the Unix domain socket at this path")
+	parallelFilesFlag := flag.Int("max-parallel-files", 1, "With -batch or -index, download up to this many files at a time")
+	totalConnsFlag := flag.Int("total-connections", 0, "With -batch or -index, hold at most this many connections over all files, shared out in turn between the files waiting for one, 0 for no limit. Every file then runs that many workers unless its entry sets a concurrency")
+	manifestOutFlag := flag.String("manifest-out", "", "With -batch, -index or a -directory listing, write a JSON report of every file, its size, sha256, status, duration and retries to this file when the batch ends")
+	batchStateFlag := flag.String("batch-state", "", "Record the progress of -batch or -index in this file and skip or continue what an earlier run completed or left")
+	directoryFlag := flag.String("directory", string(DirectoryError), "What to do when -output is empty and the url looks like a directory: error, index to save the page as index.html, or listing to download the files it links to")
+	indexFlag := flag.String("index", "", "Download and verify every file listed by a checksum index such as SHA256SUMS")
+	indexAlgoFlag := flag.String("index-algo", "sha256", "The checksum algorithm used by the -index file")
+	verboseFlag := flag.Bool("verbose", false, "Log debugging details")
+	cleanStaleFlag := flag.Duration("clean-stale", 0, "On startup remove the parts, pool and temporary files interrupted downloads left next to -output, or in the current directory, that were not written for this long, e.g. 24h; without a url only clean")
+	cleanDryRunFlag := flag.Bool("clean-dry-run", false, "With -clean-stale, only list the files it would remove")
+	joinFlag := flag.String("join", "", "Reassemble the parts described by a -split-output manifest into -output, or the original name when -output is empty")
+	probeTimeoutFlag := flag.Duration("probe-timeout", 30*time.Second, "Give up when the server does not answer the probe for range support within this time, 0 to wait forever")
+	contentMD5Flag := flag.Bool("content-md5", true, "Verify a single stream download against the Content-MD5 header the server sends")
+	decompressFlag := flag.Bool("decompress", false, "Decode gzip or deflate Content-Encoding when falling back to a single stream")
+	rangeProbeFlag := flag.Bool("probe-ranges", true, "When HEAD sends no Accept-Ranges or an unknown unit, find out with a ranged GET whether ranges work")
+	reprobeFlag := flag.Bool("reprobe-empty", true, "Confirm a missing or zero Content-Length from HEAD with a ranged GET")
+	quotaFlag := flag.String("byte-quota", "", "Abort once the bytes transferred, including retries, exceed this, e.g. 5GB")
+	pieceFlag := flag.String("piece-hashes", "", "Write hashes of fixed-size pieces of the output, e.g. 4M, to output.pieces.json")
+	pieceAlgoFlag := flag.String("piece-algo", "sha256", "The piece hash algorithm, sha1 or sha256")
+	continueFlag := flag.Bool("continue", false, "Continue a partial output left by an earlier run or another tool by appending the missing bytes")
+	maxHeaderFlag := flag.String("max-header-bytes", "", "Reject responses whose headers exceed this size, e.g. 64K (default 1M)")
+	harFlag := flag.String("har", "", "Record every request and response to a HAR file, with credentials redacted")
+	chunkSizesFlag := flag.String("chunk-sizes", "", "Split the file into chunks of these sizes in order, e.g. 256K,1M,8M, the last size repeating to the end")
+	segmentFlag := flag.String("segment-size", "", "Queue segments of this size, e.g. 1M, for the workers instead of one range per worker")
+	rampDownFlag := flag.Int("ramp-down", 0, "With -segment-size, retire workers in the tail so each has at least N queued segments left (0 keeps all)")
+	chunkRedirectFlag := flag.String("chunk-redirect", "fail", "What to do when a chunk request is redirected: fail, or revalidate that it leads to a file of the probed size")
+	samplesFlag := flag.String("speed-samples", "", "Write throughput samples to a .csv file, or JSON lines for other names")
+	sampleEveryFlag := flag.Duration("sample-interval", time.Second, "The interval between -speed-samples")
+	verifyResumeFlag := flag.String("verify-resume", string(ResumeVerifyNone), "How a partial output is checked before -continue: none, last-block to download the last block again, or full to hash every piece against -resume-pieces")
+	resumePiecesFlag := flag.String("resume-pieces", "", "A piece hashes file, as written by -piece-hashes, to check a partial output against")
+	expectSizeFlag := flag.Int64("expect-size", -1, "Refuse the download when the server reports another size in bytes, -1 to accept any")
+	adaptiveConcFlag := flag.Int("adaptive-concurrency", 0, "Add and remove workers during the download while it pays off, up to this many, 0 for a fixed -concurrency")
+	adaptiveGainFlag := flag.Float64("adaptive-gain", defaultAdaptiveGain, "With -adaptive-concurrency, the throughput gain an added worker must bring to be kept")
+	adaptiveDropFlag := flag.Float64("adaptive-drop", defaultAdaptiveDrop, "With -adaptive-concurrency, the throughput drop that removes a worker")
+	targetSpeedFlag := flag.String("target-speed", "", "Add workers during the download while the throughput is below this rate, e.g. 10M bytes per second, up to -max-concurrency")
+	maxConcFlag := flag.Int("max-concurrency", defaultAdaptiveMax, "With -target-speed, the most workers to reach it with")
+	adaptiveCooldownFlag := flag.Duration("adaptive-cooldown", defaultAdaptiveCooldown, "With -adaptive-concurrency, how long each level runs before it is judged")
+	adaptiveFlag := flag.Duration("adaptive-stream", 0, "After this long compare the parallel throughput with a single stream and switch to one connection when it is clearly faster, 0 to disable")
+	xattrFlag := flag.Bool("xattr", false, "Record the source url, time and ETag in extended attributes of the output")
+	autoSuffixFlag := flag.Bool("auto-suffix", false, "Save to output.1, output.2, ... instead of overwriting an existing output")
+	printPathFlag := flag.Bool("print-path", false, "Print the path of every completed output on standard output, one per line")
+	lockFlag := flag.String("lock", "", "A lockfile pinning the size and sha256 of every url, downloads that differ fail")
+	updateLockFlag := flag.Bool("update-lock", false, "Pin the completed downloads in the -lock file instead of enforcing it")
+	cookiesFlag := flag.Bool("cookies", false, "Keep the cookies set by responses, e.g. by the probe, and send them on later requests")
+	notifyFlag := flag.Bool("notify", false, "Show a desktop notification when the download completes or fails (notify-send, osascript or PowerShell)")
+	symlinksFlag := flag.String("symlinks", string(SymlinkRefuse), "When the output is a symbolic link: refuse, follow it, or replace the link with a new file")
+	strategyFlag := flag.String("strategy", string(StrategyWriteAt), "How chunks are assembled: writeat into the output, tempfiles merged at the end, or stream in order for pipes")
+	splitFlag := flag.Int("split-output", 0, "Keep the file as N permanent parts output.part0..N-1 plus a manifest instead of merging")
+	maxRedirectsFlag := flag.Int("max-redirects", defaultMaxRedirects, "Fail a request redirected more often than this")
+	trustRedirectsFlag := flag.Bool("trust-redirects", false, "Send the Authorization and Cookie headers on when a redirect leads to another host, like curl --location-trusted")
+	methodFlag := flag.String("method", "", "The method of the requests for the file, e.g. POST for endpoints that only serve it in answer to one (default GET, or POST with -data)")
+	dataFlag := flag.String("data", "", "The body of the requests for the file, or @path to read it from a file; set its type with -header 'Content-Type: ...'")
+	var headerFlag headerList
+	var proxyHeaderFlag headerList
+	flag.Var(&headerFlag, "header", "An extra request header as 'Name: value', may be repeated")
+	flag.Var(&proxyHeaderFlag, "proxy-header", "A header for the CONNECT request to the https proxy as 'Name: value', may be repeated")
+	var connectToFlag stringList
+	flag.Var(&connectToFlag, "connect-to", "Connect to addr2:port2 instead of host1:port1, given as host1:port1:addr2:port2 and keeping Host and SNI, may be repeated")
+	var rewriteFlag stringList
+	var alsoOutputFlag stringList
+	var hostLimitFlag stringList
+	flag.Var(&hostLimitFlag, "host-limit", "Cap the download rate from a host and its subdomains as host=rate, e.g. example.com=1MB/s or example.org=unlimited, may be repeated; other hosts get -limit-rate")
+	flag.Var(&alsoOutputFlag, "also-output", "Copy the completed output to this path as well, may be repeated")
+	flag.Var(&rewriteFlag, "rewrite", "Rewrite urls starting with a prefix as 'prefix=replacement', e.g. to an internal mirror, may be repeated")
+
+	flag.Parse()
+
+	if *joinFlag != "" {
+		return joinSplit(*joinFlag, *outputFlag)
+	}
+
+	if *cleanStaleFlag > 0 {
+		dir := "."
+		if *outputFlag != "" && *outputFlag != "-" {
+			dir = filepath.Dir(*outputFlag)
+		}
+		if err := cleanStale(dir, *cleanStaleFlag, *cleanDryRunFlag); err != nil {
+			return err
+		}
+		if *batchFlag == "" && *indexFlag == "" && *urlFlag == "" {
+			return nil
+		}
+	}
+
+	if *batchFlag == "" && *indexFlag == "" && *urlFlag == "" {
+		return errors.New("url is required")
+	}
+
+	// finish reports the outcome of the downloads, as a desktop notification with -notify
+	finish := func(what string, err error) error {
+		if *notifyFlag {
+			return notifyDone(what, err)
+		}
+		return err
+	}
+	var printPath func(*Downloader)
+	if *printPathFlag {
+		if *outputFlag == "-" {
+			return errors.New("cannot print the path of standard output")
+		}
+		printPath = func(d *Downloader) {
+			if !d.Declined() {
+				fmt.Println(d.Output())
+			}
+		}
+	}
+
+	opts := []Option{WithVerbose(*verboseFlag), WithReprobe(*reprobeFlag), WithRangeProbe(*rangeProbeFlag), WithTraceTiming(*traceTimingFlag), WithTempFilePool(*tempPoolFlag), WithMergeBuffer(*mergeBufferFlag)}
+	if *harFlag != "" {
+		har := NewHARRecorder()
+		opts = append(opts, WithHARRecorder(har))
+		// written on failure too, that is when it is most useful
+		defer func() {
+			if err := har.WriteFile(*harFlag); err != nil {
+				log.Printf("Error writing %s: %v\n", *harFlag, err)
+			}
+		}()
+	}
+	if len(connectToFlag) > 0 {
+		var rules []ConnectTo
+		for _, s := range connectToFlag {
+			rule, err := ParseConnectTo(s)
+			if err != nil {
+				return err
+			}
+			rules = append(rules, rule)
+		}
+		opts = append(opts, WithConnectTo(rules...))
+	}
+	if len(rewriteFlag) > 0 {
+		rewriter, err := PrefixRewriter(rewriteFlag)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, WithURLRewriter(rewriter))
+	}
+	if *maxHeaderFlag != "" {
+		n, err := parseSize(*maxHeaderFlag)
+		if err != nil {
+			return fmt.Errorf("invalid -max-header-bytes: %v", err)
+		}
+		opts = append(opts, WithMaxResponseHeaderBytes(n))
+	}
+	switch *chunkRedirectFlag {
+	case "fail":
+	case "revalidate":
+		opts = append(opts, WithChunkRedirects(true))
+	default:
+		return fmt.Errorf("invalid -chunk-redirect %q", *chunkRedirectFlag)
+	}
+	strategy, err := ParseStrategy(*strategyFlag)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, WithStrategy(strategy))
+	if *samplesFlag != "" {
+		opts = append(opts, WithSpeedSamples(*samplesFlag, *sampleEveryFlag))
+	}
+	if *segmentFlag != "" {
+		size, err := parseSize(*segmentFlag)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("invalid -segment-size %q", *segmentFlag)
+		}
+		opts = append(opts, WithSegmentSize(size))
+	}
+	if *chunkSizesFlag != "" {
+		splitter, err := ParseSizeList(*chunkSizesFlag)
+		if err != nil {
+			return fmt.Errorf("invalid -chunk-sizes %q: %v", *chunkSizesFlag, err)
+		}
+		opts = append(opts, WithSplitter(splitter))
+	}
+	if *rampDownFlag > 0 {
+		opts = append(opts, WithRampDown(*rampDownFlag))
+	}
+	if *quotaFlag != "" {
+		quota, err := parseSize(*quotaFlag)
+		if err != nil {
+			return fmt.Errorf("invalid -byte-quota: %v", err)
+		}
+		opts = append(opts, WithByteQuota(quota))
+	}
+	if len(headerFlag) > 0 {
+		opts = append(opts, WithHeaders(headerFlag.header()))
+	}
+	if *maxRedirectsFlag != defaultMaxRedirects {
+		if *maxRedirectsFlag <= 0 {
+			return fmt.Errorf("invalid -max-redirects %d", *maxRedirectsFlag)
+		}
+		opts = append(opts, WithMaxRedirects(*maxRedirectsFlag))
+	}
+	if *trustRedirectsFlag {
+		opts = append(opts, WithTrustedRedirects(true))
+	}
+	if *methodFlag != "" || *dataFlag != "" {
+		var body []byte
+		if path, ok := strings.CutPrefix(*dataFlag, "@"); ok {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("invalid -data: %v", err)
+			}
+			body = data
+		} else if *dataFlag != "" {
+			body = []byte(*dataFlag)
+		}
+		method := *methodFlag
+		if method == "" {
+			method = http.MethodPost
+		}
+		opts = append(opts, WithMethod(method, body))
+	}
+	if *cookiesFlag {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, WithCookieJar(jar))
+	}
+	if len(proxyHeaderFlag) > 0 {
+		opts = append(opts, WithProxyConnectHeaders(proxyHeaderFlag.header()))
+	}
+	if *autoSuffixFlag {
+		opts = append(opts, WithAutoSuffix(true))
+	}
+	symlinks, err := ParseSymlinkPolicy(*symlinksFlag)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, WithSymlinkPolicy(symlinks))
+	if *lockFlag != "" {
+		lock, err := LoadLockfile(*lockFlag)
+		if errors.Is(err, fs.ErrNotExist) && *updateLockFlag {
+			lock, err = NewLockfile(), nil
+		}
+		if err != nil {
+			return err
+		}
+		opts = append(opts, WithLockfile(lock, *updateLockFlag))
+		if *updateLockFlag {
+			// written after failures too, keeping the downloads that completed
+			defer func() {
+				if err := lock.WriteFile(*lockFlag); err != nil {
+					log.Printf("Error writing %s: %v\n", *lockFlag, err)
+				}
+			}()
+		}
+	} else if *updateLockFlag {
+		return errors.New("-update-lock needs -lock")
+	}
+	if *limitRateFlag != "" {
+		rate, err := parseSize(*limitRateFlag)
+		if err != nil {
+			return fmt.Errorf("invalid -limit-rate: %v", err)
+		}
+		opts = append(opts, WithRateLimit(rate), WithProbeRateLimit(*limitProbesFlag), WithAuxRateLimit(*limitAuxFlag))
+	}
+	if *bandwidthShareFlag != 0 {
+		if *bandwidthShareFlag < 0 || *bandwidthShareFlag > 1 {
+			return fmt.Errorf("invalid -bandwidth-share %v, expected a share between 0 and 1", *bandwidthShareFlag)
+		}
+		opts = append(opts, WithBandwidthShare(*bandwidthShareFlag))
+	}
+	if len(hostLimitFlag) > 0 {
+		limits := make(map[string]int64)
+		for _, s := range hostLimitFlag {
+			host, rate, err := ParseHostLimit(s)
+			if err != nil {
+				return err
+			}
+			limits[host] = rate
+		}
+		opts = append(opts, WithHostRateLimits(limits), WithProbeRateLimit(*limitProbesFlag), WithAuxRateLimit(*limitAuxFlag))
+	}
+
+	if *retriesFlag > 0 {
+		opts = append(opts, WithRetryPolicy(RetryPolicy{Retries: *retriesFlag, Backoff: *retryBackoffFlag, MaxBackoff: *maxBackoffFlag}))
+	}
+	if *filenameHeaderFlag != "" {
+		opts = append(opts, WithFilenameHeader(*filenameHeaderFlag))
+	}
+	if *smallFileFlag != "" {
+		n, err := parseSize(*smallFileFlag)
+		if err != nil {
+			return fmt.Errorf("invalid -small-file: %v", err)
+		}
+		opts = append(opts, WithSmallFileThreshold(n))
+	}
+	diskFull, err := ParseDiskFullAction(*diskFullFlag)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, WithDiskFullAction(diskFull))
+	if *errorPageFlag != "" {
+		action, err := ParseErrorPageAction(*errorPageFlag)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, WithErrorPageDetection(action))
+	}
+	if *targetSpeedFlag != "" {
+		rate, err := parseSize(*targetSpeedFlag)
+		if err != nil || rate <= 0 {
+			return fmt.Errorf("invalid -target-speed %q", *targetSpeedFlag)
+		}
+		if *maxConcFlag <= 0 {
+			return fmt.Errorf("invalid -max-concurrency %d", *maxConcFlag)
+		}
+		opts = append(opts, WithAdaptiveConcurrency(AdaptiveConcurrency{
+			Max:      *maxConcFlag,
+			Cooldown: *adaptiveCooldownFlag,
+			Target:   rate,
+		}))
+	} else if *adaptiveConcFlag > 0 {
+		opts = append(opts, WithAdaptiveConcurrency(AdaptiveConcurrency{
+			Max:      *adaptiveConcFlag,
+			MinGain:  *adaptiveGainFlag,
+			MinDrop:  *adaptiveDropFlag,
+			Cooldown: *adaptiveCooldownFlag,
+		}))
+	}
+	if *probeTimeoutFlag > 0 {
+		opts = append(opts, WithProbeTimeout(*probeTimeoutFlag))
+	}
+	if !*contentMD5Flag {
+		opts = append(opts, WithContentMD5(false))
+	}
+	if *cacheFlag != "" {
+		ttl := *cacheTTLFlag
+		if *revalidateFlag {
+			ttl = 0
+		}
+		cache, err := LoadCache(*cacheFlag, ttl)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, WithCache(cache))
+	}
+	if *speedFlag != string(SpeedUseful) {
+		basis, err := ParseSpeedBasis(*speedFlag)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, WithSpeedBasis(basis))
+	}
+	if *stallTimeoutFlag > 0 {
+		opts = append(opts, WithStallTimeout(*stallTimeoutFlag, *stallReopenFlag))
+	}
+	if *spotCheckFlag > 0 {
+		size, err := parseSize(*spotSizeFlag)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("invalid -spot-check-size %q", *spotSizeFlag)
+		}
+		opts = append(opts, WithSpotCheck(*spotCheckFlag, size))
+	}
+	if *maxDNSFlag > 0 {
+		opts = append(opts, WithMaxDNSConcurrency(*maxDNSFlag))
+	}
+	if !*noDelayFlag {
+		opts = append(opts, WithTCPNoDelay(false))
+	}
+	if *recvBufferFlag != "" || *sendBufferFlag != "" {
+		var read, write int64
+		var err error
+		if *recvBufferFlag != "" {
+			if read, err = parseSize(*recvBufferFlag); err != nil || read <= 0 {
+				return fmt.Errorf("invalid -recv-buffer %q", *recvBufferFlag)
+			}
+		}
+		if *sendBufferFlag != "" {
+			if write, err = parseSize(*sendBufferFlag); err != nil || write <= 0 {
+				return fmt.Errorf("invalid -send-buffer %q", *sendBufferFlag)
+			}
+		}
+		opts = append(opts, WithSocketBuffers(int(read), int(write)))
+	}
+	if *interfaceFlag != "" {
+		ips, err := ResolveInterface(*interfaceFlag)
+		if err != nil {
+			return err
+		}
+		if *verboseFlag {
+			log.Printf("Sending from %v\n", ips)
+		}
+		opts = append(opts, WithLocalAddrs(ips...))
+	}
+	if *ipcFlag != "" {
+		if ipc := DialIPC(*ipcFlag); ipc != nil {
+			defer ipc.Close()
+			opts = append(opts, WithIPC(ipc))
+		}
+	}
+	directory, err := ParseDirectoryPolicy(*directoryFlag)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, WithDirectoryPolicy(directory))
+	listing := directory == DirectoryListing && *urlFlag != "" && *outputFlag == "" && directoryURL(*urlFlag)
+	var batchState *BatchState
+	if *batchStateFlag != "" {
+		if *batchFlag == "" && *indexFlag == "" && !listing {
+			return errors.New("-batch-state needs -batch, -index or a -directory listing")
+		}
+		var err error
+		if batchState, err = LoadBatchState(*batchStateFlag); err != nil {
+			return err
+		}
+	}
+	concurrency := *concurrencyFlag
+	if *totalConnsFlag > 0 {
+		opts = append(opts, WithScheduler(NewScheduler(*totalConnsFlag)))
+		concurrency = *totalConnsFlag
+	}
+	var report *BatchReport
+	if *manifestOutFlag != "" {
+		if *batchFlag == "" && *indexFlag == "" && !listing {
+			return errors.New("-manifest-out needs -batch, -index or a -directory listing")
+		}
+		report = NewBatchReport(*manifestOutFlag)
+	}
+	if listing {
+		jobs, err := FetchIndex(context.Background(), *urlFlag, ListingParser{}, opts...)
+		if err != nil {
+			return err
+		}
+		for i := range jobs {
+			jobs[i].Concurrency = concurrency
+		}
+		return finish(*urlFlag, runBatch(context.Background(), jobs, opts, batchState, report, *parallelFilesFlag, printPath))
+	}
+	if *indexFlag != "" {
+		jobs, err := FetchIndex(context.Background(), *indexFlag, ChecksumListParser{Algo: *indexAlgoFlag}, opts...)
+		if err != nil {
+			return err
+		}
+		for i := range jobs {
+			jobs[i].Concurrency = concurrency
+		}
+		return finish(*indexFlag, runBatch(context.Background(), jobs, opts, batchState, report, *parallelFilesFlag, printPath))
+	}
+
+	if *batchFlag != "" {
+		manifest, err := loadManifest(*batchFlag)
+		if err != nil {
+			return err
+		}
+		return finish(*batchFlag, runBatch(context.Background(), manifest.jobs(concurrency), opts, batchState, report, *parallelFilesFlag, printPath))
+	}
+
+	if *checksumFlag != "" {
+		opts = append(opts, WithChecksum(*checksumFlag))
+	}
+	if *pieceFlag != "" {
+		size, err := parseSize(*pieceFlag)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("invalid -piece-hashes %q", *pieceFlag)
+		}
+		if _, ok := hashes[*pieceAlgoFlag]; !ok || *pieceAlgoFlag == "md5" {
+			return fmt.Errorf("invalid -piece-algo %q", *pieceAlgoFlag)
+		}
+		opts = append(opts, WithPieceHashes(size, *pieceAlgoFlag))
+	}
+	if *xattrFlag {
+		opts = append(opts, WithXattr(true))
+	}
+	if *adaptiveFlag > 0 {
+		opts = append(opts, WithAdaptiveStream(*adaptiveFlag))
+	}
+	if *expectSizeFlag >= 0 {
+		opts = append(opts, WithExpectedSize(*expectSizeFlag))
+	}
+	if *continueFlag {
+		verify, err := ParseResumeVerify(*verifyResumeFlag)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, WithContinue(true), WithResumeVerification(verify, *resumePiecesFlag))
+	}
+	if *decompressFlag {
+		opts = append(opts, WithDecompress(true))
+	}
+	if *splitFlag > 0 {
+		opts = append(opts, WithSplitOutput(*splitFlag))
+	}
+	if len(alsoOutputFlag) > 0 {
+		opts = append(opts, WithExtraOutputs(alsoOutputFlag...))
+	}
+	if *watchFlag > 0 {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if *watchLogFlag {
+			opts = append(opts, WithLogUnchanged(true))
+		}
+		return Watch(ctx, *urlFlag, *outputFlag, *concurrencyFlag, *watchFlag, opts...)
+	}
+	downloader := NewDownloader(*urlFlag, *outputFlag, *concurrencyFlag, opts...)
+	if err := finish(*urlFlag, downloader.Download()); err != nil {
+		if *recoveryHintFlag {
+			logRecovery(downloader, os.Args)
+		}
+		return err
+	}
+	if printPath != nil {
+		printPath(downloader)
+	}
+	return nil
+}
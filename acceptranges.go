@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// rangeSupport is what a response says about range requests
+type rangeSupport int
+
+const (
+	rangesUnknown rangeSupport = iota // no Accept-Ranges or only units other than bytes
+	rangesBytes                       // Accept-Ranges lists bytes
+	rangesNone                        // Accept-Ranges: none
+)
+
+// parseAcceptRanges reads the Accept-Ranges of header, case-insensitively
+// and over every value and comma-separated unit. bytes anywhere means byte
+// ranges are supported, none alone that no ranges are, anything else
+// leaves it unknown: the header is optional, a server may honour ranges it
+// does not advertise
+func parseAcceptRanges(header http.Header) rangeSupport {
+	support := rangesUnknown
+	for _, value := range header.Values("Accept-Ranges") {
+		for _, unit := range strings.Split(value, ",") {
+			switch strings.ToLower(strings.TrimSpace(unit)) {
+			case "bytes":
+				return rangesBytes
+			case "none":
+				support = rangesNone
+			}
+		}
+	}
+	return support
+}
+
+// WithRangeProbe sets whether a HEAD response that neither advertises byte
+// ranges nor declines them with Accept-Ranges: none is followed by a ranged
+// GET finding out whether the server honours ranges. Enabled by default,
+// disabled such a server gets a single stream
+func WithRangeProbe(enabled bool) Option {
+	return func(d *Downloader) {
+		d.rangeProbe = enabled
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseAcceptRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string // the Accept-Ranges values, nil for no header
+		want   rangeSupport
+	}{
+		{name: "missing", want: rangesUnknown},
+		{name: "empty", values: []string{""}, want: rangesUnknown},
+		{name: "bytes", values: []string{"bytes"}, want: rangesBytes},
+		{name: "mixed case bytes", values: []string{"Bytes"}, want: rangesBytes},
+		{name: "upper case bytes", values: []string{" BYTES "}, want: rangesBytes},
+		{name: "none", values: []string{"none"}, want: rangesNone},
+		{name: "mixed case none", values: []string{"None"}, want: rangesNone},
+		{name: "other unit", values: []string{"items"}, want: rangesUnknown},
+		{name: "comma list with bytes", values: []string{"items, bytes"}, want: rangesBytes},
+		{name: "comma list without bytes", values: []string{"items,pages"}, want: rangesUnknown},
+		{name: "several values with bytes", values: []string{"none", "bytes"}, want: rangesBytes},
+		{name: "several values without bytes", values: []string{"items", "none"}, want: rangesNone},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		for _, v := range tt.values {
+			header.Add("Accept-Ranges", v)
+		}
+		if got := parseAcceptRanges(header); got != tt.want {
+			t.Errorf("%s: parseAcceptRanges(%q) = %v, want %v", tt.name, tt.values, got, tt.want)
+		}
+	}
+}
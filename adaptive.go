@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// adaptiveMinGain is the speedup a single stream needs over the parallel
+// workers to replace them
+const adaptiveMinGain = 1.25
+
+// runAdaptive runs fn over the chunks like runChunks, but once the adaptive
+// window elapsed it pauses the workers, measures a single stream and, when
+// that is clearly faster than the workers were, cancels them and finishes
+// the remaining bytes over one connection. fn must continue a chunk after
+// the bytes it already wrote when called for it again
+func (d *Downloader) runAdaptive(ctx context.Context, fn func(ctx context.Context, i int, r [2]int64) error) error {
+	parallel, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	decided := make(chan bool, 1)
+	go func() {
+		switched := false
+		defer func() { decided <- switched }()
+		from := atomic.LoadInt64(&d.transferred)
+		start := d.clock.Now()
+		select {
+		case <-d.clock.After(d.adaptWindow):
+		case <-done:
+			return
+		}
+		parallelRate := float64(atomic.LoadInt64(&d.transferred)-from) / d.clock.Now().Sub(start).Seconds()
+
+		// hold the workers with a gate of our own, so a Pause of the
+		// caller is not undone once the measurement ends
+		d.hold.Pause()
+		singleRate, err := d.measureThroughput(ctx, 1)
+		d.hold.Resume()
+		if err != nil {
+			log.Printf("Could not measure a single stream, keeping %d connections: %v\n", d.concurrency, err)
+			return
+		}
+		log.Printf("Throughput with %d connections: %.2f MiB/s, with a single stream: %.2f MiB/s\n", d.concurrency, parallelRate/(1<<20), singleRate/(1<<20))
+		if singleRate <= 0 || singleRate < parallelRate*adaptiveMinGain {
+			log.Printf("Keeping %d connections\n", d.concurrency)
+			return
+		}
+		log.Println("A single stream is faster, finishing with one connection")
+		switched = true
+		cancel()
+	}()
+
+	err := d.runChunks(parallel, fn)
+	close(done)
+	if !<-decided {
+		return err
+	}
+	// the chunks the workers completed are skipped by fn, the interrupted
+	// ones continue where they stopped
+	concurrency, adaptive := d.concurrency, d.adaptive
+	d.concurrency, d.adaptive = 1, nil
+	defer func() { d.concurrency, d.adaptive = concurrency, adaptive }()
+	return d.runChunks(ctx, fn)
+}
+
+// WithAdaptiveStream compares the parallel throughput during the first
+// window of the download with a brief single stream measurement and
+// finishes with a single stream when that is at least 25% faster, e.g. on
+// servers throttling every connection against a shared cap. Bytes already
+// written are kept. It applies to the writeat strategy, 0 disables it
+func WithAdaptiveStream(window time.Duration) Option {
+	return func(d *Downloader) {
+		d.adaptWindow = window
+	}
+}
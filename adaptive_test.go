@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveStreamKeepsPause(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	srv := serveContent(t, content)
+	clock := NewManualClock(time.Unix(1700000000, 0))
+	output := filepath.Join(t.TempDir(), "output")
+	d := NewDownloader(srv.URL, output, 4, WithAdaptiveStream(time.Second), WithClock(clock))
+
+	// paused by the caller before the workers start, so only the
+	// measurement of the adaptive window transfers anything
+	d.Pause()
+	done := make(chan error, 1)
+	go func() { done <- d.Download() }()
+	for deadline := time.Now().Add(5 * time.Second); clock.Waiting() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the adaptive window was not started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Second)
+
+	select {
+	case err := <-done:
+		t.Fatalf("Download() = %v while paused, want it held until Resume", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if !d.pause.Paused() {
+		t.Fatal("the measurement resumed the download paused by the caller")
+	}
+
+	d.Resume()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Download() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Download() did not finish after Resume")
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+		t.Errorf("output holds %d bytes, want the %d of the file", len(got), len(content))
+	}
+}
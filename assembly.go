@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// offsetWriterAt shifts the writes of a download to its region of a shared output
+type offsetWriterAt struct {
+	w    io.WriterAt
+	base int64
+}
+
+// WriteAt writes p at off of the region
+func (o *offsetWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	return o.w.WriteAt(p, o.base+off)
+}
+
+// verifyRegion checks the region of the shared output against the
+// expected checksum, which needs the output to be readable
+func (d *Downloader) verifyRegion(sum *checksum) error {
+	if sum != nil {
+		ra, ok := d.writerAt.(io.ReaderAt)
+		if !ok {
+			return errors.New("cannot verify the checksum of a region of an output that cannot be read")
+		}
+		log.Printf("Verifying %s checksum...\n", sum.algo)
+		h := sum.newHash()
+		if _, err := io.Copy(h, io.NewSectionReader(ra, d.baseOffset, d.size)); err != nil {
+			return err
+		}
+		if err := sum.verify(h.Sum(nil)); err != nil {
+			return err
+		}
+	}
+	if d.pieceSize > 0 {
+		log.Println("Piece hashes are not written for a region of a shared output")
+	}
+	log.Println("Download completed")
+	return nil
+}
+
+// WithWriterAt writes the file into w at baseOffset instead of into the
+// output file, always with the writeat strategy. Several downloads can share
+// w as long as their regions do not overlap, which is up to the caller, as
+// is syncing and closing w. An Assembly checks the regions for them
+func WithWriterAt(w io.WriterAt, baseOffset int64) Option {
+	return func(d *Downloader) {
+		d.writerAt = w
+		d.baseOffset = baseOffset
+	}
+}
+
+// Assembly builds one file from several downloads running in parallel, each
+// writing its own region, e.g. the parts of a container image fetched from
+// different sources:
+//
+//	a, err := NewAssembly("disk.img", 3<<30)
+//	region, err := a.Region(0, 1<<30)
+//	go NewDownloader(part1, "", 4, region).Download()
+//	...
+//	err = a.Close()
+//
+// Regions may not overlap or extend past the size of the file. The gaps
+// between regions keep the zero bytes the file was sized with
+type Assembly struct {
+	file    *os.File
+	size    int64
+	mu      sync.Mutex
+	regions [][2]int64 // the first and last byte of every region
+}
+
+// NewAssembly creates or truncates the file at path and sizes it to size bytes
+func NewAssembly(path string, size int64) (*Assembly, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Assembly{file: file, size: size}, nil
+}
+
+// Region reserves size bytes at offset and returns the option making a
+// download write them. The download must have exactly size bytes, which is
+// checked after its probe like WithExpectedSize
+func (a *Assembly) Region(offset, size int64) (Option, error) {
+	if offset < 0 || size <= 0 || offset+size > a.size {
+		return nil, fmt.Errorf("region of %d bytes at %d does not fit in %d bytes", size, offset, a.size)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := [2]int64{offset, offset + size - 1}
+	for _, other := range a.regions {
+		if r[0] <= other[1] && other[0] <= r[1] {
+			return nil, fmt.Errorf("region %d-%d overlaps region %d-%d", r[0], r[1], other[0], other[1])
+		}
+	}
+	a.regions = append(a.regions, r)
+	return func(d *Downloader) {
+		WithWriterAt(a.file, offset)(d)
+		WithExpectedSize(size)(d)
+	}, nil
+}
+
+// Close syncs the file to disk and closes it, once every download finished
+func (a *Assembly) Close() error {
+	if err := a.file.Sync(); err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// defaultName is the output name when neither the server nor the url
+// suggest one
+const defaultName = "download"
+
+// cleanName reduces a file name suggested by a server or a url to its last
+// path element without control characters, shortened by safeName. It
+// returns "" for a name that is empty or only dots
+func cleanName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = path.Base(strings.TrimSpace(name))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if strings.Trim(name, ".") == "" || name == "/" {
+		return ""
+	}
+	return safeName(name)
+}
+
+// headerName returns the file name carried by the value of a header such
+// as X-Filename, which may be quoted or percent-encoded
+func headerName(value string) string {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		value = unescaped
+	}
+	return cleanName(value)
+}
+
+// dispositionName returns the filename of a Content-Disposition header,
+// preferring the RFC 5987 filename* form, which mime decodes into filename
+func dispositionName(value string) string {
+	_, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return ""
+	}
+	return cleanName(params["filename"])
+}
+
+// urlName returns the last element of the path of rawURL
+func urlName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return cleanName(u.Path)
+}
+
+// outputName picks the output name for a response: the header configured
+// with WithFilenameHeader, then Content-Disposition, then the url, unless
+// it looks like a directory and the directory policy decides
+func (d *Downloader) outputName(header http.Header) (string, error) {
+	if d.filenameHeader != "" {
+		if name := headerName(header.Get(d.filenameHeader)); name != "" {
+			return name, nil
+		}
+	}
+	if name := dispositionName(header.Get("Content-Disposition")); name != "" {
+		return name, nil
+	}
+	if directoryURL(d.url) {
+		return d.directoryName()
+	}
+	if name := urlName(d.url); name != "" {
+		return name, nil
+	}
+	return defaultName, nil
+}
+
+// deriveOutput names the output from a HEAD request when none was given.
+// When the HEAD request fails the name comes from the url alone
+func (d *Downloader) deriveOutput(ctx context.Context) error {
+	header := http.Header{}
+	req, err := d.newRequest(ctx, "HEAD")
+	if err != nil {
+		return err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		d.debugf("HEAD for the output name failed: %v\n", err)
+	} else {
+		d.wrapBody(resp.Body, probeRequest).Close()
+		if resp.StatusCode == http.StatusOK {
+			header = resp.Header
+		}
+	}
+	if d.output, err = d.outputName(header); err != nil {
+		return err
+	}
+	log.Printf("Saving to %s\n", d.output)
+	return nil
+}
+
+// WithFilenameHeader names an output left empty after the value of the
+// response header name, e.g. X-Filename for an API that does not send
+// Content-Disposition, before falling back to Content-Disposition and the
+// last element of the url path
+func WithFilenameHeader(name string) Option {
+	return func(d *Downloader) {
+		d.filenameHeader = name
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// probeBandwidth measures the throughput the download can reach with its
+// connections and caps its rate to the share of WithBandwidthShare of it,
+// unless a lower rate limit is set already
+func (d *Downloader) probeBandwidth(ctx context.Context) error {
+	n := d.concurrency
+	if n > estimateMaxConcurrent {
+		n = estimateMaxConcurrent
+	}
+	rate, err := d.measureThroughput(ctx, n)
+	if err != nil {
+		return fmt.Errorf("probing the bandwidth: %w", err)
+	}
+	limit := int64(rate * d.bandwidthShare)
+	if limit <= 0 {
+		return nil
+	}
+	if d.limiter != nil && int64(d.limiter.rate) <= limit {
+		log.Printf("Measured %.2f MiB/s, keeping the lower rate limit of %.2f MiB/s\n", rate/(1<<20), d.limiter.rate/(1<<20))
+		return nil
+	}
+	log.Printf("Measured %.2f MiB/s, limiting the download to %.0f%% of it, %.2f MiB/s\n", rate/(1<<20), d.bandwidthShare*100, float64(limit)/(1<<20))
+	d.limiter = newRateLimiter(limit)
+	return nil
+}
+
+// WithBandwidthShare limits the download to share, between 0 and 1, of the
+// bandwidth measured just before it starts, e.g. 0.5 to leave half of a
+// shared link of unknown capacity to others. The measurement fetches up to
+// 16MB of the file with the concurrency of the download for at most one
+// second and discards it, so it costs that much data and time up front.
+// It sees the capacity of the moment, which traffic starting or stopping
+// later changes, and a link faster than 16MB per second is measured
+// shorter and less accurately. It needs range requests and a known size,
+// a download without them is not limited. Probes are not throttled by
+// the limit, and a lower limit of WithRateLimit is kept
+func WithBandwidthShare(share float64) Option {
+	return func(d *Downloader) {
+		d.bandwidthShare = share
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// Manifest describes a batch of downloads. Every entry inherits the
+// defaults and may override any of them:
+//
+//	{
+//	  "defaults": {"concurrency": 8, "headers": {"Authorization": "Bearer ..."}},
+//	  "downloads": [
+//	    {"url": "https://example.com/a.iso", "output": "a.iso", "checksum": "sha256:..."},
+//	    {"url": "https://example.com/b.tar", "output": "b.tar", "concurrency": 2}
+//	  ]
+//	}
+//
+// Headers are merged by name, so an entry only replaces the headers it sets
+type Manifest struct {
+	Defaults  JobOptions    `json:"defaults"`
+	Downloads []DownloadJob `json:"downloads"`
+}
+
+// JobOptions are the settings that can be given as batch defaults or per download
+type JobOptions struct {
+	Concurrency int               `json:"concurrency,omitempty"` // 0 inherits, or estimates when nothing is set
+	Headers     map[string]string `json:"headers,omitempty"`     // extra request headers
+}
+
+// DownloadJob is a single download of a batch
+type DownloadJob struct {
+	URL      string `json:"url"`                // the url of the file to download
+	Output   string `json:"output"`             // the output filename
+	Checksum string `json:"checksum,omitempty"` // the expected checksum as algo:hex
+	JobOptions
+}
+
+// loadManifest reads and validates a batch manifest
+func loadManifest(path string) (*Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	dec := json.NewDecoder(file)
+	dec.DisallowUnknownFields()
+	var m Manifest
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %v", path, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// validate checks every entry of the manifest and reports all problems at once
+func (m *Manifest) validate() error {
+	var errs []error
+	if err := m.Defaults.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("defaults: %w", err))
+	}
+	if len(m.Downloads) == 0 {
+		errs = append(errs, errors.New("no downloads"))
+	}
+	outputs := make(map[string]int)
+	for i, job := range m.Downloads {
+		if err := job.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("downloads[%d]: %w", i, err))
+		}
+		if j, ok := outputs[job.Output]; ok && job.Output != "" {
+			errs = append(errs, fmt.Errorf("downloads[%d]: output %q already used by downloads[%d]", i, job.Output, j))
+		}
+		outputs[job.Output] = i
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks the options of a manifest entry or of the defaults
+func (o *JobOptions) validate() error {
+	if o.Concurrency < 0 {
+		return fmt.Errorf("negative concurrency %d", o.Concurrency)
+	}
+	for name := range o.Headers {
+		if _, _, err := parseHeader(name + ":"); err != nil {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	return nil
+}
+
+// validate checks a single download of the manifest
+func (j *DownloadJob) validate() error {
+	u, err := url.Parse(j.URL)
+	if err != nil {
+		return err
+	}
+	if !supportedScheme(u.Scheme) {
+		return fmt.Errorf("url %q must be http, https, file or data", j.URL)
+	}
+	if j.Output == "" {
+		return errors.New("missing output")
+	}
+	if j.Checksum != "" {
+		if _, err := parseChecksum(j.Checksum); err != nil {
+			return err
+		}
+	}
+	return j.JobOptions.validate()
+}
+
+// jobs returns the downloads with the defaults applied, falling back to
+// the given concurrency when neither the entry nor the defaults set one
+func (m *Manifest) jobs(concurrency int) []DownloadJob {
+	if m.Defaults.Concurrency > 0 {
+		concurrency = m.Defaults.Concurrency
+	}
+	jobs := make([]DownloadJob, len(m.Downloads))
+	for i, job := range m.Downloads {
+		if job.Concurrency == 0 {
+			job.Concurrency = concurrency
+		}
+		headers := make(map[string]string)
+		for k, v := range m.Defaults.Headers {
+			headers[k] = v
+		}
+		for k, v := range job.Headers {
+			headers[k] = v
+		}
+		job.Headers = headers
+		jobs[i] = job
+	}
+	return jobs
+}
+
+// options returns the downloader options specific to the job
+func (j *DownloadJob) options() []Option {
+	var opts []Option
+	if len(j.Headers) > 0 {
+		header := http.Header{}
+		for k, v := range j.Headers {
+			header.Set(k, v)
+		}
+		opts = append(opts, WithHeaders(header))
+	}
+	if j.Checksum != "" {
+		opts = append(opts, WithChecksum(j.Checksum))
+	}
+	return opts
+}
+
+// defaultSmallFile is the size up to which the files of a batch are
+// fetched over a single connection
+const defaultSmallFile = 1 << 20
+
+// sharedClient returns the client the downloads of a batch share, built
+// from the transport options of opts, so that sequential downloads from a
+// host reuse its keep-alive connections instead of dialing anew. It returns
+// nil when opts bring their own client
+func sharedClient(opts []Option) *http.Client {
+	d := &Downloader{}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.client != nil {
+		return nil
+	}
+	return d.transport.newClient(d.debugf)
+}
+
+// runBatch downloads the jobs with the shared options followed by the
+// per-job ones, one after another or up to parallel at a time. A failed
+// download does not stop the batch, the failures are reported together at
+// the end. With a state, downloads it records as done are skipped,
+// interrupted ones continued, and the progress is recorded in it. The
+// downloads share one client, and files up to 1MB are fetched over a single
+// connection unless opts set another WithSmallFileThreshold, so a batch of
+// small files from a host runs over one reused connection. With a report,
+// the outcome of every download is written to it when the batch ends. done
+// is called with every completed download in the order of the jobs.
+//
+// Parallel downloads that share a Scheduler from opts share its connection
+// budget: while several wait for connections the scheduler hands them out
+// in turn, so each holds about an equal share, and the connections of a
+// download that finished go to the workers of those still running
+func runBatch(ctx context.Context, jobs []DownloadJob, opts []Option, state *BatchState, report *BatchReport, parallel int, done func(*Downloader)) error {
+	shared := []Option{WithSmallFileThreshold(defaultSmallFile)}
+	if client := sharedClient(opts); client != nil {
+		shared = append(shared, WithHTTPClient(client))
+	}
+	opts = append(shared, opts...)
+	if state != nil {
+		if err := state.reconcile(jobs); err != nil {
+			return err
+		}
+	}
+	if report != nil {
+		defer func() {
+			if err := report.write(); err != nil {
+				log.Printf("Error writing the batch report %s: %v\n", report.path, err)
+			}
+		}()
+	}
+	if parallel < 1 {
+		parallel = 1
+	}
+	var mu sync.Mutex // guards failed and the completed downloads
+	failed := 0
+	// done sees the completed downloads in the order of the jobs, also
+	// when they complete out of order in parallel: the downloads wait in
+	// completed until every earlier job is resolved
+	completed := make([]*Downloader, len(jobs))
+	resolved := make([]bool, len(jobs))
+	next := 0
+	resolve := func(i int, d *Downloader) {
+		mu.Lock()
+		defer mu.Unlock()
+		completed[i], resolved[i] = d, true
+		for ; next < len(jobs) && resolved[next]; next++ {
+			if done != nil && completed[next] != nil {
+				done(completed[next])
+			}
+			completed[next] = nil
+		}
+	}
+	run := func(i int, job DownloadJob) {
+		var completedDownload *Downloader
+		defer func() { resolve(i, completedDownload) }()
+		jobOpts := append(append([]Option(nil), opts...), job.options()...)
+		if state != nil {
+			resume, complete := state.resume(job)
+			if complete {
+				log.Printf("[%d/%d] %s is already done\n", i+1, len(jobs), job.Output)
+				if report != nil {
+					e := state.entry(job.Output)
+					path := e.Path
+					if path == "" {
+						path = job.Output
+					}
+					report.skipped(i, job, path, e.Size)
+				}
+				return
+			}
+			jobOpts = append(append(jobOpts, resume...), state.track(job))
+			state.update(job.Output, func(e *BatchEntry) { e.Status, e.Error = jobRunning, "" })
+		}
+		log.Printf("[%d/%d] %s -> %s\n", i+1, len(jobs), job.URL, job.Output)
+		d := NewDownloader(job.URL, job.Output, job.Concurrency, jobOpts...)
+		err := d.DownloadContext(ctx)
+		if report != nil {
+			report.record(i, job, d, err)
+		}
+		if err != nil {
+			log.Printf("[%d/%d] Error downloading %s: %v\n", i+1, len(jobs), job.URL, err)
+			mu.Lock()
+			failed++
+			mu.Unlock()
+			if state != nil {
+				// an interrupted batch stays running, to be continued
+				status := jobFailed
+				if ctx.Err() != nil {
+					status = jobRunning
+				}
+				state.update(job.Output, func(e *BatchEntry) { e.Status, e.Error = status, err.Error() })
+			}
+			return
+		}
+		if state != nil {
+			state.update(job.Output, func(e *BatchEntry) {
+				e.Status, e.Path, e.Error = jobDone, d.Output(), ""
+				if info, err := os.Stat(d.Output()); err == nil {
+					e.Size = info.Size()
+				}
+			})
+		}
+		completedDownload = d
+	}
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			run(i, job)
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d downloads failed", failed, len(jobs))
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDownloadJobSchemes(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{url: "http://example.com/a.iso", ok: true},
+		{url: "https://example.com/a.iso", ok: true},
+		{url: "HTTPS://example.com/a.iso", ok: true},
+		{url: "file:///srv/a.iso", ok: true},
+		{url: "data:text/plain,hello", ok: true},
+		{url: "ftp://example.com/a.iso"},
+		{url: "example.com/a.iso"},
+	}
+	for _, tt := range tests {
+		job := DownloadJob{URL: tt.url, Output: "out"}
+		if err := job.validate(); (err == nil) != tt.ok {
+			t.Errorf("validate() of %q = %v, want ok %v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestBatchFileAndDataURLs(t *testing.T) {
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	source := filepath.Join(dir, "source")
+	if err := os.WriteFile(source, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	jobs := []DownloadJob{
+		{URL: "file://" + filepath.ToSlash(source), Output: filepath.Join(dir, "from-file")},
+		{URL: "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(content), Output: filepath.Join(dir, "from-data")},
+	}
+	for i := range jobs {
+		jobs[i].Concurrency = 2
+		if err := jobs[i].validate(); err != nil {
+			t.Fatalf("validate() of %q = %v", jobs[i].URL, err)
+		}
+	}
+	if err := runBatch(t.Context(), jobs, nil, nil, nil, 2, nil); err != nil {
+		t.Fatalf("runBatch() = %v", err)
+	}
+	for _, job := range jobs {
+		if got, _ := os.ReadFile(job.Output); !bytes.Equal(got, content) {
+			t.Errorf("%s holds %d bytes, want the %d of the source", job.Output, len(got), len(content))
+		}
+	}
+}
+
+func TestParallelBatchChunkFiles(t *testing.T) {
+	// two files of the same size, so their chunks have the same indexes, on
+	// slow servers, so the downloads overlap
+	contents := [][]byte{bytes.Repeat([]byte("0123456789"), 1000), bytes.Repeat([]byte("abcdefghij"), 1000)}
+	var urls []string
+	for _, content := range contents {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(10 * time.Millisecond)
+			http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(content))
+		}))
+		t.Cleanup(srv.Close)
+		urls = append(urls, srv.URL)
+	}
+
+	t.Run("tempfiles", func(t *testing.T) {
+		dir := t.TempDir()
+		jobs := []DownloadJob{
+			{URL: urls[0], Output: filepath.Join(dir, "first"), Concurrency: 4},
+			{URL: urls[1], Output: filepath.Join(dir, "second"), Concurrency: 4},
+		}
+		opts := []Option{WithStrategy(StrategyTempFiles), WithSegmentSize(1000)}
+		if err := runBatch(t.Context(), jobs, opts, nil, nil, 2, nil); err != nil {
+			t.Fatalf("runBatch() = %v", err)
+		}
+		for i, job := range jobs {
+			if got, _ := os.ReadFile(job.Output); !bytes.Equal(got, contents[i]) {
+				t.Errorf("%s holds %.20q..., want %.20q...", job.Output, got, contents[i])
+			}
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != len(jobs) {
+			t.Errorf("%s holds %d files, want only the outputs", dir, len(entries))
+		}
+	})
+
+	t.Run("ordered files", func(t *testing.T) {
+		dir := t.TempDir()
+		outputs := make([]bytes.Buffer, len(urls))
+		errs := make(chan error, len(urls))
+		for i, u := range urls {
+			go func() {
+				d := NewDownloader(u, filepath.Join(dir, "output"+string(rune('0'+i))), 4, WithStrategy(StrategyTempFiles), WithSegmentSize(1000))
+				errs <- d.DownloadTo(t.Context(), func(int64) (io.Writer, error) { return &outputs[i], nil })
+			}()
+		}
+		for range urls {
+			if err := <-errs; err != nil {
+				t.Fatalf("DownloadTo() = %v", err)
+			}
+		}
+		for i := range outputs {
+			if got := outputs[i].Bytes(); !bytes.Equal(got, contents[i]) {
+				t.Errorf("download %d wrote %.20q..., want %.20q...", i, got, contents[i])
+			}
+		}
+	})
+}
+
+func TestBatchReusesConnections(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	var accepted int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(content))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&accepted, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	var jobs []DownloadJob
+	for i := 0; i < 20; i++ {
+		jobs = append(jobs, DownloadJob{URL: srv.URL + "/" + strconv.Itoa(i), Output: filepath.Join(dir, strconv.Itoa(i)), Concurrency: 4})
+	}
+	if err := runBatch(t.Context(), jobs, nil, nil, nil, 1, nil); err != nil {
+		t.Fatalf("runBatch() = %v", err)
+	}
+	for _, job := range jobs {
+		if got, _ := os.ReadFile(job.Output); !bytes.Equal(got, content) {
+			t.Errorf("%s holds %d bytes, want %d", job.Output, len(got), len(content))
+		}
+	}
+	if got := atomic.LoadInt32(&accepted); got != 1 {
+		t.Errorf("the server accepted %d connections for %d small files, want 1", got, len(jobs))
+	}
+}
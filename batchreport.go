@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The statuses of a file in a batch report
+const (
+	reportDownloaded = "downloaded"
+	reportSkipped    = "skipped"
+	reportFailed     = "failed"
+)
+
+// BatchReport is the manifest of what a batch did, written once the batch
+// ends, also when downloads failed or it was interrupted, as JSON:
+//
+//	{
+//	  "files": [
+//	    {"url": "https://example.com/a.iso", "output": "a.iso", "size": 1048576, "sha256": "9f86d0...", "status": "downloaded", "duration": 1.52, "retries": 1},
+//	    {"url": "https://example.com/b.tar", "output": "b.tar", "size": 0, "status": "failed", "duration": 0.31, "retries": 3, "error": "unexpected status 503 Service Unavailable"}
+//	  ],
+//	  "totals": {"files": 2, "downloaded": 1, "skipped": 0, "failed": 1, "bytes": 1048576, "duration": 1.83, "retries": 4}
+//	}
+//
+// The files are listed in the order of the jobs, whatever order parallel
+// downloads completed in. Durations are in seconds. Files that were not
+// attempted because the batch was interrupted are not listed
+type BatchReport struct {
+	Files  []ReportEntry `json:"files"`
+	Totals ReportTotals  `json:"totals"`
+
+	mu      sync.Mutex          // guards the entries, added by parallel downloads
+	entries map[int]ReportEntry // by the index of the job, Files lists them in that order
+	path    string
+	started time.Time
+}
+
+// ReportEntry is the outcome of one download of a batch
+type ReportEntry struct {
+	URL      string  `json:"url"`              // the url of the file
+	Output   string  `json:"output"`           // the output written, which differs from the job with WithAutoSuffix
+	Size     int64   `json:"size"`             // the size of the output, or of the file as probed when the download failed
+	SHA256   string  `json:"sha256,omitempty"` // the hex sha256 of the output when it was verified against one
+	Status   string  `json:"status"`           // downloaded, skipped when it was done already, or failed
+	Duration float64 `json:"duration"`         // the seconds the download took
+	Retries  int     `json:"retries"`          // the chunk requests retried
+	Error    string  `json:"error,omitempty"`  // why the download failed
+}
+
+// ReportTotals sums the entries of a batch report
+type ReportTotals struct {
+	Files      int     `json:"files"`      // the files listed
+	Downloaded int     `json:"downloaded"` // the files downloaded
+	Skipped    int     `json:"skipped"`    // the files that were already done
+	Failed     int     `json:"failed"`     // the files that failed
+	Bytes      int64   `json:"bytes"`      // the bytes of the files downloaded
+	Duration   float64 `json:"duration"`   // the seconds the whole batch took
+	Retries    int     `json:"retries"`    // the chunk requests retried over all files
+}
+
+// NewBatchReport returns a report to be written to path when the batch ends
+func NewBatchReport(path string) *BatchReport {
+	return &BatchReport{Files: []ReportEntry{}, entries: make(map[int]ReportEntry), path: path, started: time.Now()}
+}
+
+// skipped records the i-th job, which the batch state had completed before
+func (r *BatchReport) skipped(i int, job DownloadJob, path string, size int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[i] = ReportEntry{URL: job.URL, Output: path, Size: size, SHA256: jobSHA256(job), Status: reportSkipped}
+}
+
+// record records the outcome err of the download d of the i-th job
+func (r *BatchReport) record(i int, job DownloadJob, d *Downloader, err error) {
+	res := d.Result()
+	e := ReportEntry{
+		URL:      job.URL,
+		Output:   d.Output(),
+		Size:     res.Size,
+		Status:   reportDownloaded,
+		Duration: res.Elapsed.Seconds(),
+		Retries:  res.Retries,
+	}
+	switch {
+	case err != nil:
+		e.Status, e.Error = reportFailed, err.Error()
+	case d.Cached() || d.Declined():
+		e.Status = reportSkipped
+	default:
+		e.SHA256 = jobSHA256(job)
+	}
+	if err == nil {
+		if info, statErr := os.Stat(d.Output()); statErr == nil {
+			e.Size = info.Size()
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[i] = e
+}
+
+// jobSHA256 returns the hex sha256 a job was verified against, if any
+func jobSHA256(job DownloadJob) string {
+	algo, digest, ok := strings.Cut(job.Checksum, ":")
+	if !ok || !strings.EqualFold(algo, "sha256") {
+		return ""
+	}
+	return strings.ToLower(digest)
+}
+
+// write sums the entries and writes the report to its path
+func (r *BatchReport) write() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	jobs := make([]int, 0, len(r.entries))
+	for i := range r.entries {
+		jobs = append(jobs, i)
+	}
+	sort.Ints(jobs)
+	r.Files = make([]ReportEntry, 0, len(jobs))
+	for _, i := range jobs {
+		r.Files = append(r.Files, r.entries[i])
+	}
+	r.Totals = ReportTotals{Files: len(r.Files), Duration: time.Since(r.started).Seconds()}
+	for _, e := range r.Files {
+		switch e.Status {
+		case reportDownloaded:
+			r.Totals.Downloaded++
+			r.Totals.Bytes += e.Size
+		case reportSkipped:
+			r.Totals.Skipped++
+		case reportFailed:
+			r.Totals.Failed++
+		}
+		r.Totals.Retries += e.Retries
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(r.path), "."+filepath.Base(r.path)+".tmp")
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// The states of a download in the batch state
+const (
+	jobPending = "pending"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// BatchState records the progress of a batch, so that a batch run again
+// with the same list skips what completed and continues what did not. It
+// is stored as JSON, keyed by the output of every download:
+//
+//	{
+//	  "jobs": {
+//	    "a.iso": {"url": "https://example.com/a.iso", "status": "done", "size": 1048576, "path": "a.iso"},
+//	    "b.tar": {"url": "https://example.com/b.tar", "status": "failed", "size": 4096, "error": "unexpected status 503 Service Unavailable"}
+//	  }
+//	}
+//
+// The file is rewritten whenever a download changes state
+type BatchState struct {
+	Jobs map[string]*BatchEntry `json:"jobs"`
+
+	mu     sync.Mutex
+	saving sync.Mutex // serializes saves of parallel downloads, so the last state written is the latest
+	path   string
+}
+
+// BatchEntry is the state of a download of the batch
+type BatchEntry struct {
+	URL    string `json:"url"`             // the url the output is downloaded from
+	Status string `json:"status"`          // pending, running, done or failed
+	Size   int64  `json:"size,omitempty"`  // the size of the file once probed, 0 when unknown
+	Path   string `json:"path,omitempty"`  // the output written, which differs from the key with WithAutoSuffix
+	Error  string `json:"error,omitempty"` // why the last attempt failed
+}
+
+// LoadBatchState reads the batch state at path, or starts an empty one
+// when it does not exist yet
+func LoadBatchState(path string) (*BatchState, error) {
+	s := &BatchState{Jobs: map[string]*BatchEntry{}, path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid batch state %s: %v", path, err)
+	}
+	if s.Jobs == nil {
+		s.Jobs = map[string]*BatchEntry{}
+	}
+	return s, nil
+}
+
+// reconcile brings the state in line with the jobs of the list: downloads
+// no longer listed are dropped, new ones and ones whose url changed start
+// as pending
+func (s *BatchState) reconcile(jobs []DownloadJob) error {
+	s.mu.Lock()
+	listed := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		listed[job.Output] = true
+		if e := s.Jobs[job.Output]; e == nil || e.URL != job.URL {
+			if e != nil {
+				log.Printf("The url of %s changed, downloading it again\n", job.Output)
+			}
+			s.Jobs[job.Output] = &BatchEntry{URL: job.URL, Status: jobPending}
+		}
+	}
+	for output := range s.Jobs {
+		if !listed[output] {
+			log.Printf("Dropping %s from the batch state, it is no longer listed\n", output)
+			delete(s.Jobs, output)
+		}
+	}
+	s.mu.Unlock()
+	return s.save()
+}
+
+// resume returns the options continuing job, and whether it is complete
+// already: a completed output that still has its size is skipped, and the
+// partial output of an interrupted or failed download is continued when it
+// is shorter than the file, which is the case for a single stream. The
+// writeat strategy sizes the output up front, so its partial outputs
+// cannot be told from complete ones and start over
+func (s *BatchState) resume(job DownloadJob) (opts []Option, done bool) {
+	e := s.entry(job.Output)
+	path := e.Path
+	if path == "" {
+		path = job.Output
+	}
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		return nil, false
+	case e.Status == jobDone && info.Size() == e.Size:
+		return nil, true
+	case e.Status != jobDone && e.Status != jobPending && info.Size() > 0 && info.Size() < e.Size:
+		log.Printf("Continuing the partial %s at byte %d\n", path, info.Size())
+		return []Option{WithContinue(true)}, false
+	}
+	return nil, false
+}
+
+// entry returns a copy of the entry of the download saved to output
+func (s *BatchState) entry(output string) BatchEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.Jobs[output]
+}
+
+// track returns the option recording the size of job once probed. It
+// must come after the options of the job, since it wraps their WithConfirm
+func (s *BatchState) track(job DownloadJob) Option {
+	return func(d *Downloader) {
+		next := d.confirmFn
+		d.confirmFn = func(info URLInfo) (bool, error) {
+			if info.Size >= 0 {
+				s.update(job.Output, func(e *BatchEntry) { e.Size = info.Size })
+			}
+			if next == nil {
+				return true, nil
+			}
+			return next(info)
+		}
+	}
+}
+
+// update changes the entry of output with fn and saves the state
+func (s *BatchState) update(output string, fn func(e *BatchEntry)) {
+	s.mu.Lock()
+	fn(s.Jobs[output])
+	s.mu.Unlock()
+	if err := s.save(); err != nil {
+		log.Printf("Error saving the batch state %s: %v\n", s.path, err)
+	}
+}
+
+// save writes the state to a temporary file renamed over the previous one,
+// so that an interruption never leaves a truncated state behind
+func (s *BatchState) save() error {
+	s.saving.Lock()
+	defer s.saving.Unlock()
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
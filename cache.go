@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Cache remembers for every url the output last downloaded or found
+// unchanged, so that batch and watch runs over many files skip even the
+// request for an output checked within the TTL. The output is trusted to be
+// unchanged while it still has the size and modification time recorded
+// with it, which trades strict freshness for speed: a resource the server
+// changed within the TTL is only picked up once it expires. It is stored as
+// JSON, keyed by url:
+//
+//	{
+//	  "entries": {
+//	    "https://example.com/a.iso": {
+//	      "output": "a.iso",
+//	      "size": 1048576,
+//	      "mtime": "2024-05-01T10:00:00Z",
+//	      "etag": "\"5f2a\"",
+//	      "modified": "Wed, 01 May 2024 09:59:00 GMT",
+//	      "checked": "2024-05-02T08:30:00Z"
+//	    }
+//	  }
+//	}
+//
+// The file is rewritten whenever an entry changes
+type Cache struct {
+	Entries map[string]*CacheEntry `json:"entries"`
+
+	mu   sync.Mutex
+	path string
+	ttl  time.Duration
+}
+
+// CacheEntry is what the cache knows about a url
+type CacheEntry struct {
+	Output   string    `json:"output"`             // the output the url was saved to
+	Size     int64     `json:"size"`               // the size of the output when checked
+	MTime    time.Time `json:"mtime"`              // the modification time of the output when checked
+	ETag     string    `json:"etag,omitempty"`     // the ETag the server reported
+	Modified string    `json:"modified,omitempty"` // the Last-Modified the server reported
+	Checked  time.Time `json:"checked"`            // when the server was last asked
+}
+
+// LoadCache reads the cache at path, or starts an empty one when it does
+// not exist yet. Entries checked within ttl are trusted without asking the
+// server, a ttl of 0 revalidates every entry
+func LoadCache(path string, ttl time.Duration) (*Cache, error) {
+	c := &Cache{Entries: map[string]*CacheEntry{}, path: path, ttl: ttl}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("invalid cache %s: %v", path, err)
+	}
+	if c.Entries == nil {
+		c.Entries = map[string]*CacheEntry{}
+	}
+	return c, nil
+}
+
+// fresh reports whether output, saved from url, was checked within the TTL
+// and is still the file that was checked
+func (c *Cache) fresh(url, output string) bool {
+	e := c.entry(url, output)
+	if e == nil || time.Since(e.Checked) >= c.ttl {
+		return false
+	}
+	info, err := os.Stat(output)
+	return err == nil && info.Size() == e.Size && info.ModTime().Equal(e.MTime)
+}
+
+// record notes that output holds the version of url with the given
+// validators as of now
+func (c *Cache) record(url, output, etag, modified string) {
+	info, err := os.Stat(output)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Entries[url] = &CacheEntry{
+		Output:   output,
+		Size:     info.Size(),
+		MTime:    info.ModTime(),
+		ETag:     etag,
+		Modified: modified,
+		Checked:  time.Now(),
+	}
+	if err := c.save(); err != nil {
+		log.Printf("Could not save the cache %s: %v\n", c.path, err)
+	}
+}
+
+// save writes the cache to a temporary file renamed over the old one, so
+// an interrupted write never leaves a truncated cache. c.mu must be held
+func (c *Cache) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(c.path), "."+filepath.Base(c.path)+".tmp")
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// entry returns the entry for url when it is for output, nil otherwise
+func (c *Cache) entry(url, output string) *CacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.Entries[url]; e != nil && e.Output == output {
+		entry := *e
+		return &entry
+	}
+	return nil
+}
+
+// Cached reports whether the download was skipped because the WithCache
+// cache held a fresh entry for it, in which case Download returned nil
+// without touching the output
+func (d *Downloader) Cached() bool {
+	return d.cached
+}
+
+// WithCache skips the download when c holds a fresh entry for the url and
+// the output, and records the output once downloaded
+func WithCache(c *Cache) Option {
+	return func(d *Downloader) {
+		d.cache = c
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned when the downloaded file does not match the expected checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// hashes maps the supported checksum algorithms to their constructors
+var hashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// checksum is an expected digest of the downloaded file
+type checksum struct {
+	algo string // the name of the hash algorithm
+	sum  []byte // the expected digest
+}
+
+// parseChecksum parses a checksum given as algo:hex, e.g. sha256:ab12...
+func parseChecksum(s string) (*checksum, error) {
+	algo, digest, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, fmt.Errorf("checksum %q must be given as algo:hex", s)
+	}
+	algo = strings.ToLower(algo)
+	newHash, ok := hashes[algo]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algo)
+	}
+	sum, err := hex.DecodeString(digest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s checksum: %v", algo, err)
+	}
+	if len(sum) != newHash().Size() {
+		return nil, fmt.Errorf("invalid %s checksum: expected %d bytes, got %d", algo, newHash().Size(), len(sum))
+	}
+	return &checksum{algo: algo, sum: sum}, nil
+}
+
+// newHash returns a new hash for the checksum algorithm
+func (c *checksum) newHash() hash.Hash {
+	return hashes[c.algo]()
+}
+
+// verify compares a computed digest with the expected one
+func (c *checksum) verify(got []byte) error {
+	if !bytes.Equal(got, c.sum) {
+		return fmt.Errorf("%w: expected %s:%x, got %s:%x", ErrChecksumMismatch, c.algo, c.sum, c.algo, got)
+	}
+	return nil
+}
+
+// verifyFile hashes the file at path and compares it with the expected digest
+func (c *checksum) verifyFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	h := c.newHash()
+	if _, err := io.Copy(h, file); err != nil {
+		return err
+	}
+	return c.verify(h.Sum(nil))
+}
+
+// WithChecksum verifies the output against a checksum given as algo:hex
+// once the download completes. Supported algorithms are md5, sha1, sha256
+// and sha512
+func WithChecksum(sum string) Option {
+	return func(d *Downloader) {
+		d.checksum = sum
+	}
+}
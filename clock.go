@@ -0,0 +1,165 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and runs the timers behind the speeds, samples,
+// backoff, rate limits, stall detection, adaptive concurrency and the
+// checks of Watch of a Downloader.
+// The real clock is used unless WithClock replaces it, e.g. by a
+// ManualClock in a test
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer started by Clock.AfterFunc, which *time.Timer implements
+type Timer interface {
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+// realClock is the Clock of the time package
+type realClock struct{}
+
+// Now returns time.Now
+func (realClock) Now() time.Time { return time.Now() }
+
+// After returns time.After
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// AfterFunc returns time.AfterFunc
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// ManualClock is a Clock whose time only moves when Advance is called, so
+// that tests get the same speeds, waits and stalls on every run:
+//
+//	clock := NewManualClock(time.Unix(0, 0))
+//	d := NewDownloader(url, output, 4, WithClock(clock))
+//	go d.Download()
+//	...
+//	clock.Advance(time.Second)
+//
+// Timers that come due fire in the order of their deadlines, on the
+// goroutine calling Advance
+type ManualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*manualTimer // the timers that have not fired or been stopped
+}
+
+// manualTimer is a timer of a ManualClock, sending on ch or calling f
+type manualTimer struct {
+	clock *ManualClock
+	at    time.Time
+	ch    chan time.Time
+	f     func()
+}
+
+// NewManualClock returns a ManualClock starting at now
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now returns the current time of the clock
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel receiving the time once the clock advanced by d
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	t := &manualTimer{clock: c, ch: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t.ch
+}
+
+// AfterFunc calls f once the clock advanced by d
+func (c *ManualClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &manualTimer{clock: c, f: f}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d and fires the timers that came due
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	var due []*manualTimer
+	kept := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(now) {
+			kept = append(kept, t)
+		} else {
+			due = append(due, t)
+		}
+	}
+	c.timers = kept
+	c.mu.Unlock()
+	sort.SliceStable(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+	for _, t := range due {
+		if t.f != nil {
+			t.f()
+		} else {
+			t.ch <- t.at
+		}
+	}
+}
+
+// Waiting returns the number of timers that have not fired yet, so a test
+// can wait for the download to start the timer it means to fire
+func (c *ManualClock) Waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// remove drops t from the pending timers, reporting whether it was pending.
+// c.mu must be held
+func (c *ManualClock) remove(t *manualTimer) bool {
+	for i, p := range c.timers {
+		if p == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Reset makes the timer fire once the clock advanced by d from now
+func (t *manualTimer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	active := c.remove(t)
+	t.at = c.now.Add(d)
+	c.timers = append(c.timers, t)
+	return active
+}
+
+// Stop keeps the timer from firing
+func (t *manualTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.remove(t)
+}
+
+// WithClock replaces the real clock of the downloader, for tests that
+// advance the time themselves with a ManualClock. It controls the timing of
+// the download itself, as listed for Clock. What is recorded for people and
+// other programs keeps the real time: the request timings of
+// WithTraceTiming and WithHARRecorder, the TTL of WithCache, the duration of
+// a batch report and the times of IPC events and extended attributes, as do
+// the network timeouts of the transport
+func WithClock(clock Clock) Option {
+	return func(d *Downloader) {
+		d.clock = clock
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// waitTimer waits until a timer of clock is pending
+func waitTimer(clock *ManualClock) {
+	for clock.Waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// The speed samples average the bytes over their interval, so bursts within
+// one interval are smoothed into a single rate. With a ManualClock the rates
+// come out the same on every run
+func ExampleManualClock() {
+	dir, _ := os.MkdirTemp("", "samples")
+	defer os.RemoveAll(dir)
+	samples := filepath.Join(dir, "samples.csv")
+	clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewDownloader("http://example.com/file", "", 1, WithClock(clock), WithSpeedSamples(samples, time.Second))
+	stop, err := d.startSampling()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// the bytes received every half second
+	for _, burst := range []int64{1 << 20, 0, 3 << 20, 1 << 20, 0, 0} {
+		waitTimer(clock)
+		atomic.AddInt64(&d.transferred, burst)
+		clock.Advance(500 * time.Millisecond)
+	}
+	waitTimer(clock)
+	stop()
+
+	data, _ := os.ReadFile(samples)
+	fmt.Print(strings.ReplaceAll(string(data), "2024-01-01T", ""))
+	// Output:
+	// time,bytes_per_second,active_connections,bytes_transferred
+	// 00:00:01Z,1048576,0,1048576
+	// 00:00:02Z,4194304,0,5242880
+	// 00:00:03Z,0,0,5242880
+	// 00:00:03Z,0,0,5242880
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// AdaptiveConcurrency tunes the controller that adds and removes workers
+// while a segmented download runs. The zero value of a field selects its
+// default
+type AdaptiveConcurrency struct {
+	Max      int           // the most workers, 16 by default
+	MinGain  float64       // the throughput gain an added worker must bring to be kept, 0.10 by default
+	MinDrop  float64       // the throughput drop at a settled level that removes a worker, 0.25 by default
+	Cooldown time.Duration // how long every level runs before it is judged, 2s by default
+	Target   int64         // the throughput in bytes per second to reach, 0 for the most the workers bring
+}
+
+const (
+	defaultAdaptiveMax      = 16
+	defaultAdaptiveGain     = 0.10
+	defaultAdaptiveDrop     = 0.25
+	defaultAdaptiveCooldown = 2 * time.Second
+)
+
+// withDefaults returns cfg with the defaults for its zero fields
+func (cfg AdaptiveConcurrency) withDefaults() AdaptiveConcurrency {
+	if cfg.Max <= 0 {
+		cfg.Max = defaultAdaptiveMax
+	}
+	if cfg.MinGain <= 0 {
+		cfg.MinGain = defaultAdaptiveGain
+	}
+	if cfg.MinDrop <= 0 {
+		cfg.MinDrop = defaultAdaptiveDrop
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = defaultAdaptiveCooldown
+	}
+	return cfg
+}
+
+// concurrencyController climbs from the initial level one worker at a time
+// while every added worker improves the throughput by at least MinGain and
+// steps back from the first one that does not. Once settled it only removes
+// a worker when the throughput falls by MinDrop and only climbs again when
+// it rises by MinGain, so small fluctuations around the optimum do not make
+// it add and remove workers over and over. Every level runs for Cooldown
+// before it is judged. It only depends on the times and byte counts passed
+// to next, so it can be driven by a simulated throughput.
+//
+// With a Target it seeks that throughput instead: it adds a worker after
+// every level that stays below the target, whether or not the last one
+// helped, and holds once the target is met. It never removes workers
+type concurrencyController struct {
+	AdaptiveConcurrency
+	level      int       // the workers wanted
+	from       int       // the level before the increase being judged, 0 when not judging one
+	probe      bool      // whether to climb once the base of the level is measured
+	base       float64   // the throughput in bytes per second the level is compared with, 0 to measure it
+	since      time.Time // the start of the current window
+	sinceBytes int64     // the bytes transferred at its start
+	unmet      bool      // whether Max workers run below the Target
+}
+
+// newConcurrencyController starts a controller at level workers, at time now
+// with bytes transferred
+func newConcurrencyController(cfg AdaptiveConcurrency, level int, now time.Time, bytes int64) *concurrencyController {
+	cfg = cfg.withDefaults()
+	if level > cfg.Max {
+		level = cfg.Max
+	}
+	return &concurrencyController{AdaptiveConcurrency: cfg, level: level, probe: true, since: now, sinceBytes: bytes}
+}
+
+// reset starts a new window at now, e.g. after the download was paused
+func (c *concurrencyController) reset(now time.Time, bytes int64) {
+	c.since, c.sinceBytes = now, bytes
+}
+
+// next returns the workers wanted given the bytes transferred by now
+func (c *concurrencyController) next(now time.Time, bytes int64) int {
+	elapsed := now.Sub(c.since)
+	if elapsed < c.Cooldown {
+		return c.level
+	}
+	rate := float64(bytes-c.sinceBytes) / elapsed.Seconds()
+	c.reset(now, bytes)
+	if c.Target > 0 {
+		c.seek(rate)
+		return c.level
+	}
+	switch {
+	case c.from > 0 && rate >= c.base*(1+c.MinGain):
+		// the added worker paid off, try another one
+		c.base = rate
+		c.raise()
+	case c.from > 0:
+		// back to the previous level, whose base is still c.base
+		c.level, c.from = c.from, 0
+	case c.base == 0:
+		c.base = rate
+		if c.probe {
+			c.raise()
+		}
+	case rate < c.base*(1-c.MinDrop) && c.level > 1:
+		c.level--
+		c.base, c.probe = 0, false
+	case rate >= c.base*(1+c.MinGain):
+		// e.g. competing traffic ended, more workers may help again
+		c.base = rate
+		c.raise()
+	}
+	return c.level
+}
+
+// raise adds a worker to be judged after the next window, up to Max
+func (c *concurrencyController) raise() {
+	c.from = 0
+	if c.level < c.Max {
+		c.from = c.level
+		c.level++
+	}
+}
+
+// seek adds a worker while the rate of the level is below the Target
+func (c *concurrencyController) seek(rate float64) {
+	c.base, c.from = rate, 0
+	c.unmet = rate < float64(c.Target) && c.level >= c.Max
+	if rate < float64(c.Target) && c.level < c.Max {
+		c.level++
+	}
+}
+
+// shed reports whether a worker that just finished a segment should stop
+// because the controller wants fewer than the active workers
+func shed(active, target *int32) bool {
+	for {
+		n := atomic.LoadInt32(active)
+		if n <= atomic.LoadInt32(target) || n <= 1 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(active, n, n-1) {
+			return true
+		}
+	}
+}
+
+// controlConcurrency adjusts the target of the workers of runChunks until
+// the queue is empty or the tail ramp-down retires workers. It starts new
+// workers with spawn in slots taken from free and leaves removing workers
+// to shed
+func (d *Downloader) controlConcurrency(ctx context.Context, queue chan int, active, target *int32, free chan int, spawn func(worker int)) {
+	c := newConcurrencyController(*d.adaptive, int(atomic.LoadInt32(target)), d.clock.Now(), atomic.LoadInt64(&d.transferred))
+	warned := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.clock.After(c.Cooldown / 4):
+		}
+		if len(queue) == 0 || atomic.LoadInt32(&d.retired) > 0 {
+			return
+		}
+		if d.paused() {
+			c.reset(d.clock.Now(), atomic.LoadInt64(&d.transferred))
+			continue
+		}
+		n := int32(c.next(d.clock.Now(), atomic.LoadInt64(&d.transferred)))
+		if old := atomic.SwapInt32(target, n); old != n {
+			log.Printf("Adaptive concurrency: %d workers, was %d, at %.2f MiB/s\n", n, old, c.base/(1<<20))
+		}
+		if c.unmet && !warned {
+			warned = true
+			log.Printf("Adaptive concurrency: %d workers reach %.2f MiB/s, below the target of %.2f MiB/s\n", n, c.base/(1<<20), float64(c.Target)/(1<<20))
+		}
+		// only this goroutine takes from free
+		for atomic.LoadInt32(active) < n && len(free) > 0 {
+			atomic.AddInt32(active, 1)
+			spawn(<-free)
+		}
+	}
+}
+
+// WithAdaptiveConcurrency adds and removes workers during the download
+// while that improves the throughput, as tuned by cfg, starting from the
+// concurrency of the downloader. Workers are added and removed between
+// segments, so without a segment size or splitter the file is queued in
+// segments of 4MB. It applies to the writeat and tempfiles strategies.
+//
+// With a Target it adds workers until the target throughput is met or Max
+// workers run. More workers cannot get past the bandwidth of the server or
+// the link, or a rate limit, so the target may stay out of reach; that is
+// logged once and the download goes on with Max workers
+func WithAdaptiveConcurrency(cfg AdaptiveConcurrency) Option {
+	return func(d *Downloader) {
+		cfg = cfg.withDefaults()
+		d.adaptive = &cfg
+	}
+}
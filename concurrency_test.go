@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConcurrencyControllerSimulated(t *testing.T) {
+	const mib = 1 << 20
+	// capped gives every worker perWorker bytes per second up to limit in total
+	capped := func(perWorker, limit float64) func(int, time.Duration) float64 {
+		return func(workers int, _ time.Duration) float64 {
+			return min(float64(workers)*perWorker, limit)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		cfg   AdaptiveConcurrency
+		start int
+		rate  func(workers int, elapsed time.Duration) float64 // the simulated throughput of workers
+		want  int                                              // the level the controller settles at
+		unmet bool
+	}{
+		{name: "climbs to the link limit", cfg: AdaptiveConcurrency{}, start: 2, rate: capped(mib, 6*mib), want: 6},
+		{name: "stops at the optimum", cfg: AdaptiveConcurrency{}, start: 1, rate: func(workers int, _ time.Duration) float64 {
+			// every worker past 4 costs the server more than it brings
+			if workers <= 4 {
+				return float64(workers) * mib
+			}
+			return 4*mib - float64(workers-4)*mib/2
+		}, want: 4},
+		{name: "small gains do not count", cfg: AdaptiveConcurrency{MinGain: 0.5}, start: 1, rate: capped(mib, 2.5*mib), want: 2},
+		{name: "never above Max", cfg: AdaptiveConcurrency{Max: 8}, start: 2, rate: capped(mib, 100*mib), want: 8},
+		{name: "starts at most at Max", cfg: AdaptiveConcurrency{Max: 3}, start: 10, rate: capped(mib, 100*mib), want: 3},
+		{name: "steps back when the link slows down", cfg: AdaptiveConcurrency{}, start: 4, rate: func(workers int, elapsed time.Duration) float64 {
+			if elapsed > time.Minute {
+				// competing traffic takes most of the link
+				return min(float64(workers)*mib, mib)
+			}
+			return min(float64(workers)*mib, 4*mib)
+		}, want: 3},
+		{name: "seeks the target", cfg: AdaptiveConcurrency{Target: 5 * mib}, start: 1, rate: capped(mib, 100*mib), want: 5},
+		{name: "target out of reach", cfg: AdaptiveConcurrency{Target: 10 * mib, Max: 6}, start: 1, rate: capped(mib, 3*mib), want: 6, unmet: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewManualClock(time.Unix(0, 0))
+			c := newConcurrencyController(tt.cfg, tt.start, clock.Now(), 0)
+			// polled every quarter cooldown, as controlConcurrency does
+			step := c.Cooldown / 4
+			var bytes float64
+			var levels []int
+			for i := 0; i < 400; i++ {
+				bytes += tt.rate(c.level, time.Duration(i)*step) * step.Seconds()
+				clock.Advance(step)
+				level := c.next(clock.Now(), int64(bytes))
+				if level < 1 || level > c.Max {
+					t.Fatalf("level %d after %v, want 1 to %d", level, time.Duration(i+1)*step, c.Max)
+				}
+				levels = append(levels, level)
+			}
+			// settled for the last quarter
+			for _, level := range levels[300:] {
+				if level != tt.want {
+					t.Fatalf("settled at %d, want %d; levels %v", level, tt.want, levels[300:])
+				}
+			}
+			if c.unmet != tt.unmet {
+				t.Errorf("unmet = %v, want %v", c.unmet, tt.unmet)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import "errors"
+
+// URLInfo is what the probe learned about the file, passed to the
+// WithConfirm hook before any byte of the file is fetched
+type URLInfo struct {
+	URL          string // the url given to the downloader
+	ResolvedURL  string // the url after redirects, which the chunks are fetched from
+	Size         int64  // the size of the file in bytes, -1 when unknown
+	ContentType  string // the Content-Type reported by the server
+	ETag         string // the ETag reported by the server
+	LastModified string // the Last-Modified reported by the server
+	AcceptRanges bool   // whether the file is downloaded in parallel ranges
+}
+
+// errDeclined aborts a download the WithConfirm hook declined
+var errDeclined = errors.New("download declined")
+
+// confirm asks the WithConfirm hook whether to go on with the download
+func (d *Downloader) confirm(ranges bool) error {
+	if d.confirmFn == nil {
+		return nil
+	}
+	info := URLInfo{
+		URL:          d.url,
+		ResolvedURL:  d.resolved,
+		Size:         -1,
+		ContentType:  d.contentType,
+		ETag:         d.etag,
+		LastModified: d.modified,
+		AcceptRanges: ranges,
+	}
+	if ranges {
+		info.Size = d.size
+	}
+	ok, err := d.confirmFn(info)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errDeclined
+	}
+	return nil
+}
+
+// Declined reports whether the WithConfirm hook declined the download, in
+// which case Download returned nil without creating the output
+func (d *Downloader) Declined() bool {
+	return d.declined
+}
+
+// WithConfirm calls fn after the probe and before any byte of the file is
+// fetched, e.g. to ask the user about a large file or enforce a policy on
+// the Content-Type. Returning false aborts the download without an error,
+// see Declined, and returning an error aborts the download with it
+func WithConfirm(fn func(info URLInfo) (bool, error)) Option {
+	return func(d *Downloader) {
+		d.confirmFn = fn
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ConnectTo sends the connections for one endpoint to another address,
+// like curl's --connect-to. Only the dialed address changes: the url, the
+// Host header and the TLS server name stay those of the request, so a new
+// backend can be tried before a DNS cutover. Empty fields match any host or
+// port, or keep the original one on the target side
+type ConnectTo struct {
+	Host, Port     string // the requested endpoint to match
+	ToHost, ToPort string // the address to connect to instead
+}
+
+// ParseConnectTo parses a mapping given as host1:port1:host2:port2, with
+// IPv6 addresses in brackets
+func ParseConnectTo(s string) (ConnectTo, error) {
+	var fields []string
+	rest := s
+	for i := 0; i < 4; i++ {
+		var field string
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return ConnectTo{}, fmt.Errorf("invalid -connect-to %q: unclosed bracket", s)
+			}
+			field, rest = rest[1:end], rest[end+1:]
+		} else if j := strings.Index(rest, ":"); j >= 0 && i < 3 {
+			field, rest = rest[:j], rest[j:]
+		} else {
+			field, rest = rest, ""
+		}
+		fields = append(fields, field)
+		if i < 3 {
+			if !strings.HasPrefix(rest, ":") {
+				return ConnectTo{}, fmt.Errorf("invalid -connect-to %q: expected host1:port1:host2:port2", s)
+			}
+			rest = rest[1:]
+		}
+	}
+	if rest != "" {
+		return ConnectTo{}, fmt.Errorf("invalid -connect-to %q: expected host1:port1:host2:port2", s)
+	}
+	return ConnectTo{Host: fields[0], Port: fields[1], ToHost: fields[2], ToPort: fields[3]}, nil
+}
+
+// match reports whether the rule applies to the given host and port
+func (c ConnectTo) match(host, port string) bool {
+	return (c.Host == "" || strings.EqualFold(c.Host, host)) && (c.Port == "" || c.Port == port)
+}
+
+// dialAddress returns the address to dial for the requested address, the
+// first matching -connect-to rule winning. It is applied on every dial, so
+// redirects to a mapped endpoint are routed the same way
+func (o *transportOptions) dialAddress(address string, debugf func(string, ...interface{})) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	for _, c := range o.connectTo {
+		if !c.match(host, port) {
+			continue
+		}
+		toHost, toPort := host, port
+		if c.ToHost != "" {
+			toHost = c.ToHost
+		}
+		if c.ToPort != "" {
+			toPort = c.ToPort
+		}
+		mapped := net.JoinHostPort(toHost, toPort)
+		debugf("Connecting to %s instead of %s\n", mapped, address)
+		return mapped
+	}
+	return address
+}
+
+// WithConnectTo adds -connect-to rules, tried in order
+func WithConnectTo(rules ...ConnectTo) Option {
+	return func(d *Downloader) {
+		d.transport.connectTo = append(d.transport.connectTo, rules...)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+)
+
+// Content-MD5 (RFC 1864) is the base64 MD5 of the body it comes with. It
+// is checked automatically on the single stream download, whose body is the
+// whole file. A ranged response carries the digest of its range at most, so
+// for ranged downloads the digest reported by the probe is only logged and
+// can be passed as -checksum md5:<hex> to be verified
+
+// parseContentMD5 decodes a Content-MD5 header value
+func parseContentMD5(value string) ([]byte, error) {
+	sum, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-MD5 %q: %v", value, err)
+	}
+	if len(sum) != md5.Size {
+		return nil, fmt.Errorf("invalid Content-MD5 %q: expected %d bytes, got %d", value, md5.Size, len(sum))
+	}
+	return sum, nil
+}
+
+// contentMD5 is the digest a single stream response promises for its body
+type contentMD5 struct {
+	want []byte
+	h    hash.Hash
+}
+
+// checkContentMD5 returns the check of the Content-MD5 of resp, nil when
+// the header is absent, malformed or turned off
+func (d *Downloader) checkContentMD5(resp *http.Response) *contentMD5 {
+	value := resp.Header.Get("Content-MD5")
+	if value == "" || d.noContentMD5 {
+		return nil
+	}
+	want, err := parseContentMD5(value)
+	if err != nil {
+		log.Printf("Ignoring %v\n", err)
+		return nil
+	}
+	return &contentMD5{want: want, h: md5.New()}
+}
+
+// reader hashes body as it is read
+func (c *contentMD5) reader(body io.Reader) io.Reader {
+	return io.TeeReader(body, c.h)
+}
+
+// verify compares the hashed body with the digest of the header
+func (c *contentMD5) verify() error {
+	log.Println("Verifying Content-MD5...")
+	sum := &checksum{algo: "md5", sum: c.want}
+	if err := sum.verify(c.h.Sum(nil)); err != nil {
+		return fmt.Errorf("Content-MD5: %w", err)
+	}
+	return nil
+}
+
+// reportContentMD5 logs the Content-MD5 the probe reported for a ranged
+// download, which is not verified
+func (d *Downloader) reportContentMD5() {
+	if d.contentMD5 == "" || d.checksum != "" || d.noContentMD5 {
+		return
+	}
+	sum, err := parseContentMD5(d.contentMD5)
+	if err != nil {
+		log.Printf("Ignoring %v\n", err)
+		return
+	}
+	log.Printf("The server reports Content-MD5 %x, which is not verified for ranged downloads; pass -checksum md5:%x to verify it\n", sum, sum)
+}
+
+// WithContentMD5 turns the automatic Content-MD5 check of single stream
+// downloads on or off, it is on by default
+func WithContentMD5(verify bool) Option {
+	return func(d *Downloader) {
+		d.noContentMD5 = !verify
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrRangeMismatch is returned when a 206 response to a chunk request holds
+// a different range than requested, e.g. from a broken proxy or cache
+var ErrRangeMismatch = errors.New("response range does not match the request")
+
+// ContentRange is a parsed Content-Range response header
+type ContentRange struct {
+	Start int64 // the offset of the first byte of the response
+	End   int64 // the offset of the last byte of the response
+	Size  int64 // the size of the whole resource, -1 when the server sends *
+}
+
+// parseContentRange parses a Content-Range header such as "bytes 0-99/1000"
+func parseContentRange(s string) (ContentRange, error) {
+	cr := ContentRange{Size: -1}
+	spec, ok := strings.CutPrefix(strings.TrimSpace(s), "bytes ")
+	if !ok {
+		return cr, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	rng, size, ok := strings.Cut(spec, "/")
+	if !ok {
+		return cr, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	if size != "*" {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || n < 0 {
+			return cr, fmt.Errorf("invalid Content-Range %q", s)
+		}
+		cr.Size = n
+	}
+	start, end, ok := strings.Cut(rng, "-")
+	if !ok {
+		return cr, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	var err1, err2 error
+	cr.Start, err1 = strconv.ParseInt(start, 10, 64)
+	cr.End, err2 = strconv.ParseInt(end, 10, 64)
+	if err1 != nil || err2 != nil || cr.Start < 0 || cr.End < cr.Start || (cr.Size >= 0 && cr.End >= cr.Size) {
+		return cr, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	return cr, nil
+}
+
+// checkResponseRange checks that the Content-Range of a 206 response to a
+// request for r starts at r[0] and does not end past r[1], since its body is
+// written at the requested offset and shifted bytes would pass unnoticed
+func checkResponseRange(header string, r [2]int64) error {
+	cr, err := parseContentRange(header)
+	if err != nil {
+		return fmt.Errorf("%w: %v for range %v", ErrRangeMismatch, err, r)
+	}
+	if cr.Start != r[0] || cr.End > r[1] {
+		return fmt.Errorf("%w: server sent bytes %d-%d for range %v", ErrRangeMismatch, cr.Start, cr.End, r)
+	}
+	return nil
+}
+
+// DownloadRange fetches bytes start to end, inclusive, of url with a single
+// ranged GET and returns them with the Content-Range of the response. A
+// negative end asks for the rest of the file. The server may send less than
+// asked at the end of the file, but the response must be a 206 starting at
+// start whose body matches its Content-Range. The options configure the
+// request like those of NewDownloader, e.g. WithHeaders
+func DownloadRange(ctx context.Context, url string, start, end int64, opts ...Option) ([]byte, ContentRange, error) {
+	d := NewDownloader(url, "", 1, opts...)
+	return d.downloadRange(ctx, start, end)
+}
+
+// downloadRange implements DownloadRange
+func (d *Downloader) downloadRange(ctx context.Context, start, end int64) ([]byte, ContentRange, error) {
+	if start < 0 || (end >= 0 && end < start) {
+		return nil, ContentRange{}, fmt.Errorf("invalid range %d-%d", start, end)
+	}
+	req, err := d.newRequest(ctx, "GET")
+	if err != nil {
+		return nil, ContentRange{}, err
+	}
+	if end < 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	}
+	// an encoded body would not match the Content-Range
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, ContentRange{}, err
+	}
+	body := d.wrapBody(resp.Body, chunkRequest)
+	defer body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, ContentRange{}, statusError(resp)
+	}
+	cr, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, ContentRange{}, err
+	}
+	if cr.Start != start || (end >= 0 && cr.End > end) {
+		return nil, cr, fmt.Errorf("server sent range %d-%d for %s", cr.Start, cr.End, req.Header.Get("Range"))
+	}
+	want := cr.End - cr.Start + 1
+	buf, err := io.ReadAll(io.LimitReader(body, want+1))
+	if err != nil {
+		return nil, cr, err
+	}
+	if int64(len(buf)) != want {
+		return nil, cr, fmt.Errorf("received %d bytes for range %d-%d", len(buf), cr.Start, cr.End)
+	}
+	return buf, cr, nil
+}
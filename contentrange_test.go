@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   ContentRange
+		err    bool
+	}{
+		{header: "bytes 0-99/1000", want: ContentRange{Start: 0, End: 99, Size: 1000}},
+		{header: " bytes 900-999/1000 ", want: ContentRange{Start: 900, End: 999, Size: 1000}},
+		{header: "bytes 0-99/*", want: ContentRange{Start: 0, End: 99, Size: -1}},
+		{header: "bytes */1000", err: true},
+		{header: "bytes */*", err: true},
+		{header: "items 0-99/1000", err: true},
+		{header: "0-99/1000", err: true},
+		{header: "bytes 0-1000/1000", err: true},
+		{header: "bytes 0-999/1000", want: ContentRange{Start: 0, End: 999, Size: 1000}},
+		{header: "bytes 100-99/1000", err: true},
+		{header: "bytes -1-99/1000", err: true},
+		{header: "bytes 0-99", err: true},
+		{header: "bytes 0-99/-1", err: true},
+		{header: "", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseContentRange(tt.header)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseContentRange(%q) = %+v, want an error", tt.header, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseContentRange(%q) = %+v, %v, want %+v", tt.header, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheckResponseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		r      [2]int64
+		ok     bool
+	}{
+		{header: "bytes 100-199/1000", r: [2]int64{100, 199}, ok: true},
+		{header: "bytes 100-149/1000", r: [2]int64{100, 199}, ok: true}, // short, the rest is fetched again
+		{header: "bytes 101-199/1000", r: [2]int64{100, 199}},
+		{header: "bytes 99-199/1000", r: [2]int64{100, 199}},
+		{header: "bytes 100-200/1000", r: [2]int64{100, 199}},
+		{header: "bytes */1000", r: [2]int64{100, 199}},
+	}
+	for _, tt := range tests {
+		err := checkResponseRange(tt.header, tt.r)
+		if tt.ok && err != nil {
+			t.Errorf("checkResponseRange(%q, %v) = %v, want nil", tt.header, tt.r, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrRangeMismatch) {
+			t.Errorf("checkResponseRange(%q, %v) = %v, want %v", tt.header, tt.r, err, ErrRangeMismatch)
+		}
+	}
+}
+
+func TestShiftedRangeProxy(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	origin := serveContent(t, content)
+	target, err := url.Parse(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a broken proxy asking the origin for one byte past the start of
+	// every range but the first, so its 206 responses are shifted
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	direct := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		direct(req)
+		var start, end int64
+		if _, err := fmt.Sscanf(req.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil && start > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start+1, end))
+		}
+	}
+	srv := httptest.NewServer(proxy)
+	defer srv.Close()
+
+	for _, strategy := range []Strategy{StrategyWriteAt, StrategyTempFiles, StrategyStream} {
+		t.Run(string(strategy), func(t *testing.T) {
+			dir := t.TempDir()
+			output := filepath.Join(dir, "output")
+			err := NewDownloader(srv.URL, output, 4, WithStrategy(strategy), WithSegmentSize(1000)).Download()
+			if !errors.Is(err, ErrRangeMismatch) {
+				t.Fatalf("Download() through the shifting proxy = %v, want %v", err, ErrRangeMismatch)
+			}
+			if data, err := os.ReadFile(output); err == nil && bytes.Equal(data, content) {
+				t.Errorf("the output holds the file, want the download to fail")
+			}
+		})
+	}
+}
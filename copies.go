@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// outputCopy is an extra output being written next to its final path
+type outputCopy struct {
+	path string
+	tmp  *os.File
+	err  error // why the copy failed, nil while it is fine
+}
+
+// createCopies creates a temporary file next to every extra output before
+// the download starts, so that a path that cannot be written fails the
+// download at once instead of after the transfer
+func (d *Downloader) createCopies() ([]*outputCopy, error) {
+	var copies []*outputCopy
+	for _, path := range d.extraOutputs {
+		tmp, err := os.Create(filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".copy"))
+		if err != nil {
+			removeCopies(copies)
+			return nil, fmt.Errorf("cannot create the extra output %s: %w", path, err)
+		}
+		copies = append(copies, &outputCopy{path: path, tmp: tmp})
+	}
+	return copies, nil
+}
+
+// removeCopies removes the temporary files of the copies not renamed into place
+func removeCopies(copies []*outputCopy) {
+	for _, c := range copies {
+		if c.tmp != nil {
+			c.tmp.Close()
+			os.Remove(c.tmp.Name())
+		}
+	}
+}
+
+// writeCopies writes the completed output to the copies in one pass over
+// it, reads every copy back to check it matches, and renames the ones that
+// do into place. A copy that fails does not stop the others, the failures
+// are reported together
+func (d *Downloader) writeCopies(copies []*outputCopy) error {
+	if len(copies) == 0 {
+		return nil
+	}
+	src, err := os.Open(d.output)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	h := sha256.New()
+	buf := make([]byte, 1<<20)
+	var size int64
+	for {
+		n, err := src.Read(buf)
+		h.Write(buf[:n])
+		size += int64(n)
+		for _, c := range copies {
+			if c.err == nil {
+				_, c.err = c.tmp.Write(buf[:n])
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	want := h.Sum(nil)
+
+	var errs []error
+	for _, c := range copies {
+		if c.err == nil {
+			c.err = c.tmp.Close()
+		}
+		if c.err == nil {
+			c.err = verifyCopy(c.tmp.Name(), size, want)
+		}
+		if c.err == nil {
+			c.err = os.Rename(c.tmp.Name(), c.path)
+		}
+		if c.err != nil {
+			errs = append(errs, fmt.Errorf("extra output %s: %w", c.path, c.err))
+			continue
+		}
+		c.tmp = nil
+		log.Printf("Copied to %s\n", c.path)
+	}
+	return errors.Join(errs...)
+}
+
+// verifyCopy checks that the file at path has the size and sha256 of the output
+func verifyCopy(path string, size int64, sum []byte) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, file)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("%w: the copy has %d bytes, the output %d", ErrSizeMismatch, n, size)
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, sum) {
+		return fmt.Errorf("%w: the copy has sha256:%x, the output sha256:%x", ErrChecksumMismatch, got, sum)
+	}
+	return nil
+}
+
+// WithExtraOutputs saves the file to each of paths as well, e.g. a cache
+// and a working directory, without downloading it again: once the output
+// is complete and verified it is copied to them and every copy is checked
+// against it. The paths are created before the download starts, so one
+// that cannot be written fails it at once. A copy failing afterwards does
+// not undo the output or the other copies
+func WithExtraOutputs(paths ...string) Option {
+	return func(d *Downloader) {
+		d.extraOutputs = paths
+	}
+}
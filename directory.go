@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ErrDirectoryURL is returned when the output is to be named after a url
+// that points to a directory rather than a file
+var ErrDirectoryURL = errors.New("url points to a directory")
+
+// DirectoryPolicy selects what a download whose output is named after the
+// url does when the url looks like a directory, e.g. https://host/pub/
+type DirectoryPolicy string
+
+const (
+	// DirectoryError fails with ErrDirectoryURL unless the server names
+	// the file. This is the default
+	DirectoryError DirectoryPolicy = "error"
+	// DirectoryIndex saves the page the server returns as index.html
+	DirectoryIndex DirectoryPolicy = "index"
+	// DirectoryListing downloads the files the page links to, which is an
+	// index for FetchIndex with ListingParser. A Downloader cannot do that
+	// alone and fails like DirectoryError
+	DirectoryListing DirectoryPolicy = "listing"
+)
+
+// directoryIndexName is the output name of DirectoryIndex
+const directoryIndexName = "index.html"
+
+// ParseDirectoryPolicy parses the name of a DirectoryPolicy
+func ParseDirectoryPolicy(s string) (DirectoryPolicy, error) {
+	switch p := DirectoryPolicy(strings.ToLower(s)); p {
+	case DirectoryError, DirectoryIndex, DirectoryListing:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown directory policy %q, expected error, index or listing", s)
+}
+
+// directoryURL reports whether the path of rawURL names a directory: it is
+// empty or ends in a slash, or its last element is . or ..
+func directoryURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	p := u.Path
+	return p == "" || strings.HasSuffix(p, "/") || strings.HasSuffix(p, "/.") || strings.HasSuffix(p, "/..")
+}
+
+// directoryName returns the output name for a directory url under the
+// policy, which the server did not name
+func (d *Downloader) directoryName() (string, error) {
+	if d.directoryPolicy == DirectoryIndex {
+		return directoryIndexName, nil
+	}
+	return "", fmt.Errorf("%w: %s, give an output name, or choose the index or listing directory policy", ErrDirectoryURL, redactURL(d.url))
+}
+
+// hrefPattern matches the link targets of an HTML page
+var hrefPattern = regexp.MustCompile(`(?i)<a\s[^>]*?href\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+
+// ListingParser parses the HTML directory listing a web server generates
+// for a url ending in a slash, such as the autoindex of nginx or Apache.
+// Every link to a file directly in the listed directory becomes a job saved
+// under the base name of the file. Links to subdirectories, parent
+// directories, other hosts and the sort links of the listing are skipped
+type ListingParser struct{}
+
+// Parse implements IndexParser
+func (ListingParser) Parse(base *url.URL, r io.Reader) ([]DownloadJob, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	dir := base.Path
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir) + "/"
+	}
+	var jobs []DownloadJob
+	seen := make(map[string]bool)
+	for _, m := range hrefPattern.FindAllSubmatch(body, -1) {
+		href := strings.TrimSpace(html.UnescapeString(string(m[1]) + string(m[2]) + string(m[3])))
+		ref, err := url.Parse(href)
+		if err != nil || href == "" || strings.HasPrefix(href, "?") || strings.HasPrefix(href, "#") {
+			continue
+		}
+		target := base.ResolveReference(ref)
+		target.Fragment = ""
+		if target.Host != base.Host || target.Scheme != base.Scheme {
+			continue
+		}
+		rest, ok := strings.CutPrefix(target.Path, dir)
+		if !ok || rest == "" || strings.Contains(rest, "/") || target.RawQuery != "" {
+			continue
+		}
+		output := cleanName(rest)
+		if output == "" || seen[output] {
+			continue
+		}
+		seen[output] = true
+		jobs = append(jobs, DownloadJob{URL: target.String(), Output: output})
+	}
+	if len(jobs) == 0 {
+		return nil, errors.New("no files listed")
+	}
+	return jobs, nil
+}
+
+// WithDirectoryPolicy selects what happens when the output is left empty
+// to be named after a url that looks like a directory and the server does
+// not name the file, DirectoryError by default
+func WithDirectoryPolicy(p DirectoryPolicy) Option {
+	return func(d *Downloader) {
+		d.directoryPolicy = p
+	}
+}
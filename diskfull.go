@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrDiskFull is returned when the output cannot be written because the
+// disk is full
+var ErrDiskFull = errors.New("disk full")
+
+// DiskFullAction is what happens when a write fails because the disk is full
+type DiskFullAction string
+
+const (
+	// DiskFullFail fails the download with ErrDiskFull and removes the
+	// partial output, except when continuing one
+	DiskFullFail DiskFullAction = "fail"
+	// DiskFullPause holds the write and tries again every diskFullRetry
+	// until another process frees space or the context is done
+	DiskFullPause DiskFullAction = "pause"
+)
+
+// diskFullRetry is how often a write paused by a full disk is tried again
+const diskFullRetry = 5 * time.Second
+
+// ParseDiskFullAction parses the name of a DiskFullAction
+func ParseDiskFullAction(s string) (DiskFullAction, error) {
+	switch a := DiskFullAction(strings.ToLower(s)); a {
+	case DiskFullFail, DiskFullPause:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown disk full action %q, expected pause or fail", s)
+}
+
+// diskFullWriter applies the disk full action to the writes to w
+type diskFullWriter struct {
+	w   io.Writer
+	ctx context.Context
+	d   *Downloader
+}
+
+// Write writes p, waiting for space or failing with ErrDiskFull when the
+// disk is full
+func (w *diskFullWriter) Write(p []byte) (int, error) {
+	written := 0
+	for {
+		n, err := w.w.Write(p[written:])
+		written += n
+		if !errors.Is(err, syscall.ENOSPC) {
+			return written, err
+		}
+		if w.d.onDiskFull != DiskFullPause {
+			return written, fmt.Errorf("%w: %v", ErrDiskFull, err)
+		}
+		log.Printf("Disk full, retrying the write every %v until space is freed\n", diskFullRetry)
+		if err := w.d.sleep(w.ctx, diskFullRetry); err != nil {
+			return written, fmt.Errorf("%w: %v", ErrDiskFull, err)
+		}
+	}
+}
+
+// diskFull makes the writes to w follow the disk full action
+func (d *Downloader) diskFull(ctx context.Context, w io.Writer) io.Writer {
+	if d.fakeDisk != nil {
+		w = d.fakeDisk(w)
+	}
+	return &diskFullWriter{w: w, ctx: ctx, d: d}
+}
+
+// removePartial removes the partial output left by a download that failed
+// on a full disk, unless it is not a regular file
+func (d *Downloader) removePartial() {
+	if info, err := os.Lstat(d.output); err == nil && info.Mode().IsRegular() {
+		log.Printf("Removing the partial %s\n", d.output)
+		os.Remove(d.output)
+	}
+}
+
+// WithDiskFullAction chooses what happens when the disk fills up during
+// the download: DiskFullFail, the default, or DiskFullPause, which holds
+// the writes until another process frees space. The connections stay open
+// while paused, so a server with a short idle timeout may drop them
+func WithDiskFullAction(action DiskFullAction) Option {
+	return func(d *Downloader) {
+		d.onDiskFull = action
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fullDisk is a writer that fails with ENOSPC once free bytes were written,
+// until space is freed
+type fullDisk struct {
+	w    io.Writer
+	free *int64 // the bytes that still fit, shared by every writer of a disk
+}
+
+func (f *fullDisk) Write(p []byte) (int, error) {
+	n := int64(len(p))
+	if left := atomic.AddInt64(f.free, -n); left < 0 {
+		n = max(0, n+left)
+		atomic.AddInt64(f.free, int64(len(p))-n)
+		written, err := f.w.Write(p[:n])
+		if err == nil {
+			err = &os.PathError{Op: "write", Path: "output", Err: syscall.ENOSPC}
+		}
+		return written, err
+	}
+	return f.w.Write(p)
+}
+
+func TestDiskFullWriter(t *testing.T) {
+	var buf bytes.Buffer
+	free := int64(10)
+	d := NewDownloader("", "", 1)
+	w := d.diskFull(t.Context(), &fullDisk{w: &buf, free: &free})
+	n, err := w.Write([]byte("0123456789abcdef"))
+	if n != 10 || !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("Write() on a full disk = %d, %v, want 10, %v", n, err, ErrDiskFull)
+	}
+
+	// pausing retries the rest once space is freed
+	buf.Reset()
+	free = 4
+	var waits []time.Duration
+	d = NewDownloader("", "", 1, WithDiskFullAction(DiskFullPause), WithSleepFunc(func(wait time.Duration) {
+		waits = append(waits, wait)
+		if len(waits) == 2 {
+			atomic.AddInt64(&free, 100)
+		}
+	}))
+	w = d.diskFull(t.Context(), &fullDisk{w: &buf, free: &free})
+	if n, err := w.Write([]byte("0123456789")); n != 10 || err != nil || buf.String() != "0123456789" {
+		t.Fatalf("Write() paused on a full disk = %d, %v and %q written, want all of it", n, err, buf.String())
+	}
+	if len(waits) != 2 || waits[0] != diskFullRetry {
+		t.Errorf("waited %v, want twice %v", waits, diskFullRetry)
+	}
+
+	// until the context is done
+	free = 0
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	w = d.diskFull(ctx, &fullDisk{w: &buf, free: &free})
+	if _, err := w.Write([]byte("x")); !errors.Is(err, ErrDiskFull) {
+		t.Errorf("Write() paused with a done context = %v, want %v", err, ErrDiskFull)
+	}
+}
+
+func TestDiskFullRemovesPartial(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	srv := serveContent(t, content)
+	for _, strategy := range []Strategy{StrategyWriteAt, StrategyTempFiles, StrategyStream} {
+		t.Run(string(strategy), func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "output")
+			d := NewDownloader(srv.URL, output, 4, WithStrategy(strategy))
+			// the disk fills up halfway through the file
+			free := int64(len(content) / 2)
+			d.fakeDisk = func(w io.Writer) io.Writer { return &fullDisk{w: w, free: &free} }
+			if err := d.Download(); !errors.Is(err, ErrDiskFull) {
+				t.Fatalf("Download() = %v, want %v", err, ErrDiskFull)
+			}
+			if _, err := os.Stat(output); !os.IsNotExist(err) {
+				t.Errorf("the partial output is left behind: %v", err)
+			}
+		})
+	}
+}
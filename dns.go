@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// dnsLimiter caps the DNS lookups its dialer runs at once, so that a batch
+// starting downloads from many hosts does not overwhelm the resolver
+type dnsLimiter struct {
+	sem     chan struct{}
+	resolve func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// newDNSLimiter returns a limiter for at most n lookups at a time
+func newDNSLimiter(n int) *dnsLimiter {
+	return &dnsLimiter{sem: make(chan struct{}, n), resolve: net.DefaultResolver.LookupIPAddr}
+}
+
+// lookup resolves host once a lookup slot is free
+func (l *dnsLimiter) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	select {
+	case l.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-l.sem }()
+	return l.resolve(ctx, host)
+}
+
+// dial resolves the host of address under the cap and dials its addresses
+// with dial in the order the resolver returned them until one connects.
+// Unlike the dialer of net, which resolves by itself, it does not race
+// IPv4 against IPv6
+func (l *dnsLimiter) dial(ctx context.Context, network, address string, dial func(ctx context.Context, network, address string) (net.Conn, error)) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return dial(ctx, network, address)
+	}
+	addrs, err := l.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, addr := range addrs {
+		conn, err := dial(ctx, network, net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no addresses for %s", host)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// WithMaxDNSConcurrency caps the DNS lookups of the connections at n at a
+// time, separately from the connections themselves. The cap is shared by
+// everything using the client built with it, e.g. all downloads of a batch
+func WithMaxDNSConcurrency(n int) Option {
+	return func(d *Downloader) {
+		d.transport.maxDNS = n
+	}
+}
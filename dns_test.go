@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDNSLimiterCap(t *testing.T) {
+	const n = 3
+	l := newDNSLimiter(n)
+	var inFlight, most int32
+	release := make(chan struct{})
+	l.resolve = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		cur := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&most)
+			if cur <= m || atomic.CompareAndSwapInt32(&most, m, cur) {
+				break
+			}
+		}
+		<-release
+		return []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, nil
+	}
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		c, _ := net.Pipe()
+		return c, nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := l.dial(t.Context(), "tcp", "example.com:80", dial)
+			if err == nil {
+				conn.Close()
+			}
+			errs <- err
+		}()
+	}
+	// let the lookups that can start do so before releasing them
+	for atomic.LoadInt32(&inFlight) < n {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("dial() = %v", err)
+		}
+	}
+	if got := atomic.LoadInt32(&most); got != n {
+		t.Errorf("at most %d lookups ran at once, want %d", got, n)
+	}
+}
+
+func TestDNSLimiterDial(t *testing.T) {
+	l := newDNSLimiter(1)
+	var lookups int32
+	l.resolve = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		atomic.AddInt32(&lookups, 1)
+		if host == "missing.example" {
+			return nil, errors.New("no such host")
+		}
+		return []net.IPAddr{{IP: net.ParseIP("192.0.2.1")}, {IP: net.ParseIP("2001:db8::1")}, {IP: net.ParseIP("192.0.2.2")}}, nil
+	}
+
+	tests := []struct {
+		address string
+		tried   []string // the addresses dialed in order
+		lookup  bool     // whether the host is looked up
+		err     bool
+	}{
+		{address: "example.com:443", tried: []string{"192.0.2.1:443", "[2001:db8::1]:443"}, lookup: true},
+		{address: "192.0.2.9:443", tried: []string{"192.0.2.9:443"}},
+		{address: "missing.example:443", lookup: true, err: true},
+	}
+	for _, tt := range tests {
+		atomic.StoreInt32(&lookups, 0)
+		var tried []string
+		// only the IPv6 address connects
+		dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+			tried = append(tried, address)
+			if address == "192.0.2.1:443" {
+				return nil, errors.New("connection refused")
+			}
+			c, _ := net.Pipe()
+			return c, nil
+		}
+		conn, err := l.dial(t.Context(), "tcp", tt.address, dial)
+		if (err != nil) != tt.err {
+			t.Errorf("dial(%q) = %v, want an error: %v", tt.address, err, tt.err)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+		if !slices.Equal(tried, tt.tried) {
+			t.Errorf("dial(%q) tried %q, want %q", tt.address, tried, tt.tried)
+		}
+		if got := atomic.LoadInt32(&lookups) == 1; got != tt.lookup {
+			t.Errorf("dial(%q) looked the host up: %v, want %v", tt.address, got, tt.lookup)
+		}
+	}
+}
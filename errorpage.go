@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrErrorPage is returned when the content looks like an HTML, XML or
+// JSON error page instead of the file
+var ErrErrorPage = errors.New("content looks like an error page")
+
+// ErrorPageAction is what happens when the start of a download looks like
+// an error page
+type ErrorPageAction string
+
+const (
+	// ErrorPageWarn logs a warning and keeps downloading
+	ErrorPageWarn ErrorPageAction = "warn"
+	// ErrorPageFail aborts the download with ErrErrorPage
+	ErrorPageFail ErrorPageAction = "fail"
+)
+
+// sniffBytes is how much of the start of the content is sniffed, as much as
+// http.DetectContentType considers
+const sniffBytes = 512
+
+// textExtensions are the outputs expected to hold markup or JSON
+var textExtensions = map[string]bool{
+	".html": true, ".htm": true, ".xhtml": true, ".xml": true, ".json": true, ".txt": true,
+}
+
+// ParseErrorPageAction parses the name of an ErrorPageAction
+func ParseErrorPageAction(s string) (ErrorPageAction, error) {
+	switch a := ErrorPageAction(strings.ToLower(s)); a {
+	case ErrorPageWarn, ErrorPageFail:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown error page action %q, expected warn or fail", s)
+}
+
+// sniffPage returns the kind of page head starts like, HTML, XML or JSON,
+// or "" for anything else
+func sniffPage(head []byte) string {
+	ct := http.DetectContentType(head)
+	switch {
+	case strings.HasPrefix(ct, "text/html"):
+		return "HTML"
+	case strings.HasPrefix(ct, "text/xml"):
+		return "XML"
+	case strings.HasPrefix(ct, "text/plain"):
+		t := bytes.TrimLeft(head, " \t\r\n")
+		if bytes.HasPrefix(t, []byte("{\"")) || bytes.HasPrefix(t, []byte("[{")) {
+			return "JSON"
+		}
+	}
+	return ""
+}
+
+// expectsText reports whether the output is expected to be markup or JSON
+// by its extension, or that of the url for a streamed output
+func (d *Downloader) expectsText() bool {
+	name := d.output
+	if d.streamed() {
+		if u, err := url.Parse(d.url); err == nil {
+			name = path.Base(u.Path)
+		}
+	}
+	return textExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// checkErrorPage applies the error page action to the first bytes of the
+// content
+func (d *Downloader) checkErrorPage(head []byte) error {
+	kind := sniffPage(head)
+	if kind == "" || d.expectsText() {
+		return nil
+	}
+	err := fmt.Errorf("%w: it starts like %s, %q", ErrErrorPage, kind, truncateUTF8(string(bytes.TrimSpace(head)), 80))
+	if d.errorPage == ErrorPageFail {
+		return err
+	}
+	log.Printf("Warning: %v\n", err)
+	return nil
+}
+
+// sniffReader sniffs the first bytes of r before passing them on
+type sniffReader struct {
+	r       io.Reader
+	d       *Downloader
+	head    []byte // the sniffed bytes not returned yet
+	sniffed bool
+}
+
+func (s *sniffReader) Read(p []byte) (int, error) {
+	if !s.sniffed {
+		s.sniffed = true
+		head := make([]byte, sniffBytes)
+		n, err := io.ReadFull(s.r, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		s.head = head[:n]
+		if err := s.d.checkErrorPage(s.head); err != nil {
+			return 0, err
+		}
+	}
+	if len(s.head) > 0 {
+		n := copy(p, s.head)
+		s.head = s.head[n:]
+		return n, nil
+	}
+	return s.r.Read(p)
+}
+
+// sniffed checks the start of the single stream r for an error page when
+// the check is enabled
+func (d *Downloader) sniffed(r io.Reader) io.Reader {
+	if d.errorPage == "" {
+		return r
+	}
+	return &sniffReader{r: r, d: d}
+}
+
+// sniffStart fetches the first bytes of the file with a ranged request of
+// their own and checks them, before the chunks are fetched, as the first
+// chunk of a small error page split over the workers is too short to sniff
+func (d *Downloader) sniffStart(ctx context.Context) error {
+	req, err := d.newRequest(ctx, "GET")
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", sniffBytes-1))
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	body := d.wrapBody(resp.Body, probeRequest)
+	defer body.Close()
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	head, err := io.ReadAll(io.LimitReader(body, sniffBytes))
+	if err != nil {
+		return err
+	}
+	return d.checkErrorPage(head)
+}
+
+// WithErrorPageDetection sniffs the first bytes of the file, as served, for
+// an HTML, XML or JSON error page served with a success status, which a
+// Content-Type check misses when the server claims e.g.
+// application/octet-stream. Outputs with an .html, .htm, .xhtml, .xml,
+// .json or .txt extension are expected to hold such content and are not
+// checked. action decides between a warning and failing the download
+func WithErrorPageDetection(action ErrorPageAction) Option {
+	return func(d *Downloader) {
+		d.errorPage = action
+	}
+}
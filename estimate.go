@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	estimateWindow        = time.Second // how long each concurrency level is measured
+	estimateStepBytes     = 16 << 20    // the most bytes fetched per concurrency level
+	estimateMaxConcurrent = 16          // the highest concurrency level tried
+	estimateMinGain       = 1.15        // the speedup required to keep doubling
+)
+
+// EstimateConcurrency measures the throughput to url with 1, 2, 4, ... up to
+// 16 parallel ranged requests and returns the level after which doubling the
+// connections stopped improving throughput by at least 15%. Each level runs
+// for at most one second and fetches at most 16MB spread over its
+// connections, so the whole probe takes at most five seconds and 80MB, and
+// usually much less because it stops as soon as the throughput levels off.
+// The bytes fetched are discarded. A server that does not support range
+// requests yields 1. The requests are made with opts, e.g. the headers,
+// proxy or client the download itself will use
+func EstimateConcurrency(ctx context.Context, url string, opts ...Option) (int, error) {
+	d := NewDownloader(url, "", 1, opts...)
+	if err := d.checkSupportRange(ctx); err != nil {
+		if err == ErrRangeNotSupported {
+			return 1, nil
+		}
+		return 0, err
+	}
+	return d.estimateConcurrency(ctx)
+}
+
+// estimateConcurrency runs the EstimateConcurrency measurement once the
+// size of the file is known
+func (d *Downloader) estimateConcurrency(ctx context.Context) (int, error) {
+	if d.size <= 0 {
+		return 1, nil
+	}
+
+	best, bestRate := 1, 0.0
+	for n := 1; n <= estimateMaxConcurrent; n *= 2 {
+		rate, err := d.measureThroughput(ctx, n)
+		if err != nil {
+			return 0, err
+		}
+		if n > 1 && (rate <= 0 || rate < bestRate*estimateMinGain) {
+			break
+		}
+		best, bestRate = n, rate
+	}
+	return best, nil
+}
+
+// measureThroughput fetches up to estimateStepBytes with n parallel ranged
+// requests for at most estimateWindow and returns the observed bytes per
+// second. The window and the elapsed time both come from the clock of the
+// download, the rate is 0 when no time passed on it, e.g. on a ManualClock
+// that was not advanced
+func (d *Downloader) measureThroughput(parent context.Context, n int) (float64, error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	window := d.clock.AfterFunc(estimateWindow, cancel)
+	defer window.Stop()
+
+	span := d.size
+	if span > estimateStepBytes {
+		span = estimateStepBytes
+	}
+	per := span / int64(n)
+	if per == 0 {
+		per = 1
+	}
+
+	var total int64
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	start := d.clock.Now()
+	for i := 0; i < n; i++ {
+		from := int64(i) * per
+		if from >= d.size {
+			break
+		}
+		to := from + per - 1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := d.newRequest(ctx, "GET")
+			if err != nil {
+				errs <- err
+				return
+			}
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, to))
+			resp, err := d.client.Do(req)
+			if err != nil {
+				if ctx.Err() == nil {
+					errs <- err
+				}
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusPartialContent {
+				errs <- fmt.Errorf("%w while measuring the throughput", statusError(resp))
+				return
+			}
+			io.Copy(io.Discard, &countingReader{ReadCloser: d.wrapBody(resp.Body, probeRequest), n: &total})
+		}()
+	}
+	wg.Wait()
+	elapsed := d.clock.Now().Sub(start)
+	close(errs)
+	if err := <-errs; err != nil {
+		return 0, err
+	}
+	// the caller giving up is an error, our own window expiring is not
+	if err := parent.Err(); err != nil {
+		return 0, err
+	}
+	if elapsed <= 0 {
+		return 0, nil
+	}
+	return float64(atomic.LoadInt64(&total)) / elapsed.Seconds(), nil
+}
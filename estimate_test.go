@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMeasureThroughputManualClock(t *testing.T) {
+	const sent = 64 << 10
+	// sends the first bytes of every range, then hangs until the request
+	// is given up
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", "bytes 0-"+strconv.Itoa(1<<20-1)+"/"+strconv.Itoa(8<<20))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(make([]byte, sent))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	clock := NewManualClock(time.Unix(1700000000, 0))
+	d := NewDownloader(srv.URL, "", 1, WithClock(clock))
+	d.size = 8 << 20
+
+	type result struct {
+		rate float64
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		rate, err := d.measureThroughput(t.Context(), 2)
+		done <- result{rate, err}
+	}()
+	for deadline := time.Now().Add(5 * time.Second); clock.Waiting() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the measurement window was not started on the clock")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case res := <-done:
+		t.Fatalf("measureThroughput() = %v, %v before the window elapsed on the clock", res.rate, res.err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	clock.Advance(2 * time.Second)
+	var res result
+	select {
+	case res = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("measureThroughput() did not return once the window elapsed")
+	}
+	if res.err != nil {
+		t.Fatalf("measureThroughput() = %v", res.err)
+	}
+	if math.IsNaN(res.rate) || math.IsInf(res.rate, 0) || res.rate <= 0 || res.rate > sent {
+		t.Errorf("measureThroughput() = %v bytes per second, want at most %d, the %d bytes sent over 2s", res.rate, sent, 2*sent)
+	}
+}
+
+func TestEstimateConcurrencyOptions(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	var measured int32 // the authorized ranged GETs
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "" {
+			atomic.AddInt32(&measured, 1)
+		}
+		http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	n, err := EstimateConcurrency(t.Context(), srv.URL, WithHeaders(http.Header{"Authorization": {"Bearer secret"}}))
+	if err != nil || n < 1 || n > estimateMaxConcurrent {
+		t.Errorf("EstimateConcurrency() with credentials = %d, %v, want 1 to %d", n, err, estimateMaxConcurrent)
+	}
+	if atomic.LoadInt32(&measured) == 0 {
+		t.Error("EstimateConcurrency() made no authorized ranged request, want the headers of the options on every request")
+	}
+}
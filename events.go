@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// SegmentEventKind is the stage of a segment reported by a SegmentEvent
+type SegmentEventKind int
+
+const (
+	SegmentStarted   SegmentEventKind = iota // a request for the segment is about to be sent
+	SegmentProgress                          // bytes of the segment were received
+	SegmentCompleted                         // the segment was received completely
+	SegmentFailed                            // the attempt at the segment failed, see Err
+)
+
+// String returns the name of the kind
+func (k SegmentEventKind) String() string {
+	switch k {
+	case SegmentStarted:
+		return "started"
+	case SegmentProgress:
+		return "progress"
+	case SegmentCompleted:
+		return "completed"
+	case SegmentFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// SegmentEvent reports a change in the lifecycle of a segment. A segment is
+// one of the ranges the file is split into. Its ID is its index in the
+// file, so it stays the same when the segment is fetched again, e.g. after
+// switching to a single stream, and sorting by ID orders the segments by
+// offset
+type SegmentEvent struct {
+	Kind   SegmentEventKind
+	ID     int      // the index of the segment, from 0
+	Range  [2]int64 // the first and last byte of the segment
+	Worker int      // the worker fetching the segment, -1 for the stream strategy
+	Bytes  int64    // the bytes of the segment received so far, over all attempts
+	Err    error    // why the attempt failed, for SegmentFailed
+}
+
+// segmentProgressEvery is the least time between two SegmentProgress events
+// of a segment
+const segmentProgressEvery = 100 * time.Millisecond
+
+// segmentState tracks a segment across attempts
+type segmentState struct {
+	bytes int64 // accessed atomically
+	done  bool
+}
+
+// segmentKey is the context key of the segmentReporter of a chunk request
+type segmentKey struct{}
+
+// segmentReporter counts the bytes of a segment and reports the progress
+type segmentReporter struct {
+	d      *Downloader
+	id     int
+	worker int
+	last   time.Time
+}
+
+// event sends an event about the segment to the handler
+func (s *segmentReporter) event(kind SegmentEventKind, err error) {
+	s.d.onSegment(SegmentEvent{
+		Kind:   kind,
+		ID:     s.id,
+		Range:  s.d.ranges[s.id],
+		Worker: s.worker,
+		Bytes:  atomic.LoadInt64(&s.d.segments[s.id].bytes),
+		Err:    err,
+	})
+}
+
+// segmentBody counts the bytes read from a segment response
+type segmentBody struct {
+	io.ReadCloser
+	s *segmentReporter
+}
+
+// Read reads from the body and reports the progress at most every segmentProgressEvery
+func (b *segmentBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.s.d.segments[b.s.id].bytes, int64(n))
+	if now := b.s.d.clock.Now(); n > 0 && now.Sub(b.s.last) >= segmentProgressEvery {
+		b.s.last = now
+		b.s.event(SegmentProgress, nil)
+	}
+	return n, err
+}
+
+// reportSegment makes body report the progress of the segment fetched by ctx, if any
+func reportSegment(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	if s, ok := ctx.Value(segmentKey{}).(*segmentReporter); ok {
+		return &segmentBody{ReadCloser: body, s: s}
+	}
+	return body
+}
+
+// runSegment calls fn for segment i on behalf of worker, retrying it as the
+// retry policy says. A segment that completed before is skipped.
+// d.segments must have been allocated for the ranges
+func (d *Downloader) runSegment(ctx context.Context, i, worker int, fn func(ctx context.Context, i int, r [2]int64) error) error {
+	if d.segments[i].done {
+		return nil
+	}
+	fn = d.traceChunks(worker, fn)
+	return d.withRetries(ctx, fmt.Sprintf("chunk %d", i), func() error {
+		return d.runAttempt(ctx, i, worker, fn)
+	})
+}
+
+// runAttempt makes one attempt of runSegment and reports its lifecycle
+func (d *Downloader) runAttempt(ctx context.Context, i, worker int, fn func(ctx context.Context, i int, r [2]int64) error) error {
+	if d.onSegment == nil {
+		err := fn(ctx, i, d.ranges[i])
+		d.segments[i].done = err == nil
+		return err
+	}
+	s := &segmentReporter{d: d, id: i, worker: worker}
+	s.event(SegmentStarted, nil)
+	err := fn(context.WithValue(ctx, segmentKey{}, s), i, d.ranges[i])
+	if err != nil {
+		s.event(SegmentFailed, err)
+		return err
+	}
+	d.segments[i].done = true
+	s.event(SegmentCompleted, nil)
+	return nil
+}
+
+// WithSegmentEvents calls fn whenever a segment starts, progresses,
+// completes or fails, e.g. to draw the segments of a download manager. fn
+// is called from the workers at the same time and should return quickly
+func WithSegmentEvents(fn func(SegmentEvent)) Option {
+	return func(d *Downloader) {
+		d.onSegment = fn
+	}
+}
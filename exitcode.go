@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// The exit codes of the command, by the class of the failure, so that a
+// script can e.g. retry on a network error but alert on a checksum mismatch:
+//
+//	0   success, or a download declined by the confirmation
+//	1   any other failure, including invalid arguments
+//	2   flags that cannot be parsed, as for every Go command
+//	3   a network error or timeout
+//	4   an unusable status from the server, e.g. 404 or 503
+//	5   access denied by the server, 401 or 403
+//	6   checksum mismatch
+//	7   size mismatch, e.g. with -expect-size or the lockfile
+//	8   ranges are not supported and there is no fallback
+//	9   the disk is full
+//	10  the -byte-quota was exceeded
+//	11  the url is not in the -lock lockfile
+//	12  the output was refused, a symbolic link, a name too long or a directory url
+//	13  the download does not fit in the memory budget
+//	14  an unexpected redirect of a chunk request, or more than -max-redirects
+//	15  the content looks like an error page, with -detect-error-page fail
+const (
+	exitFailure         = 1
+	exitNetwork         = 3
+	exitStatus          = 4
+	exitForbidden       = 5
+	exitChecksum        = 6
+	exitSize            = 7
+	exitRangeNotSupport = 8
+	exitDiskFull        = 9
+	exitQuota           = 10
+	exitNotLocked       = 11
+	exitOutputRefused   = 12
+	exitMemory          = 13
+	exitRedirect        = 14
+	exitErrorPage       = 15
+)
+
+// exitCode returns the exit code for the failure err
+func exitCode(err error) int {
+	var status *StatusError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrChecksumMismatch):
+		return exitChecksum
+	case errors.Is(err, ErrSizeMismatch):
+		return exitSize
+	case errors.Is(err, ErrRangeNotSupported):
+		return exitRangeNotSupport
+	case errors.Is(err, ErrDiskFull), errors.Is(err, syscall.ENOSPC):
+		return exitDiskFull
+	case errors.Is(err, ErrQuotaExceeded):
+		return exitQuota
+	case errors.Is(err, ErrNotLocked):
+		return exitNotLocked
+	case errors.Is(err, ErrSymlinkOutput), errors.Is(err, ErrNameTooLong), errors.Is(err, ErrDirectoryURL):
+		return exitOutputRefused
+	case errors.Is(err, ErrMemoryBudget):
+		return exitMemory
+	case errors.Is(err, ErrUnexpectedRedirect), errors.Is(err, ErrTooManyRedirects):
+		return exitRedirect
+	case errors.Is(err, ErrErrorPage):
+		return exitErrorPage
+	case errors.As(err, &status):
+		if status.Code == http.StatusUnauthorized || status.Code == http.StatusForbidden {
+			return exitForbidden
+		}
+		return exitStatus
+	// last, since client errors wrap everything in a *url.Error, which is a
+	// net.Error
+	case errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrProbeTimeout), errors.Is(err, ErrStalled), errors.Is(err, io.ErrUnexpectedEOF):
+		return exitNetwork
+	}
+	return exitFailure
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrSizeMismatch is returned when the server reports another size than expected
+var ErrSizeMismatch = errors.New("size mismatch")
+
+// checkExpectedSize fails when size, as reported by the server, is known
+// and differs from the expected size
+func (d *Downloader) checkExpectedSize(size int64) error {
+	if d.expectSize < 0 || size < 0 || size == d.expectSize {
+		return nil
+	}
+	return fmt.Errorf("%w: server reports %d bytes, expected %d", ErrSizeMismatch, size, d.expectSize)
+}
+
+// checkOutputSize fails when the completed output does not hold the size
+// of the file reported by the probe, so that chunks that all came back
+// short or empty cannot pass as a successful download
+func (d *Downloader) checkOutputSize() error {
+	// the chunks written into a region were checked one by one
+	if !d.sizeGuard || d.size <= 0 || d.writerAt != nil {
+		return nil
+	}
+	n := d.outBytes
+	if !d.streamed() {
+		info, err := os.Stat(d.output)
+		if err != nil {
+			return err
+		}
+		// e.g. /dev/null has no size to check
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		n = info.Size()
+	}
+	if n != d.size {
+		return fmt.Errorf("%w: output holds %d bytes, the file has %d", ErrSizeMismatch, n, d.size)
+	}
+	return nil
+}
+
+// WithSizeGuard checks that a completed output holds exactly the size the
+// server reported, which is on by default. It guards against chunks that
+// succeed without data, e.g. empty 206 responses
+func WithSizeGuard(enabled bool) Option {
+	return func(d *Downloader) {
+		d.sizeGuard = enabled
+	}
+}
+
+// WithExpectedSize refuses the download before fetching any bytes when the
+// server reports another size than size, e.g. because the url points at
+// another or an updated artifact. A negative size skips the check
+func WithExpectedSize(size int64) Option {
+	return func(d *Downloader) {
+		d.expectSize = size
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEmptyPartialContent(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	// answers HEAD like a web server, every range with an empty 206
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(content))
+			return
+		}
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+			http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(content))
+			return
+		}
+		w.Header().Set("Content-Range", "bytes "+strconv.Itoa(start)+"-"+strconv.Itoa(end)+"/"+strconv.Itoa(len(content)))
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusPartialContent)
+	}))
+	defer srv.Close()
+
+	for _, strategy := range []Strategy{StrategyWriteAt, StrategyTempFiles, StrategyStream} {
+		t.Run(string(strategy), func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "output")
+			err := NewDownloader(srv.URL, output, 4, WithStrategy(strategy)).Download()
+			if err == nil {
+				t.Fatal("Download() of empty 206 responses = nil, want an error")
+			}
+		})
+	}
+
+	// the guard behind the checks of the chunks
+	output := filepath.Join(t.TempDir(), "output")
+	if err := os.WriteFile(output, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDownloader(srv.URL, output, 4)
+	d.size = int64(len(content))
+	if err := d.checkOutputSize(); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("checkOutputSize() of an empty output = %v, want %v", err, ErrSizeMismatch)
+	}
+	d = NewDownloader(srv.URL, output, 4, WithSizeGuard(false))
+	d.size = int64(len(content))
+	if err := d.checkOutputSize(); err != nil {
+		t.Errorf("checkOutputSize() without the guard = %v, want nil", err)
+	}
+}
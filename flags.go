@@ -0,0 +1,17 @@
+package main
+
+import "strings"
+
+// stringList is a repeatable string flag
+type stringList []string
+
+// String returns the values joined by commas
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+// Set appends a value
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
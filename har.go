@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// redactedHeaders are replaced by a placeholder in the recorded session
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// HARRecorder records every request made by the downloaders it is given to
+// as a HAR 1.2 log (http://www.softwareishard.com/blog/har-12-spec/), which
+// browsers and analysis tools can load. Credentials are redacted. Requests
+// that fail without a response have status 0 and an "_error" field
+type HARRecorder struct {
+	mu      sync.Mutex
+	entries []*harEntry
+}
+
+// harEntry is a single request of a HAR log
+type harEntry struct {
+	Started  time.Time   `json:"startedDateTime"`
+	Time     float64     `json:"time"`
+	Request  harRequest  `json:"request"`
+	Response harResponse `json:"response"`
+	Timings  harTimings  `json:"timings"`
+	Error    string      `json:"_error,omitempty"`
+}
+
+// harRequest is the request part of a HAR entry
+type harRequest struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []harHeader `json:"headers"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+// harResponse is the response part of a HAR entry
+type harResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []harHeader `json:"headers"`
+	Content     harContent  `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+// harContent describes the response body, which is not recorded
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+// harTimings splits the time of an entry into waiting for the response
+// headers and receiving the body, in milliseconds. DNS, connect and SSL
+// are only known with WithTraceTiming and are -1 otherwise or when a
+// connection was reused
+type harTimings struct {
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harHeader is a single header of a HAR entry
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// NewHARRecorder creates an empty HARRecorder
+func NewHARRecorder() *HARRecorder {
+	return &HARRecorder{}
+}
+
+// harHeaders converts headers to their HAR form, sorted and redacted
+func harHeaders(h http.Header) []harHeader {
+	headers := []harHeader{}
+	for name, values := range h {
+		for _, v := range values {
+			if redactedHeaders[name] {
+				v = "[redacted]"
+			}
+			headers = append(headers, harHeader{Name: name, Value: v})
+		}
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+	return headers
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// wrap returns a RoundTripper recording every request made through rt
+func (r *HARRecorder) wrap(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		e := &harEntry{
+			Started: time.Now(),
+			Request: harRequest{
+				Method:      req.Method,
+				URL:         req.URL.String(),
+				HTTPVersion: req.Proto,
+				Headers:     harHeaders(req.Header),
+				HeadersSize: -1,
+				BodySize:    0,
+			},
+		}
+		r.mu.Lock()
+		r.entries = append(r.entries, e)
+		r.mu.Unlock()
+
+		resp, err := rt.RoundTrip(req)
+		wait := time.Since(e.Started)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		e.Timings.DNS, e.Timings.Connect, e.Timings.SSL = -1, -1, -1
+		var setup time.Duration
+		if t := timingOf(req.Context()); t != nil {
+			t.mu.Lock()
+			if t.traced && !t.reused {
+				// HAR counts the handshake as part of connect
+				e.Timings.DNS = milliseconds(t.dns)
+				e.Timings.Connect = milliseconds(t.connect + t.tls)
+				if t.tls > 0 {
+					e.Timings.SSL = milliseconds(t.tls)
+				}
+				setup = t.dns + t.connect + t.tls
+			}
+			t.mu.Unlock()
+		}
+		e.Timings.Wait = milliseconds(wait - setup)
+		e.Time = milliseconds(wait)
+		if err != nil {
+			e.Error = err.Error()
+			e.Response = harResponse{Headers: []harHeader{}, HeadersSize: -1, BodySize: -1}
+			return nil, err
+		}
+		e.Response = harResponse{
+			Status:      resp.StatusCode,
+			StatusText:  http.StatusText(resp.StatusCode),
+			HTTPVersion: resp.Proto,
+			Headers:     harHeaders(resp.Header),
+			Content:     harContent{MimeType: resp.Header.Get("Content-Type")},
+			RedirectURL: resp.Header.Get("Location"),
+			HeadersSize: -1,
+		}
+		resp.Body = &harBody{ReadCloser: resp.Body, recorder: r, entry: e, wait: wait}
+		return resp, nil
+	})
+}
+
+// harBody counts the bytes of a response body and completes its entry when closed
+type harBody struct {
+	io.ReadCloser
+	recorder *HARRecorder
+	entry    *harEntry
+	wait     time.Duration
+	n        int64
+	once     sync.Once
+}
+
+// Read reads from the body and counts the bytes
+func (b *harBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+// Close closes the body and completes the entry
+func (b *harBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+// finish records the body size and receive time of the entry
+func (b *harBody) finish() {
+	b.once.Do(func() {
+		b.recorder.mu.Lock()
+		defer b.recorder.mu.Unlock()
+		total := time.Since(b.entry.Started)
+		b.entry.Time = milliseconds(total)
+		b.entry.Timings.Receive = milliseconds(total - b.wait)
+		b.entry.Response.BodySize = b.n
+		b.entry.Response.Content.Size = b.n
+	})
+}
+
+// WriteFile writes the recorded session as a HAR log to path
+func (r *HARRecorder) WriteFile(path string) error {
+	r.mu.Lock()
+	doc := map[string]interface{}{
+		"log": map[string]interface{}{
+			"version": "1.2",
+			"creator": map[string]string{"name": "downloader", "version": "1"},
+			"pages":   []interface{}{},
+			"entries": r.entries,
+		},
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req)
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// WithHARRecorder records every request of the download to r
+func WithHARRecorder(r *HARRecorder) Option {
+	return func(d *Downloader) {
+		d.middleware = append(d.middleware, r.wrap)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// headerList is a repeatable flag collecting headers given as "Name: value"
+type headerList []string
+
+// String returns the headers joined by commas
+func (h *headerList) String() string {
+	return strings.Join(*h, ", ")
+}
+
+// Set validates and appends a header
+func (h *headerList) Set(value string) error {
+	if _, _, err := parseHeader(value); err != nil {
+		return err
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// header converts the collected values into an http.Header
+func (h headerList) header() http.Header {
+	header := http.Header{}
+	for _, value := range h {
+		name, v, _ := parseHeader(value)
+		header.Add(name, v)
+	}
+	return header
+}
+
+// parseHeader splits a header given as "Name: value"
+func parseHeader(s string) (string, string, error) {
+	name, value, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", fmt.Errorf("header %q must be given as 'Name: value'", s)
+	}
+	return textproto.CanonicalMIMEHeaderKey(name), strings.TrimSpace(value), nil
+}
+
+// WithHeaders adds extra headers to every request, replacing earlier
+// values of the same headers
+func WithHeaders(header http.Header) Option {
+	return func(d *Downloader) {
+		if d.headers == nil {
+			d.headers = http.Header{}
+		}
+		for k, v := range header {
+			d.headers[k] = append([]string(nil), v...)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// hostLimits maps hosts to the rate limiters of their responses, nil for a
+// host that is not limited at all
+type hostLimits map[string]*rateLimiter
+
+// ParseHostLimit parses a per-host rate limit given as host=rate, where
+// rate is a size per second such as 500K, 1MB or 1MB/s, and 0 or unlimited
+// exempts the host from every limit, e.g. example.com=1MB/s
+func ParseHostLimit(s string) (string, int64, error) {
+	host, rate, ok := strings.Cut(s, "=")
+	host = strings.ToLower(strings.TrimSpace(host))
+	if !ok || host == "" {
+		return "", 0, fmt.Errorf("host limit %q must be given as host=rate", s)
+	}
+	rate = strings.TrimSpace(rate)
+	if strings.EqualFold(rate, "unlimited") {
+		return host, 0, nil
+	}
+	n, err := parseSize(strings.TrimSuffix(strings.TrimSuffix(rate, "/s"), "/S"))
+	if err != nil {
+		return "", 0, fmt.Errorf("host limit %q: %v", s, err)
+	}
+	return host, n, nil
+}
+
+// lookup returns the limiter of host, or of the closest parent domain
+// listed, and whether any is listed
+func (l hostLimits) lookup(host string) (*rateLimiter, bool) {
+	host = strings.ToLower(host)
+	for {
+		if limiter, ok := l[host]; ok {
+			return limiter, true
+		}
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			return nil, false
+		}
+		host = parent
+	}
+}
+
+// rateLimiter returns the limiter of the file's host, the host of the url
+// the chunks are fetched from, or the global one when the host has no limit
+// of its own
+func (d *Downloader) rateLimiter() *rateLimiter {
+	if d.hostLimits == nil {
+		return d.limiter
+	}
+	raw := d.resolved
+	if raw == "" {
+		raw = d.url
+	}
+	if u, err := url.Parse(raw); err == nil {
+		if limiter, ok := d.hostLimits.lookup(u.Hostname()); ok {
+			return limiter
+		}
+	}
+	return d.limiter
+}
+
+// WithHostRateLimits caps the download rate from every host of limits at
+// its bytes per second, a rate of 0 exempting the host. A host's limit
+// applies to its subdomains too, unless they have their own. Hosts not
+// listed fall back to WithRateLimit. A limit is shared by every
+// downloader given this option, so all downloads of a batch from a host
+// share its rate, while each host of the batch is throttled on its own
+func WithHostRateLimits(limits map[string]int64) Option {
+	l := hostLimits{}
+	for host, rate := range limits {
+		l[strings.ToLower(host)] = nil
+		if rate > 0 {
+			l[strings.ToLower(host)] = newRateLimiter(rate)
+		}
+	}
+	return func(d *Downloader) {
+		d.hostLimits = l
+	}
+}
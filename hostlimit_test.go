@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHostLimit(t *testing.T) {
+	tests := []struct {
+		in   string
+		host string
+		rate int64
+		err  bool
+	}{
+		{in: "example.com=1MB/s", host: "example.com", rate: 1 << 20},
+		{in: " Example.COM = 500K ", host: "example.com", rate: 500 << 10},
+		{in: "example.com=unlimited", host: "example.com"},
+		{in: "example.com=0", host: "example.com"},
+		{in: "example.com", err: true},
+		{in: "=1MB", err: true},
+		{in: "example.com=fast", err: true},
+	}
+	for _, tt := range tests {
+		host, rate, err := ParseHostLimit(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseHostLimit(%q) = %q, %d, want an error", tt.in, host, rate)
+			}
+			continue
+		}
+		if err != nil || host != tt.host || rate != tt.rate {
+			t.Errorf("ParseHostLimit(%q) = %q, %d, %v, want %q, %d", tt.in, host, rate, err, tt.host, tt.rate)
+		}
+	}
+}
+
+func TestHostRateLimits(t *testing.T) {
+	limits := WithHostRateLimits(map[string]int64{"a.example": 1000, "B.example": 1000, "free.a.example": 0})
+	global := WithRateLimit(500)
+	limiter := func(rawURL, resolved string) *rateLimiter {
+		d := NewDownloader(rawURL, "", 1, limits, global)
+		d.resolved = resolved
+		return d.rateLimiter()
+	}
+	a := limiter("http://a.example/file", "")
+	b := limiter("http://b.example/file", "")
+	other := limiter("http://c.example/file", "")
+	if a == nil || b == nil || other == nil || a == b || a == other || b == other {
+		t.Fatalf("limiters of a, b and another host are %p, %p and %p, want three separate ones", a, b, other)
+	}
+
+	tests := []struct {
+		url, resolved string
+		want          *rateLimiter
+	}{
+		{url: "http://A.example:8080/other", want: a},                              // shared by the downloads from a host
+		{url: "http://mirror.a.example/file", want: a},                             // a subdomain without its own limit
+		{url: "http://free.a.example/file"},                                        // exempt
+		{url: "http://x.free.a.example/file"},                                      // exempt with its parent
+		{url: "http://c.example/file", want: other},                                // the global limit
+		{url: "http://a.example/file", resolved: "http://b.example/file", want: b}, // the host the chunks come from
+	}
+	for _, tt := range tests {
+		if got := limiter(tt.url, tt.resolved); got != tt.want {
+			t.Errorf("rateLimiter() of %s resolved to %q = %p, want %p", tt.url, tt.resolved, got, tt.want)
+		}
+	}
+
+	// a host in debt does not hold up another
+	clock := NewManualClock(time.Unix(0, 0))
+	doneA, doneB := make(chan struct{}), make(chan struct{})
+	go func() { a.wait(1000, clock); close(doneA) }()
+	waitTimer(clock)
+	go func() { b.wait(500, clock); close(doneB) }()
+	for clock.Waiting() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(500 * time.Millisecond)
+	select {
+	case <-doneB:
+	case <-time.After(5 * time.Second):
+		t.Fatal("b.example waited past its own 500ms of debt")
+	}
+	select {
+	case <-doneA:
+		t.Fatal("a.example stopped waiting after 500ms, want its second of debt paid")
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Advance(500 * time.Millisecond)
+	select {
+	case <-doneA:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a.example did not stop waiting after its second of debt")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// IndexParser turns the body of an index listing several files into
+// download jobs. base is the url of the index, for resolving relative entries
+type IndexParser interface {
+	Parse(base *url.URL, r io.Reader) ([]DownloadJob, error)
+}
+
+// FetchIndex downloads the index at indexURL as an auxiliary request, using
+// the client, headers and limits configured by opts, and parses it into
+// validated jobs ready for batch mode
+func FetchIndex(ctx context.Context, indexURL string, parser IndexParser, opts ...Option) ([]DownloadJob, error) {
+	base, err := url.Parse(indexURL)
+	if err != nil {
+		return nil, err
+	}
+	d := NewDownloader(indexURL, "", 1, opts...)
+	req, err := d.newRequest(ctx, "GET")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body := d.wrapBody(resp.Body, auxRequest)
+	defer body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching index %s: %w", indexURL, statusError(resp))
+	}
+	jobs, err := parser.Parse(base, body)
+	if err != nil {
+		return nil, fmt.Errorf("parsing index %s: %w", indexURL, err)
+	}
+	m := Manifest{Downloads: jobs}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("index %s: %w", indexURL, err)
+	}
+	return jobs, nil
+}
+
+// ChecksumListParser parses the output format of sha256sum and friends, as
+// published in SHA256SUMS or MD5SUMS files:
+//
+//	<hex digest>  <file>
+//	<hex digest> *<file>
+//
+// Files are resolved against the index url and saved under their base name
+type ChecksumListParser struct {
+	Algo string // the checksum algorithm of the list, e.g. sha256
+}
+
+// Parse implements IndexParser
+func (p ChecksumListParser) Parse(base *url.URL, r io.Reader) ([]DownloadJob, error) {
+	algo := strings.ToLower(p.Algo)
+	if _, ok := hashes[algo]; !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", p.Algo)
+	}
+	var jobs []DownloadJob
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		digest, name, ok := strings.Cut(text, " ")
+		name = strings.TrimPrefix(strings.TrimLeft(name, " "), "*")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("line %d: expected '<digest>  <file>'", line)
+		}
+		ref, err := url.Parse(name)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		output := path.Base(ref.Path)
+		if output == "." || output == "/" || output == ".." {
+			return nil, fmt.Errorf("line %d: no file name in %q", line, name)
+		}
+		output = safeName(output)
+		jobs = append(jobs, DownloadJob{
+			URL:      base.ResolveReference(ref).String(),
+			Output:   output,
+			Checksum: algo + ":" + digest,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, errors.New("no files listed")
+	}
+	return jobs, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ResolveInterface returns the local addresses the downloads bound to a
+// network interface are sent from. spec is the name of the interface, e.g.
+// eth1, whose routable addresses are preferred over link-local ones, at most
+// one IPv4 and one IPv6 address. An interface with several addresses of a
+// family can be disambiguated with name@address, e.g. eth1@192.0.2.10.
+//
+// The binding sets the source address of the connections, which is all a
+// program can do without privileges on every platform. Windows and macOS
+// then send the traffic out of the interface holding the address. Linux
+// routes by destination and may use another interface for it unless policy
+// routing sends the traffic of the address through its interface, as is
+// usual on multi-homed servers; binding to the device itself would need
+// CAP_NET_RAW
+func ResolveInterface(spec string) ([]net.IP, error) {
+	name, want, pinned := strings.Cut(spec, "@")
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %w", name, err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("interface %s is down", name)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %w", name, err)
+	}
+	var ips []net.IP
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	if pinned {
+		ip := net.ParseIP(want)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q for interface %s", want, name)
+		}
+		for _, have := range ips {
+			if have.Equal(ip) {
+				return []net.IP{ip}, nil
+			}
+		}
+		return nil, fmt.Errorf("interface %s has no address %s", name, ip)
+	}
+	var v4, v6 net.IP
+	for _, ip := range ips {
+		best := &v6
+		if ip.To4() != nil {
+			best = &v4
+		}
+		// a link-local address only does for the link, take it as a last resort
+		if *best == nil || (*best).IsLinkLocalUnicast() && !ip.IsLinkLocalUnicast() {
+			*best = ip
+		}
+	}
+	var local []net.IP
+	for _, ip := range []net.IP{v4, v6} {
+		if ip != nil {
+			local = append(local, ip)
+		}
+	}
+	if len(local) == 0 {
+		return nil, fmt.Errorf("interface %s has no usable address", name)
+	}
+	return local, nil
+}
+
+// dialLocal dials address from the local addresses in turn, each reaching
+// only the destinations of its family, until one connects
+func (o *transportOptions) dialLocal(ctx context.Context, dialer net.Dialer, network, address string) (net.Conn, error) {
+	var errs []error
+	for _, ip := range o.localAddrs {
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, fmt.Errorf("from %s: %w", ip, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// WithLocalAddrs sends the requests from one of the local addresses, e.g.
+// the ones ResolveInterface returns for a network interface. An IPv4
+// address reaches IPv4 destinations and an IPv6 one IPv6 destinations, a
+// host with both is dialed from the first address that connects
+func WithLocalAddrs(ips ...net.IP) Option {
+	return func(d *Downloader) {
+		d.transport.localAddrs = ips
+	}
+}
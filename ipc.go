@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IPC streams the events of downloads as JSON over a Unix domain socket,
+// for an application embedding the command that wants structured updates
+// instead of parsing its log. Every event is one JSON object on a line of
+// its own, with a type telling which of the fields are set:
+//
+//	{"type":"start","time":"…","url":"https://example.com/a.iso","output":"a.iso"}
+//	{"type":"segment","time":"…","url":"…","segment":{"kind":"progress","id":3,"range":[3145728,4194303],"worker":1,"bytes":524288}}
+//	{"type":"progress","time":"…","url":"…","size":4194304,"transferred":1048576,"bytes_per_second":2097152,"active":4}
+//	{"type":"result","time":"…","url":"…","output":"a.iso","size":4194304,"transferred":4194304,"retries":0}
+//	{"type":"result","time":"…","url":"…","output":"a.iso","error":"unexpected status 404 Not Found"}
+//	{"type":"unchanged","time":"…","url":"…","output":"a.iso"}
+//
+// A start and a result enclose the events of every download, a batch sends
+// them for every file over the same connection. Segment events are those of
+// WithSegmentEvents, their error is set for the failed kind. A result with
+// "declined" or "cached" set reports a download skipped by the
+// confirmation or the cache. With Watch, an unchanged event reports every
+// check that found the mirrored resource unchanged. When the socket cannot be reached or goes
+// away the download goes on without it
+type IPC struct {
+	mu   sync.Mutex
+	conn net.Conn
+	enc  *json.Encoder
+	path string
+}
+
+// ipcEvent is a line of the IPC protocol
+type ipcEvent struct {
+	Type        string      `json:"type"` // start, segment, progress, result or unchanged
+	Time        time.Time   `json:"time"`
+	URL         string      `json:"url"`
+	Output      string      `json:"output,omitempty"`
+	Segment     *ipcSegment `json:"segment,omitempty"`
+	Size        int64       `json:"size,omitempty"`
+	Transferred int64       `json:"transferred,omitempty"`
+	Rate        float64     `json:"bytes_per_second,omitempty"`
+	Active      int32       `json:"active,omitempty"`
+	Retries     int         `json:"retries,omitempty"`
+	Declined    bool        `json:"declined,omitempty"`
+	Cached      bool        `json:"cached,omitempty"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// ipcSegment is a SegmentEvent in the IPC protocol
+type ipcSegment struct {
+	Kind   string   `json:"kind"`
+	ID     int      `json:"id"`
+	Range  [2]int64 `json:"range"`
+	Worker int      `json:"worker"`
+	Bytes  int64    `json:"bytes"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// ipcProgressEvery is the time between two progress events
+const ipcProgressEvery = 500 * time.Millisecond
+
+// DialIPC connects to the Unix domain socket at path. When it cannot be
+// reached it logs why and returns nil, which WithIPC ignores
+func DialIPC(path string) *IPC {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		log.Printf("Not sending events to %s: %v\n", path, err)
+		return nil
+	}
+	return &IPC{conn: conn, enc: json.NewEncoder(conn), path: path}
+}
+
+// send writes an event, dropping the connection when the write fails
+func (c *IPC) send(e ipcEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return
+	}
+	e.Time = time.Now()
+	c.conn.SetWriteDeadline(e.Time.Add(5 * time.Second))
+	if err := c.enc.Encode(e); err != nil {
+		log.Printf("Not sending events to %s any more: %v\n", c.path, err)
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// Close closes the connection
+func (c *IPC) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// track sends the start of the download of d, forwards its segment events
+// and sends its progress until the returned function is called with the
+// outcome, which sends the result
+func (c *IPC) track(d *Downloader) func(err error) {
+	c.send(ipcEvent{Type: "start", URL: d.url, Output: d.output})
+	prev := d.onSegment
+	d.onSegment = func(e SegmentEvent) {
+		s := &ipcSegment{Kind: e.Kind.String(), ID: e.ID, Range: e.Range, Worker: e.Worker, Bytes: e.Bytes}
+		if e.Err != nil {
+			s.Error = e.Err.Error()
+		}
+		c.send(ipcEvent{Type: "segment", URL: d.url, Segment: s})
+		if prev != nil {
+			prev(e)
+		}
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		last, lastBytes := d.clock.Now(), atomic.LoadInt64(&d.transferred)
+		for {
+			select {
+			case now := <-d.clock.After(ipcProgressEvery):
+				bytes := atomic.LoadInt64(&d.transferred)
+				c.send(ipcEvent{
+					Type:        "progress",
+					URL:         d.url,
+					Size:        d.size,
+					Transferred: bytes,
+					Rate:        float64(bytes-lastBytes) / now.Sub(last).Seconds(),
+					Active:      atomic.LoadInt32(&d.active),
+				})
+				last, lastBytes = now, bytes
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func(err error) {
+		close(stop)
+		<-done
+		res := d.Result()
+		e := ipcEvent{
+			Type:        "result",
+			URL:         d.url,
+			Output:      d.output,
+			Size:        res.Size,
+			Transferred: res.Transferred,
+			Retries:     res.Retries,
+			Declined:    d.declined,
+			Cached:      d.cached,
+		}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		c.send(e)
+	}
+}
+
+// WithIPC sends the events of the download over c, see IPC. A nil c, as
+// returned by DialIPC for an unreachable socket, sends nothing
+func WithIPC(c *IPC) Option {
+	return func(d *Downloader) {
+		d.ipc = c
+	}
+}
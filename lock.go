@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrNotLocked is returned when a url is missing from the lockfile
+var ErrNotLocked = errors.New("url is not in the lockfile")
+
+// Lockfile pins the size and SHA-256 of every file a build downloads, so
+// that any drift on the server fails the download. It is stored as JSON:
+//
+//	{
+//	  "files": {
+//	    "https://example.com/tool-1.2.tar.gz": {
+//	      "size": 1048576,
+//	      "sha256": "…"
+//	    }
+//	  }
+//	}
+//
+// The keys are the urls as given to the downloader, before any rewriting
+type Lockfile struct {
+	Files map[string]LockEntry `json:"files"`
+
+	mu sync.Mutex
+}
+
+// LockEntry is what the lockfile pins about a file
+type LockEntry struct {
+	Size   int64  `json:"size"`   // the size of the file in bytes
+	SHA256 string `json:"sha256"` // the hex SHA-256 of the file
+}
+
+// LoadLockfile reads and checks a lockfile
+func LoadLockfile(path string) (*Lockfile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	dec := json.NewDecoder(file)
+	dec.DisallowUnknownFields()
+	var l Lockfile
+	if err := dec.Decode(&l); err != nil {
+		return nil, fmt.Errorf("invalid lockfile %s: %v", path, err)
+	}
+	for url, e := range l.Files {
+		if sum, err := hex.DecodeString(e.SHA256); err != nil || len(sum) != 32 || e.Size < 0 {
+			return nil, fmt.Errorf("invalid lockfile %s: bad entry for %s", path, url)
+		}
+	}
+	if l.Files == nil {
+		l.Files = map[string]LockEntry{}
+	}
+	return &l, nil
+}
+
+// NewLockfile returns an empty lockfile, to be filled by WithLockfile in update mode
+func NewLockfile() *Lockfile {
+	return &Lockfile{Files: map[string]LockEntry{}}
+}
+
+// WriteFile writes the lockfile to path with the urls sorted
+func (l *Lockfile) WriteFile(path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// entry returns the entry of url
+func (l *Lockfile) entry(url string) (LockEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.Files[url]
+	return e, ok
+}
+
+// set records the entry of url
+func (l *Lockfile) set(url string, e LockEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.Files[url] = e
+}
+
+// applyLock makes the download expect the size and checksum pinned for
+// its url. A checksum given as well must be the pinned one
+func (d *Downloader) applyLock() error {
+	e, ok := d.lock.entry(d.url)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotLocked, d.url)
+	}
+	pinned := "sha256:" + strings.ToLower(e.SHA256)
+	if d.checksum != "" && strings.ToLower(d.checksum) != pinned {
+		return fmt.Errorf("checksum %s contradicts the lockfile, which pins %s", d.checksum, pinned)
+	}
+	d.checksum = pinned
+	d.expectSize = e.Size
+	return nil
+}
+
+// recordLock pins the size and SHA-256 of the completed output in the lockfile
+func (d *Downloader) recordLock() error {
+	if !d.fileOutput() || d.split > 0 {
+		return errors.New("only a single output file can be added to the lockfile")
+	}
+	file, err := os.Open(d.output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	h := hashes["sha256"]()
+	n, err := io.Copy(h, file)
+	if err != nil {
+		return err
+	}
+	d.lock.set(d.url, LockEntry{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))})
+	return nil
+}
+
+// WithLockfile enforces the size and SHA-256 pinned by lock: the size is
+// checked right after the probe, before any bytes are fetched, and the
+// checksum once the download completes, and a url missing from the lock
+// fails with ErrNotLocked. With update set nothing is enforced, instead
+// every completed download is pinned in lock, to be saved with WriteFile
+func WithLockfile(lock *Lockfile, update bool) Option {
+	return func(d *Downloader) {
+		d.lock = lock
+		d.updateLock = update
+	}
+}
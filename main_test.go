@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestMain keeps the progress the downloads log out of the test output
+// unless the tests run verbosely
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// serveContent starts a server answering every request with content,
+// supporting ranges
+func serveContent(t *testing.T, content []byte) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(content))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrMemoryBudget is returned when buffering a download in memory would
+// exceed the memory budget or the memory available to the process
+var ErrMemoryBudget = errors.New("download does not fit in memory")
+
+// DownloadBytes downloads the file concurrently into memory and returns its
+// content. The whole file is buffered, so the peak memory use is its size;
+// the download is refused up front with ErrMemoryBudget when that exceeds the
+// budget set by WithMemoryBudget or the memory currently available
+func (d *Downloader) DownloadBytes(ctx context.Context) ([]byte, error) {
+	if err := d.checkSupportRange(ctx); err != nil {
+		return nil, err
+	}
+	if err := d.checkMemory(d.size); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, d.size)
+	if d.size == 0 {
+		return buf, nil
+	}
+	if d.concurrency <= 0 {
+		n, err := d.estimateConcurrency(ctx)
+		if err != nil {
+			return nil, err
+		}
+		d.concurrency = n
+	}
+	if err := d.calculateRanges(); err != nil {
+		return nil, err
+	}
+	err := d.runChunks(ctx, func(ctx context.Context, i int, r [2]int64) error {
+		w := &sliceWriter{buf: buf[r[0] : r[1]+1]}
+		if err := d.fetchRange(ctx, r, w); err != nil {
+			return err
+		}
+		if w.n != len(w.buf) {
+			return fmt.Errorf("chunk %d: received %d bytes, expected %d", i, w.n, len(w.buf))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// checkMemory refuses to buffer need bytes when that exceeds the memory
+// budget or the memory available to the process
+func (d *Downloader) checkMemory(need int64) error {
+	if d.memBudget > 0 && need > d.memBudget {
+		return fmt.Errorf("%w: needs %d bytes, budget is %d", ErrMemoryBudget, need, d.memBudget)
+	}
+	if avail, ok := availableMemory(); ok && need > avail {
+		return fmt.Errorf("%w: needs %d bytes, %d available", ErrMemoryBudget, need, avail)
+	}
+	return nil
+}
+
+// availableMemory returns the memory the process can still allocate, the
+// lower of MemAvailable and the remaining cgroup limit. It is only known on Linux
+func availableMemory() (int64, bool) {
+	avail, ok := memInfoAvailable()
+	if limit, err := readInt("/sys/fs/cgroup/memory.max"); err == nil {
+		if used, err := readInt("/sys/fs/cgroup/memory.current"); err == nil {
+			if left := limit - used; !ok || left < avail {
+				avail, ok = left, true
+			}
+		}
+	}
+	return avail, ok
+}
+
+// memInfoAvailable returns MemAvailable from /proc/meminfo
+func memInfoAvailable() (int64, bool) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			return kb << 10, true
+		}
+	}
+	return 0, false
+}
+
+// readInt reads a file holding a single integer, failing for values such as "max"
+func readInt(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// sliceWriter writes into a fixed buffer and fails instead of growing it
+type sliceWriter struct {
+	buf []byte
+	n   int
+}
+
+// Write copies p after the bytes written so far
+func (w *sliceWriter) Write(p []byte) (int, error) {
+	if len(p) > len(w.buf)-w.n {
+		return 0, io.ErrShortWrite
+	}
+	w.n += copy(w.buf[w.n:], p)
+	return len(p), nil
+}
+
+// WithMemoryBudget caps the bytes in-memory modes such as DownloadBytes may
+// buffer, so a download that would risk running out of memory fails early
+func WithMemoryBudget(budget int64) Option {
+	return func(d *Downloader) {
+		if budget > 0 {
+			d.memBudget = budget
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// defaultMergeBuffer is the size of the buffer the temporary files are
+// merged through when WithMergeBuffer is not given
+const defaultMergeBuffer = 1 << 20
+
+// mergeBuffer returns the buffer one merge copies through
+func (d *Downloader) mergeBuffer() []byte {
+	size := d.mergeBufSize
+	if size <= 0 {
+		size = defaultMergeBuffer
+	}
+	return make([]byte, size)
+}
+
+// copyBuffer copies r to w through buf. Unlike io.CopyBuffer it always uses
+// buf, files would otherwise hand the copy to ReadFrom or WriteTo and their
+// own buffers
+func copyBuffer(w io.Writer, r io.Reader, buf []byte) (int64, error) {
+	return io.CopyBuffer(struct{ io.Writer }{w}, struct{ io.Reader }{r}, buf)
+}
+
+// timeMerge logs the throughput of a merge of n bytes started at start and
+// adds its duration to the result
+func (d *Downloader) timeMerge(start time.Time, n int64) {
+	elapsed := d.clock.Now().Sub(start)
+	atomic.AddInt64(&d.merged, int64(elapsed))
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(n) / elapsed.Seconds() / (1 << 20)
+	}
+	log.Printf("Merged %d bytes in %v, %.2f MiB/s\n", n, elapsed.Round(time.Millisecond), rate)
+}
+
+// WithMergeBuffer sets the size in bytes of the buffer the temporary files
+// of the tempfiles strategy are copied into the output through, 1 MiB by
+// default. Smaller buffers hold less memory, larger ones make fewer system
+// calls on a big file
+func WithMergeBuffer(size int) Option {
+	return func(d *Downloader) {
+		d.mergeBufSize = size
+	}
+}
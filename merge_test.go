@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// writeChunks writes content to the temporary chunk files of d, one per range
+func writeChunks(tb testing.TB, d *Downloader, content []byte) {
+	tb.Helper()
+	for i, r := range d.ranges {
+		if err := os.WriteFile(d.chunkFile(i), content[r[0]:r[1]+1], 0o644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+func TestMergeTruncatedChunk(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	output := filepath.Join(t.TempDir(), "output")
+	d := NewDownloader("", output, 4)
+	d.size = int64(len(content))
+	d.ranges = fixedRanges(d.size, 2500)
+
+	writeChunks(t, d, content)
+	if err := os.Truncate(d.chunkFile(2), 1000); err != nil {
+		t.Fatal(err)
+	}
+	err := d.mergeFiles(t.Context())
+	if err == nil || !strings.Contains(err.Error(), "chunk 2") {
+		t.Fatalf("mergeFiles() with a truncated chunk = %v, want an error about chunk 2", err)
+	}
+	// nothing was merged or removed, so the chunk can be fetched again
+	for i := range d.ranges {
+		if _, err := os.Stat(d.chunkFile(i)); err != nil {
+			t.Errorf("chunk %d: %v, want it kept", i, err)
+		}
+	}
+
+	writeChunks(t, d, content)
+	if err := d.mergeFiles(t.Context()); err != nil {
+		t.Fatalf("mergeFiles() = %v", err)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+		t.Errorf("output holds %d bytes, want the %d of the chunks", len(got), len(content))
+	}
+}
+
+func BenchmarkMergeFiles(b *testing.B) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1<<20) // 16 MiB
+	for _, size := range []int{4 << 10, 64 << 10, 1 << 20, 8 << 20} {
+		b.Run(strconv.Itoa(size>>10)+"KiB", func(b *testing.B) {
+			d := NewDownloader("", filepath.Join(b.TempDir(), "output"), 16, WithMergeBuffer(size))
+			d.size = int64(len(content))
+			d.ranges = fixedRanges(d.size, 1<<20)
+			b.SetBytes(d.size)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				writeChunks(b, d, content)
+				b.StartTimer()
+				if err := d.mergeFiles(b.Context()); err != nil {
+					b.Fatalf("mergeFiles() = %v", err)
+				}
+			}
+		})
+	}
+}
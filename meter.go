@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// ErrQuotaExceeded is returned when the bytes transferred exceed the byte quota
+var ErrQuotaExceeded = errors.New("byte quota exceeded")
+
+// countingReader adds the number of bytes read from the body to a shared counter
+type countingReader struct {
+	io.ReadCloser
+	n     *int64
+	limit int64 // when positive, reads fail once the counter exceeds it
+}
+
+// Read reads from the underlying body and updates the counter
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	total := atomic.AddInt64(r.n, int64(n))
+	if r.limit > 0 && total > r.limit {
+		return n, fmt.Errorf("%w: transferred %d bytes, quota is %d", ErrQuotaExceeded, total, r.limit)
+	}
+	return n, err
+}
+
+// WithByteQuota aborts the download with ErrQuotaExceeded once the bytes
+// read from the network, summed over probes, chunks and retries, exceed quota
+func WithByteQuota(quota int64) Option {
+	return func(d *Downloader) {
+		d.quota = quota
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// overrideMethod turns req, a GET for the file, into a request with the
+// method and body of WithMethod, if one is set
+func (d *Downloader) overrideMethod(req *http.Request) {
+	if d.method == "" || req.Method != http.MethodGet {
+		return
+	}
+	req.Method = d.method
+	if d.body == nil {
+		return
+	}
+	// GetBody lets the client send the body again after a 307 or 308
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(d.body)), nil
+	}
+	req.Body, _ = req.GetBody()
+	req.ContentLength = int64(len(d.body))
+}
+
+// WithMethod requests the file with method, e.g. POST, sending body with
+// every request, for endpoints that only serve a file in answer to it. Ranged
+// requests then carry the method and body too, so parallel downloads only
+// work with an endpoint honouring Range for it: instead of a HEAD the probe
+// asks for the first byte, and without a 206 the file is downloaded in a
+// single stream. The Content-Type of the body is a header to set with
+// WithHeaders. Auxiliary downloads such as checksum files stay GETs, and a
+// 301, 302 or 303 redirect turns the request into a GET without the body,
+// as for any HTTP client. An empty method, or GET without a body, leaves the
+// requests as they are
+func WithMethod(method string, body []byte) Option {
+	return func(d *Downloader) {
+		d.method, d.body = "", nil
+		if method = strings.ToUpper(method); method != "" && (method != http.MethodGet || body != nil) {
+			d.method, d.body = method, body
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithMethod(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	body := []byte(`{"file":"a.iso"}`)
+
+	tests := []struct {
+		name   string
+		ranges bool // whether the endpoint honours Range for a POST
+		ranged int  // the least ranged requests wanted
+	}{
+		{name: "ranged POST", ranges: true, ranged: 4},
+		{name: "POST without ranges", ranges: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var requests, ranged int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ := io.ReadAll(r.Body)
+				if r.Method != http.MethodPost || !bytes.Equal(got, body) {
+					http.Error(w, "POST the file name", http.StatusMethodNotAllowed)
+					return
+				}
+				mu.Lock()
+				requests++
+				if r.Header.Get("Range") != "" && tt.ranges {
+					ranged++
+				}
+				mu.Unlock()
+				if !tt.ranges {
+					r.Header.Del("Range")
+				}
+				// ServeContent only answers GET and HEAD with ranges
+				r.Method = http.MethodGet
+				http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(content))
+			}))
+			defer srv.Close()
+
+			output := filepath.Join(t.TempDir(), "output")
+			if err := NewDownloader(srv.URL, output, 4, WithMethod("post", body)).Download(); err != nil {
+				t.Fatalf("Download() = %v", err)
+			}
+			if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+				t.Errorf("output holds %d bytes, want the %d of the file", len(got), len(content))
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			// the ranged probe and the chunks, or the probe and one stream
+			if ranged < tt.ranged || !tt.ranges && requests > 2 {
+				t.Errorf("%d requests, %d of them ranged, want at least %d ranged", requests, ranged, tt.ranged)
+			}
+		})
+	}
+}
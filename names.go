@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrNameTooLong is returned for an output whose name exceeds the limits of
+// common filesystems, before anything is downloaded
+var ErrNameTooLong = errors.New("file name too long")
+
+const (
+	maxNameBytes = 255  // the longest file name most filesystems accept
+	maxPathBytes = 4096 // the longest path Linux accepts
+	maxExtBytes  = 16   // the longest suffix kept as an extension when truncating
+)
+
+// safeName shortens a file name derived from a url or a server to at most
+// maxNameBytes, keeping its extension and whole UTF-8 characters
+func safeName(name string) string {
+	if len(name) <= maxNameBytes {
+		return name
+	}
+	ext := filepath.Ext(name)
+	if len(ext) > maxExtBytes || ext == name {
+		ext = ""
+	}
+	return truncateUTF8(strings.TrimSuffix(name, ext), maxNameBytes-len(ext)) + ext
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// checkNameLength fails with ErrNameTooLong when an element of path is
+// longer than maxNameBytes or the path longer than maxPathBytes
+func checkNameLength(path string) error {
+	if len(path) > maxPathBytes {
+		return fmt.Errorf("%w: the path has %d bytes, the limit is %d", ErrNameTooLong, len(path), maxPathBytes)
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(path), "/") {
+		if len(elem) > maxNameBytes {
+			return fmt.Errorf("%w: %q has %d bytes, the limit is %d", ErrNameTooLong, truncateUTF8(elem, 32)+"…", len(elem), maxNameBytes)
+		}
+	}
+	return nil
+}
+
+// checkOutputName checks the output and the longest name written next to it
+func (d *Downloader) checkOutputName() error {
+	name := d.output
+	switch {
+	case d.split > 0:
+		name = splitManifestFile(d.output)
+	case d.pieceSize > 0:
+		name = pieceHashesFile(d.output)
+	}
+	return checkNameLength(name)
+}
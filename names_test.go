@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSafeNameMultibyte(t *testing.T) {
+	tests := []struct {
+		name string
+		want int // the length in bytes of the safe name
+	}{
+		{name: strings.Repeat("é", 127) + "a", want: 255},                           // at the limit
+		{name: strings.Repeat("é", 128), want: 254},                                 // 256 bytes, a 2-byte character dropped
+		{name: strings.Repeat("日", 84) + ".iso", want: 253},                         // 256 bytes, the extension kept
+		{name: strings.Repeat("日", 85), want: 255},                                  // 255 bytes, untouched
+		{name: strings.Repeat("😀", 64), want: 252},                                  // 256 bytes of 4-byte characters
+		{name: strings.Repeat("😀", 100) + ".tar.gz", want: 255},                     // .gz kept, .tar cut off with the base
+		{name: "a" + strings.Repeat("😀", 70) + ".iso", want: 253},                   // cut back to a character boundary
+		{name: strings.Repeat("x", 300) + "." + strings.Repeat("e", 20), want: 255}, // too long to be an extension
+	}
+	for _, tt := range tests {
+		got := safeName(tt.name)
+		if len(got) != tt.want || len(got) > maxNameBytes || !utf8.ValidString(got) {
+			t.Errorf("safeName() of %d bytes = %d bytes, valid UTF-8 %v, want %d bytes", len(tt.name), len(got), utf8.ValidString(got), tt.want)
+		}
+		if ext := ext(tt.name); len(ext) <= maxExtBytes && !strings.HasSuffix(got, ext) {
+			t.Errorf("safeName() of %d bytes = %q, want the extension %q kept", len(tt.name), got, ext)
+		}
+		if err := checkNameLength(got); err != nil {
+			t.Errorf("checkNameLength() of the safe name = %v", err)
+		}
+	}
+}
+
+// ext returns the extension safeName keeps, the last one
+func ext(name string) string {
+	if i := strings.LastIndex(name, "."); i > 0 {
+		return name[i:]
+	}
+	return ""
+}
+
+func TestCheckNameLength(t *testing.T) {
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{path: "dir/" + strings.Repeat("日", 85), ok: true},
+		{path: "dir/" + strings.Repeat("日", 85) + "a"},
+		{path: strings.Repeat("é", 128) + "/file"},
+		{path: strings.Repeat(strings.Repeat("d", 200)+"/", 20) + "file", ok: true},
+		{path: strings.Repeat(strings.Repeat("d", 200)+"/", 21) + "file"},
+	}
+	for _, tt := range tests {
+		err := checkNameLength(tt.path)
+		if tt.ok && err != nil {
+			t.Errorf("checkNameLength() of %d bytes = %v, want nil", len(tt.path), err)
+		}
+		if !tt.ok && !errors.Is(err, ErrNameTooLong) {
+			t.Errorf("checkNameLength() of %d bytes = %v, want %v", len(tt.path), err, ErrNameTooLong)
+		}
+	}
+}
+
+func TestDerivedNameMultibyte(t *testing.T) {
+	long := strings.Repeat("日本語", 40) + ".iso" // 364 bytes
+	d := NewDownloader("http://example.com/"+url.PathEscape(long), "", 1)
+	for _, header := range []http.Header{
+		{"Content-Disposition": {`attachment; filename*=UTF-8''` + url.PathEscape(long)}},
+		{}, // the name of the url
+	} {
+		got, err := d.outputName(header)
+		if err != nil {
+			t.Fatalf("outputName() = %v", err)
+		}
+		if len(got) > maxNameBytes || !utf8.ValidString(got) || !strings.HasSuffix(got, ".iso") || !strings.HasPrefix(long, strings.TrimSuffix(got, ".iso")) {
+			t.Errorf("outputName() = %q, %d bytes, want a prefix of the name with .iso in at most %d bytes", got, len(got), maxNameBytes)
+		}
+		if d.output = got; d.checkOutputName() != nil {
+			t.Errorf("checkOutputName() of the derived name = %v", d.checkOutputName())
+		}
+	}
+}
+
+func TestLongOutputTempFiles(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	srv := serveContent(t, content)
+	output := filepath.Join(t.TempDir(), strings.Repeat("日", 85))
+	if err := NewDownloader(srv.URL, output, 4, WithStrategy(StrategyTempFiles), WithSegmentSize(500)).Download(); err != nil {
+		t.Fatalf("Download() to a name of %d bytes = %v", len(filepath.Base(output)), err)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+		t.Errorf("output holds %d bytes, want the %d of the file", len(got), len(content))
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// notifyTimeout bounds how long the notification command may take
+const notifyTimeout = 5 * time.Second
+
+// notifyCommand returns the command showing a desktop notification on this
+// platform: notify-send on Linux and the BSDs, osascript on macOS and a
+// PowerShell balloon tip on Windows
+func notifyCommand(ctx context.Context, title, message string) *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(message), appleScriptString(title))
+		return exec.CommandContext(ctx, "osascript", "-e", script)
+	case "windows":
+		quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+		script := "Add-Type -AssemblyName System.Windows.Forms;" +
+			"$n = New-Object System.Windows.Forms.NotifyIcon;" +
+			"$n.Icon = [System.Drawing.SystemIcons]::Information;" +
+			"$n.Visible = $true;" +
+			"$n.ShowBalloonTip(5000, " + quote(title) + ", " + quote(message) + ", 'None');" +
+			"Start-Sleep -Seconds 5; $n.Dispose()"
+		return exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	}
+	return exec.CommandContext(ctx, "notify-send", "--app-name=downloader", title, message)
+}
+
+// appleScriptString quotes s as an AppleScript string literal
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// notifyDone shows a desktop notification about the outcome of downloading
+// what and returns err unchanged. Without a notification mechanism, e.g. no
+// notify-send or no desktop session, it only logs why
+func notifyDone(what string, err error) error {
+	title, message := "Download completed", what
+	if err != nil {
+		title, message = "Download failed", fmt.Sprintf("%s: %v", what, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	if out, nerr := notifyCommand(ctx, title, message).CombinedOutput(); nerr != nil {
+		log.Printf("Cannot show a desktop notification: %v %s\n", nerr, strings.TrimSpace(string(out)))
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// PauseGate is a switch that holds the transfers of the downloaders
+// checking it while it is paused. The zero value is running
+//
+// A paused transfer stops reading its response but keeps the connection
+// open, so resuming continues where it stopped without a new request.
+// The server keeps sending until the socket buffers fill up, then TCP
+// flow control holds it, which most servers tolerate for minutes. A
+// server or proxy with a shorter idle timeout may close the connection,
+// in which case the chunk fails once resumed. Chunks that did not start
+// yet wait without sending a request
+type PauseGate struct {
+	mu     sync.Mutex
+	resume chan struct{} // closed when resumed, nil while running
+}
+
+// Pause holds the transfers until Resume is called
+func (g *PauseGate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume == nil {
+		g.resume = make(chan struct{})
+	}
+}
+
+// Resume releases the transfers held by Pause
+func (g *PauseGate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume != nil {
+		close(g.resume)
+		g.resume = nil
+	}
+}
+
+// Paused reports whether the gate is paused
+func (g *PauseGate) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resume != nil
+}
+
+// wait blocks while the gate is paused and reports whether it was
+func (g *PauseGate) wait(ctx context.Context) (bool, error) {
+	g.mu.Lock()
+	resume := g.resume
+	g.mu.Unlock()
+	if resume == nil {
+		return false, nil
+	}
+	select {
+	case <-resume:
+		return true, nil
+	case <-ctx.Done():
+		return true, ctx.Err()
+	}
+}
+
+// globalPause is the gate checked by every downloader in the process
+var globalPause PauseGate
+
+// PauseAll pauses every download of the process, in flight or queued
+func PauseAll() {
+	globalPause.Pause()
+}
+
+// ResumeAll resumes the downloads paused by PauseAll. Downloads paused
+// individually or by a shared PauseGate stay paused
+func ResumeAll() {
+	globalPause.Resume()
+}
+
+// Pause pauses this download
+func (d *Downloader) Pause() {
+	d.pause.Pause()
+}
+
+// Resume resumes this download after Pause
+func (d *Downloader) Resume() {
+	d.pause.Resume()
+}
+
+// waitResumed blocks until the global gate, the shared gate and the gates of
+// the download are all running
+func (d *Downloader) waitResumed(ctx context.Context) error {
+	for {
+		waited := false
+		for _, g := range []*PauseGate{&globalPause, d.sharedPause, &d.pause, &d.hold} {
+			if g == nil {
+				continue
+			}
+			w, err := g.wait(ctx)
+			if err != nil {
+				return err
+			}
+			waited = waited || w
+		}
+		// another gate may have been paused while waiting for one
+		if !waited {
+			return nil
+		}
+	}
+}
+
+// paused reports whether any gate of the download is paused
+func (d *Downloader) paused() bool {
+	return globalPause.Paused() || d.sharedPause != nil && d.sharedPause.Paused() || d.pause.Paused() || d.hold.Paused()
+}
+
+// pausedReader waits for the download to be resumed before every read
+type pausedReader struct {
+	r   io.Reader
+	ctx context.Context
+	d   *Downloader
+}
+
+// Read reads from the underlying reader once the download is running
+func (r *pausedReader) Read(p []byte) (int, error) {
+	if err := r.d.waitResumed(r.ctx); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// pausable makes the reads from r wait while the download is paused
+func (d *Downloader) pausable(ctx context.Context, r io.Reader) io.Reader {
+	return &pausedReader{r: r, ctx: ctx, d: d}
+}
+
+// WithPauseGate makes the download also wait while g is paused, so a
+// download manager can pause a group of downloads at once
+func WithPauseGate(g *PauseGate) Option {
+	return func(d *Downloader) {
+		d.sharedPause = g
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// PieceHashes lists the hashes of consecutive fixed-size pieces of a file,
+// so pieces can be verified independently. It is written next to the
+// output as output.pieces.json:
+//
+//	{
+//	  "file": "big.iso",
+//	  "size": 10485760,
+//	  "piece_size": 4194304,
+//	  "algorithm": "sha256",
+//	  "pieces": ["…", "…", "…"]
+//	}
+//
+// Every piece has piece_size bytes except the last one, which holds the rest
+type PieceHashes struct {
+	File      string   `json:"file"`       // the base name of the file
+	Size      int64    `json:"size"`       // the size of the file in bytes
+	PieceSize int64    `json:"piece_size"` // the size of every piece but the last
+	Algorithm string   `json:"algorithm"`  // the hash algorithm, sha1 or sha256
+	Pieces    []string `json:"pieces"`     // the hex hashes of the pieces in order
+}
+
+// pieceHashesFile returns the name of the piece hash sidecar of output
+func pieceHashesFile(output string) string {
+	return output + ".pieces.json"
+}
+
+// hashPieces computes the piece hashes of the file at path
+func hashPieces(path string, pieceSize int64, algo string) (*PieceHashes, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	p := &PieceHashes{
+		File:      filepath.Base(path),
+		Size:      info.Size(),
+		PieceSize: pieceSize,
+		Algorithm: algo,
+		Pieces:    []string{},
+	}
+	for _, r := range fixedRanges(p.Size, pieceSize) {
+		h := hashes[algo]()
+		if _, err := io.Copy(h, io.NewSectionReader(file, r[0], r[1]-r[0]+1)); err != nil {
+			return nil, err
+		}
+		p.Pieces = append(p.Pieces, hex.EncodeToString(h.Sum(nil)))
+	}
+	return p, nil
+}
+
+// loadPieceHashes reads and checks a PieceHashes file
+func loadPieceHashes(path string) (*PieceHashes, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p PieceHashes
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, ok := hashes[p.Algorithm]; !ok {
+		return nil, fmt.Errorf("%s: unsupported algorithm %q", path, p.Algorithm)
+	}
+	if p.PieceSize <= 0 || p.Size < 0 || int64(len(p.Pieces)) != (p.Size+p.PieceSize-1)/p.PieceSize {
+		return nil, fmt.Errorf("%s: %d pieces do not cover %d bytes in %d byte pieces", path, len(p.Pieces), p.Size, p.PieceSize)
+	}
+	return &p, nil
+}
+
+// pieceMatches reports whether range r of file hashes to the hex sum
+func pieceMatches(file io.ReaderAt, r [2]int64, algo, sum string) (bool, error) {
+	h := hashes[algo]()
+	if _, err := io.Copy(h, io.NewSectionReader(file, r[0], r[1]-r[0]+1)); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)) == sum, nil
+}
+
+// writePieceHashes writes the piece hash sidecar of the output
+func (d *Downloader) writePieceHashes() error {
+	log.Printf("Hashing %d byte pieces...\n", d.pieceSize)
+	p, err := hashPieces(d.output, d.pieceSize, d.pieceAlgo)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(pieceHashesFile(d.output), append(data, '\n'), 0644)
+}
+
+// WithPieceHashes writes the PieceHashes of the output with the given piece
+// size and algorithm, sha1 or sha256, once the download completes
+func WithPieceHashes(pieceSize int64, algo string) Option {
+	return func(d *Downloader) {
+		d.pieceSize = pieceSize
+		d.pieceAlgo = algo
+	}
+}
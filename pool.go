@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// tempPool keeps the segments of the tempfiles strategy in one temporary
+// file per worker slot instead of one per segment, so that many small
+// segments do not create as many files. A worker writes each of its
+// segments after the previous one in its file, and the index records
+// where, so the merge can copy the segments back in file order
+type tempPool struct {
+	mu    sync.Mutex
+	files []*os.File   // by worker slot, nil until the slot writes a segment
+	ends  []int64      // by worker slot, the end of its last completed segment
+	index []poolRecord // by segment
+}
+
+// poolRecord is the index record of a segment, Length is -1 until the
+// segment completed
+type poolRecord struct {
+	File   int   // the worker slot whose file holds the segment
+	Offset int64 // where the segment starts in the file
+	Length int64 // the bytes of the segment
+}
+
+// workerSlotKey is the context key of the slot of the worker of a chunk request
+type workerSlotKey struct{}
+
+// poolFile returns the name of the temporary file of a worker slot
+func (d *Downloader) poolFile(slot int) string {
+	return d.output + ".pool" + strconv.Itoa(slot)
+}
+
+// newTempPool starts a pool for the ranges and up to slots workers
+func newTempPool(segments, slots int) *tempPool {
+	p := &tempPool{
+		files: make([]*os.File, slots),
+		ends:  make([]int64, slots),
+		index: make([]poolRecord, segments),
+	}
+	for i := range p.index {
+		p.index[i].Length = -1
+	}
+	return p
+}
+
+// downloadSegment fetches segment i into the file of the worker running ctx,
+// after its previous segments. A failed segment leaves bytes behind its
+// last completed one, which the next segment of the worker overwrites
+func (d *Downloader) downloadSegment(ctx context.Context, p *tempPool, i int, r [2]int64) error {
+	slot, _ := ctx.Value(workerSlotKey{}).(int)
+	p.mu.Lock()
+	file := p.files[slot]
+	if file == nil {
+		var err error
+		if file, err = os.OpenFile(d.poolFile(slot), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			p.mu.Unlock()
+			return err
+		}
+		p.files[slot] = file
+	}
+	offset := p.ends[slot]
+	p.mu.Unlock()
+
+	var n int64
+	if err := d.fetchRange(ctx, r, &countingWriter{w: io.NewOffsetWriter(file, offset), n: &n}); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.index[i] = poolRecord{File: slot, Offset: offset, Length: n}
+	p.ends[slot] = offset + n
+	return nil
+}
+
+// mergePool copies the segments from the pool files into the output in order
+func (d *Downloader) mergePool(ctx context.Context, p *tempPool) error {
+	for i, r := range d.ranges {
+		if want := r[1] - r[0] + 1; p.index[i].Length != want {
+			return fmt.Errorf("segment %d holds %d bytes, expected %d", i, p.index[i].Length, want)
+		}
+	}
+	output, err := os.Create(d.output)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+	start := d.clock.Now()
+	buf := d.mergeBuffer()
+	var total int64
+	for i, rec := range p.index {
+		n, err := copyBuffer(d.diskFull(ctx, output), io.NewSectionReader(p.files[rec.File], rec.Offset, rec.Length), buf)
+		if err != nil {
+			return err
+		}
+		if n != rec.Length {
+			return fmt.Errorf("merged %d of the %d bytes of segment %d", n, rec.Length, i)
+		}
+		total += n
+	}
+	if err := output.Close(); err != nil {
+		return err
+	}
+	if total != d.size {
+		return fmt.Errorf("merged output holds %d bytes, the file %d", total, d.size)
+	}
+	d.timeMerge(start, total)
+	return nil
+}
+
+// removePool closes and deletes the pool files
+func (d *Downloader) removePool(p *tempPool) {
+	for slot, file := range p.files {
+		if file != nil {
+			file.Close()
+			os.Remove(d.poolFile(slot))
+		}
+	}
+}
+
+// WithTempFilePool makes the tempfiles strategy keep the segments in one
+// temporary file per worker, output.pool0, output.pool1, ..., instead of one
+// file per segment, e.g. with a small segment size where inodes or
+// directory entries are limited. The merge copies the segments back in
+// order from where each worker wrote them. It does not apply to split
+// outputs, whose parts are kept
+func WithTempFilePool(enabled bool) Option {
+	return func(d *Downloader) {
+		d.tempPool = enabled
+	}
+}
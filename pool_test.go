@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMergePoolOrder(t *testing.T) {
+	content := []byte("aaaabbbbccccddddee")
+	dir := t.TempDir()
+	d := NewDownloader("", filepath.Join(dir, "output"), 2)
+	d.size = int64(len(content))
+	d.ranges = fixedRanges(d.size, 4)
+
+	// slot 0 wrote segments 3 and 0, slot 1 segments 4, 2 and 1
+	p := newTempPool(len(d.ranges), 2)
+	for slot, segments := range [][]int{{3, 0}, {4, 2, 1}} {
+		file, err := os.Create(d.poolFile(slot))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.files[slot] = file
+		var offset int64
+		for _, i := range segments {
+			r := d.ranges[i]
+			n, err := file.Write(content[r[0] : r[1]+1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.index[i] = poolRecord{File: slot, Offset: offset, Length: int64(n)}
+			offset += int64(n)
+		}
+	}
+	defer d.removePool(p)
+
+	if err := d.mergePool(t.Context(), p); err != nil {
+		t.Fatalf("mergePool() = %v", err)
+	}
+	if got, _ := os.ReadFile(d.output); !bytes.Equal(got, content) {
+		t.Errorf("output holds %q, want %q", got, content)
+	}
+
+	// a segment that never completed fails the merge
+	p.index[2].Length = -1
+	if err := d.mergePool(t.Context(), p); err == nil || !strings.Contains(err.Error(), "segment 2") {
+		t.Errorf("mergePool() with an incomplete segment = %v, want an error about segment 2", err)
+	}
+}
+
+func TestTempFilePool(t *testing.T) {
+	content := make([]byte, 64<<10)
+	for i := range content {
+		content[i] = byte(i * 7 % 251)
+	}
+	// the first segments are the slowest, so the workers complete them
+	// out of order and keep writing later ones into their files
+	var mu sync.Mutex
+	var dir string
+	most := 0 // the most files next to the output while a chunk is served
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil && start > 0 {
+			time.Sleep(time.Duration(len(content)-start) * time.Millisecond / 4096)
+			entries, _ := os.ReadDir(dir)
+			mu.Lock()
+			most = max(most, len(entries))
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	dir = t.TempDir()
+	output := filepath.Join(dir, "output")
+	opts := []Option{WithStrategy(StrategyTempFiles), WithTempFilePool(true), WithSegmentSize(1 << 10)}
+	if err := NewDownloader(srv.URL, output, 4, opts...).Download(); err != nil {
+		t.Fatalf("Download() = %v", err)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+		t.Errorf("output holds %d bytes, want the %d of the file in order", len(got), len(content))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if most > 4 {
+		t.Errorf("%d files while downloading 64 segments, want one per worker", most)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%s holds %d files, want only the output", dir, len(entries))
+	}
+}
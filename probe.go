@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrProbeTimeout is returned when the server does not answer the probe
+// for range support within the probe timeout
+var ErrProbeTimeout = errors.New("probe timed out")
+
+// checkSupportRange checks if the server supports partial requests, within
+// the probe timeout if one is set. The timeout covers the probe requests
+// only, the chunks are fetched without it
+func (d *Downloader) checkSupportRange(ctx context.Context) error {
+	if d.probeTimeout <= 0 {
+		return d.probeSupport(ctx)
+	}
+	ctx, cancel := context.WithTimeoutCause(ctx, d.probeTimeout, ErrProbeTimeout)
+	defer cancel()
+	err := d.probeSupport(ctx)
+	if err != nil && errors.Is(context.Cause(ctx), ErrProbeTimeout) {
+		return fmt.Errorf("%w: no answer from %s within %v", ErrProbeTimeout, d.url, d.probeTimeout)
+	}
+	return err
+}
+
+// WithProbeTimeout bounds the time the probe for range support may take,
+// so that a server that hangs fails the download quickly. 0 means no bound
+func WithProbeTimeout(timeout time.Duration) Option {
+	return func(d *Downloader) {
+		d.probeTimeout = timeout
+	}
+}
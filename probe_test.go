@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestZeroLengthHead(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	// answers HEAD with a zero length although the file is not empty
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", "0")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	output := filepath.Join(t.TempDir(), "output")
+	d := NewDownloader(srv.URL, output, 4)
+	if err := d.Download(); err != nil {
+		t.Fatalf("Download() = %v", err)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+		t.Errorf("output holds %d bytes, want the %d of the file", len(got), len(content))
+	}
+
+	d = NewDownloader(srv.URL, output, 4, WithReprobe(false))
+	if err := d.checkSupportRange(t.Context()); err != nil {
+		t.Fatalf("checkSupportRange() without the re-probe = %v", err)
+	}
+	if d.size != 0 {
+		t.Errorf("size without the re-probe = %d, want the 0 of the HEAD", d.size)
+	}
+}
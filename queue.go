@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync/atomic"
+)
+
+// retire reports whether a worker that just finished a segment should stop
+// because of the tail ramp-down. With a ramp-down of n, once fewer than n
+// segments per active worker remain queued, workers are retired until every
+// remaining one has n segments ahead of it, down to a single worker. Workers
+// holding a connection for one last small segment otherwise only add idle
+// connections in the tail. A ramp-down of 1 retires a worker only when it
+// would find the queue empty anyway, larger values trade a slightly longer
+// tail for fewer connections
+func (d *Downloader) retire(active *int32, queued int) bool {
+	if d.rampDown <= 0 || queued == 0 {
+		return false
+	}
+	allowed := int32((queued + d.rampDown - 1) / d.rampDown)
+	for {
+		n := atomic.LoadInt32(active)
+		if n <= allowed || n <= 1 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(active, n, n-1) {
+			if atomic.AddInt32(&d.retired, 1) == 1 {
+				d.tailStart = d.clock.Now()
+			}
+			d.debugf("Retiring a worker, %d segments queued for %d workers\n", queued, n-1)
+			return true
+		}
+	}
+}
+
+// WithSegmentSize splits the file into segments of the given size that the
+// workers take from a queue, instead of one range per worker, so fast
+// connections fetch more of the file than slow ones
+func WithSegmentSize(size int64) Option {
+	return func(d *Downloader) {
+		d.segment = size
+	}
+}
+
+// WithRampDown retires workers near the end of a segmented download so
+// that each remaining worker has at least n segments queued ahead of it.
+// See retire for the curve
+func WithRampDown(n int) Option {
+	return func(d *Downloader) {
+		d.rampDown = n
+	}
+}
+
+// WithSmallFileThreshold fetches files of up to size bytes with a single
+// request instead of splitting them over the workers, where the setup of
+// more connections costs more than it gains. A batch uses 1MB unless set
+func WithSmallFileThreshold(size int64) Option {
+	return func(d *Downloader) {
+		d.smallFile = size
+	}
+}
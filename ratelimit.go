@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// requestKind classifies the requests made by the downloader so that
+// policies such as rate limiting can treat them differently
+type requestKind int
+
+const (
+	chunkRequest requestKind = iota // a ranged GET for a part of the file
+	probeRequest                    // capability detection such as the HEAD request
+	auxRequest                      // side downloads such as checksum or signature files
+)
+
+// rateLimiter is a token bucket shared by every reader it wraps, so the
+// configured rate caps the aggregate throughput of all connections
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64   // bytes per second
+	tokens float64   // bytes that may be read without waiting, negative when in debt
+	last   time.Time // the last time tokens were refilled, zero before the first read
+}
+
+// newRateLimiter creates a rateLimiter allowing rate bytes per second
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: float64(rate)}
+}
+
+// wait accounts for n bytes that have been read and sleeps on clock until
+// the average rate is back under the limit
+func (l *rateLimiter) wait(n int, clock Clock) {
+	l.mu.Lock()
+	now := clock.Now()
+	if l.last.IsZero() {
+		l.last = now
+	}
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		// never allow more than one second of burst
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if delay > 0 {
+		<-clock.After(delay)
+	}
+}
+
+// limitedReader throttles reads from the underlying body through a rateLimiter
+type limitedReader struct {
+	io.ReadCloser
+	limiter *rateLimiter
+	clock   Clock
+}
+
+// Read reads at most one second worth of bytes and waits for the limiter
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if max := int(r.limiter.rate); max > 0 && len(p) > max {
+		p = p[:max]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.limiter.wait(n, r.clock)
+	}
+	return n, err
+}
+
+// wrapBody wraps a response body so its bytes count towards the bytes
+// transferred and the byte quota, and are throttled by the rate limiter when
+// requests of the given kind count against the rate budget. By default chunk
+// requests and auxiliary downloads are limited while probes are not, since
+// the probe responses are tiny and throttling them only delays the start
+func (d *Downloader) wrapBody(body io.ReadCloser, kind requestKind) io.ReadCloser {
+	body = &countingReader{ReadCloser: body, n: &d.transferred, limit: d.quota}
+	limiter := d.rateLimiter()
+	if limiter == nil {
+		return body
+	}
+	switch kind {
+	case probeRequest:
+		if !d.limitProbes {
+			return body
+		}
+	case auxRequest:
+		if !d.limitAux {
+			return body
+		}
+	}
+	return &limitedReader{ReadCloser: body, limiter: limiter, clock: d.clock}
+}
+
+// WithRateLimit caps the total download rate to the given bytes per second.
+// The limit is shared by every downloader given this option, so the
+// parallel downloads of a batch share the rate rather than each getting it
+func WithRateLimit(rate int64) Option {
+	var limiter *rateLimiter
+	if rate > 0 {
+		limiter = newRateLimiter(rate)
+	}
+	return func(d *Downloader) {
+		if limiter != nil {
+			d.limiter = limiter
+		}
+	}
+}
+
+// WithProbeRateLimit sets whether probe requests count against the rate
+// limit. Disabled by default
+func WithProbeRateLimit(enabled bool) Option {
+	return func(d *Downloader) {
+		d.limitProbes = enabled
+	}
+}
+
+// WithAuxRateLimit sets whether auxiliary downloads such as checksum and
+// signature files count against the rate limit. Enabled by default
+func WithAuxRateLimit(enabled bool) Option {
+	return func(d *Downloader) {
+		d.limitAux = enabled
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterClock(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	l := newRateLimiter(1000)
+
+	done := make(chan struct{})
+	go func() {
+		// half a second of debt
+		l.wait(500, clock)
+		close(done)
+	}()
+	waitTimer(clock)
+	clock.Advance(400 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("wait() returned after 400ms, want the debt of 500ms paid")
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Advance(100 * time.Millisecond)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait() did not return once the clock advanced 500ms")
+	}
+
+	// idling refills at most a second of tokens
+	clock.Advance(5 * time.Second)
+	l.wait(1000, clock)
+	if clock.Waiting() != 0 {
+		t.Fatal("wait() of a second of tokens after idling started a timer, want none")
+	}
+	go l.wait(100, clock)
+	for deadline := time.Now().Add(5 * time.Second); clock.Waiting() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("wait() past the second of tokens did not wait")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(100 * time.Millisecond)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// orderedReader reads the temporary files of the segments in order, each as
+// soon as its download completed, so the start of the file can be consumed
+// while later segments are still downloading. Every file is removed once it
+// has been read, which frees a slot of the window for the next download
+type orderedReader struct {
+	d      *Downloader
+	ctx    context.Context
+	done   []chan error // signalled once when the download of a segment ends
+	window chan struct{}
+	i      int      // the segment being read
+	file   *os.File // the temporary file of segment i once it is complete
+}
+
+// Read reads from the temporary file of the current segment, waiting for
+// its download to complete first
+func (r *orderedReader) Read(p []byte) (int, error) {
+	for r.i < len(r.done) {
+		if r.file == nil {
+			select {
+			case err := <-r.done[r.i]:
+				if err != nil {
+					log.Printf("Error downloading chunk %d: %v\n", r.i, err)
+					return 0, err
+				}
+			case <-r.ctx.Done():
+				return 0, r.ctx.Err()
+			}
+			file, err := os.Open(r.d.chunkFile(r.i))
+			if err != nil {
+				return 0, err
+			}
+			r.file = file
+		}
+		n, err := r.file.Read(p)
+		if err == io.EOF {
+			r.file.Close()
+			os.Remove(r.d.chunkFile(r.i))
+			r.file = nil
+			r.i++
+			<-r.window
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+	return 0, io.EOF
+}
+
+// Close closes the current temporary file and removes those not read yet
+func (r *orderedReader) Close() error {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	for i := r.i; i < len(r.done); i++ {
+		os.Remove(r.d.chunkFile(i))
+	}
+	return nil
+}
+
+// downloadOrderedFiles downloads the segments of a streamed output into
+// temporary files and writes them to the output in order, each as soon as
+// it is complete. Up to concurrency segments, or the read-ahead with
+// WithReadAhead, are kept on disk ahead of the one being written, which
+// bounds the disk space instead of the memory of downloadOrdered
+func (d *Downloader) downloadOrderedFiles(ctx context.Context, sum *checksum) error {
+	out, err := d.createOutput(sum, d.size)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	d.segments = make([]segmentState, len(d.ranges))
+	size := d.concurrency
+	if d.readAhead > 0 {
+		size = d.readAhead + 1
+	}
+	r := &orderedReader{
+		d:      d,
+		ctx:    ctx,
+		done:   make([]chan error, len(d.ranges)),
+		window: make(chan struct{}, size),
+	}
+	for i := range r.done {
+		r.done[i] = make(chan error, 1)
+	}
+	// the last download must have stopped writing its file before Close
+	// removes it
+	running := make(chan struct{})
+	defer func() {
+		cancel()
+		<-running
+		r.Close()
+	}()
+	conns := make(chan struct{}, d.concurrency)
+	go func() {
+		defer close(running)
+		finished := make(chan struct{}, len(d.ranges))
+		started := 0
+		defer func() {
+			for ; started > 0; started-- {
+				<-finished
+			}
+		}()
+		for i := range d.ranges {
+			select {
+			case r.window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			idx := i
+			started++
+			go func() {
+				defer func() { finished <- struct{}{} }()
+				select {
+				case conns <- struct{}{}:
+				case <-ctx.Done():
+					r.done[idx] <- ctx.Err()
+					return
+				}
+				defer func() { <-conns }()
+				r.done[idx] <- d.runSegment(ctx, idx, -1, func(ctx context.Context, i int, rng [2]int64) error {
+					if err := d.downloadChunk(ctx, d.chunkFile(i), rng); err != nil {
+						return err
+					}
+					info, err := os.Stat(d.chunkFile(i))
+					if err != nil {
+						return err
+					}
+					if want := rng[1] - rng[0] + 1; info.Size() != want {
+						return fmt.Errorf("chunk %d: received %d bytes, expected %d", i, info.Size(), want)
+					}
+					return nil
+				})
+			}()
+		}
+	}()
+
+	if _, err := copyBuffer(d.diskFull(ctx, out), r, d.mergeBuffer()); err != nil {
+		return err
+	}
+	return out.Close()
+}
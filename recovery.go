@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// unrecoverable are the failures of a download that continuing cannot fix,
+// since the bytes received are the wrong ones or the output is refused
+var unrecoverable = []error{
+	ErrChecksumMismatch, ErrSizeMismatch, ErrErrorPage, ErrNotLocked, ErrSymlinkOutput, ErrNameTooLong,
+}
+
+// noteResumable records how much of the output a download that failed with
+// err left for WithContinue to continue. Only an output holding a prefix
+// of the file qualifies, which is what the single stream and the stream
+// strategy write, the writeat and tempfiles strategies remove theirs. It
+// must have been written by this run, a file left at the output earlier by
+// anything else is not part of this download
+func (d *Downloader) noteResumable(err error) {
+	if !d.fileOutput() || d.split > 0 || !d.wroteOutput {
+		return
+	}
+	for _, e := range unrecoverable {
+		if errors.Is(err, e) {
+			return
+		}
+	}
+	info, statErr := os.Lstat(d.output)
+	if statErr != nil || !info.Mode().IsRegular() || info.Size() == 0 || d.size > 0 && info.Size() >= d.size {
+		return
+	}
+	d.resumable = info.Size()
+}
+
+// logRecovery logs how to continue the failed download of d started by the
+// command line args, if its output can be continued
+func logRecovery(d *Downloader, args []string) {
+	res := d.Result()
+	if res.Resumable == 0 {
+		return
+	}
+	if res.Size > 0 {
+		log.Printf("Kept %d of %d bytes (%.1f%%) in %s, continue with:\n", res.Resumable, res.Size, 100*float64(res.Resumable)/float64(res.Size), d.Output())
+	} else {
+		log.Printf("Kept %d bytes in %s, continue with:\n", res.Resumable, d.Output())
+	}
+	log.Printf("  %s\n", recoveryCommand(args, d.Output()))
+}
+
+// recoveryCommand returns args with -continue and the output saved to, so
+// that it continues an output picked by the downloader as well
+func recoveryCommand(args []string, output string) string {
+	cmd := []string{filepath.Base(args[0])}
+	for i := 1; i < len(args); i++ {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		switch {
+		case !strings.HasPrefix(args[i], "-"):
+		case name == "continue":
+			continue
+		case name == "output":
+			if !hasValue {
+				i++
+			}
+			continue
+		}
+		cmd = append(cmd, shellQuote(args[i]))
+	}
+	return strings.Join(append(cmd, "-continue", "-output", shellQuote(output)), " ")
+}
+
+// shellQuote quotes s for a POSIX shell when it holds anything but safe characters
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+,.:/@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
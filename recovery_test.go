@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestResumableOnlyForOwnOutput(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output")
+	if err := os.WriteFile(output, []byte("left by something else"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	d := NewDownloader(srv.URL, output, 2)
+	if err := d.Download(); err == nil {
+		t.Fatal("Download() = nil, want an error")
+	}
+	if got := d.Result().Resumable; got != 0 {
+		t.Errorf("Resumable = %d for an output this run did not write, want 0", got)
+	}
+}
+
+func TestResumableAfterBrokenStream(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	half := len(content) / 2
+	// no ranges, so the file comes in a single stream, which breaks off
+	// half way through
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write(content[:half])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer srv.Close()
+
+	output := filepath.Join(t.TempDir(), "output")
+	d := NewDownloader(srv.URL, output, 2)
+	if err := d.Download(); err == nil {
+		t.Fatal("Download() = nil, want an error")
+	}
+	if got := d.Result().Resumable; got != int64(half) {
+		t.Errorf("Resumable = %d, want the %d bytes received", got, half)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrUnexpectedRedirect is returned when a chunk request is redirected after
+// the probe fixed the url and size of the file, or an http request is
+// redirected to another scheme
+var ErrUnexpectedRedirect = errors.New("unexpected redirect")
+
+// noRedirectsKey marks the context of requests that must not follow redirects
+type noRedirectsKey struct{}
+
+// withoutRedirects returns a context whose requests fail instead of
+// following a redirect
+func withoutRedirects(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRedirectsKey{}, true)
+}
+
+// sensitiveHeaders are the request headers carrying credentials, which are
+// not sent on to another origin unless redirects are trusted
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Cookie2"}
+
+// sameOrigin reports whether a and b have the same scheme, host and port
+func sameOrigin(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host)
+}
+
+// webScheme reports whether scheme is http or https
+func webScheme(scheme string) bool {
+	return strings.EqualFold(scheme, "http") || strings.EqualFold(scheme, "https")
+}
+
+// stripCredentials removes the sensitive headers from header
+func stripCredentials(header http.Header) {
+	for _, k := range sensitiveHeaders {
+		header.Del(k)
+	}
+}
+
+// ErrTooManyRedirects is returned when a request is redirected more often
+// than WithMaxRedirects allows
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// defaultMaxRedirects is the most redirects a request follows by default,
+// as for net/http
+const defaultMaxRedirects = 10
+
+// withRedirectCheck returns a copy of client refusing redirects for the
+// requests marked by withoutRedirects, and otherwise deciding like client
+// within the redirects WithMaxRedirects allows. A redirect from an http or
+// https url to any other scheme fails, so a server cannot make the client
+// read a local file: or another handler's url into the output; those are
+// only fetched when given directly. A redirect to another origin than the
+// first request drops the credentials unless redirects are
+// trusted, in which case they are sent on even where net/http would drop
+// them, e.g. to another domain. Every redirect followed is counted for the
+// result and recorded on the download span
+func (d *Downloader) withRedirectCheck(client *http.Client) *http.Client {
+	c := *client
+	next := client.CheckRedirect
+	limit := d.maxRedirects
+	if limit <= 0 {
+		limit = defaultMaxRedirects
+	}
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.Context().Value(noRedirectsKey{}) != nil {
+			return fmt.Errorf("%w of a chunk request to %s", ErrUnexpectedRedirect, req.URL.Redacted())
+		}
+		if webScheme(via[len(via)-1].URL.Scheme) && !webScheme(req.URL.Scheme) {
+			return fmt.Errorf("%w from %s to a %s url", ErrUnexpectedRedirect, via[len(via)-1].URL.Redacted(), req.URL.Scheme)
+		}
+		// via holds the requests made so far, following req makes that
+		// many redirects. The CheckRedirect of a client given with
+		// WithHTTPClient replaces the default limit, not WithMaxRedirects
+		n := len(via)
+		if (next == nil || d.maxRedirects > 0) && n > limit {
+			return fmt.Errorf("%w: stopped after %d redirects at %s", ErrTooManyRedirects, limit, req.URL.Redacted())
+		}
+		if next != nil {
+			if err := next(req, via); err != nil {
+				return err
+			}
+		}
+		switch {
+		case d.trustRedirects:
+			for _, k := range sensitiveHeaders {
+				if v := via[0].Header.Values(k); len(v) > 0 && req.Header.Get(k) == "" {
+					req.Header[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+				}
+			}
+		case !sameOrigin(req.URL, via[0].URL):
+			stripCredentials(req.Header)
+		}
+		d.noteRedirect(n, req.URL)
+		return nil
+	}
+	return &c
+}
+
+// noteRedirect counts the n-th redirect of a request, to u
+func (d *Downloader) noteRedirect(n int, u *url.URL) {
+	atomic.AddInt32(&d.redirects, 1)
+	for {
+		longest := atomic.LoadInt32(&d.redirectChain)
+		if int32(n) <= longest || atomic.CompareAndSwapInt32(&d.redirectChain, longest, int32(n)) {
+			break
+		}
+	}
+	d.debugf("Redirect %d of the request to %s\n", n, u.Redacted())
+	d.traceEvent("redirect", Attribute{"redirect.count", int64(n)}, Attribute{"redirect.url", u.Redacted()})
+}
+
+// revalidate checks that a chunk response that was redirected to another
+// url still belongs to a file of the probed size, since writing bytes of a
+// different file would silently corrupt the output
+func (d *Downloader) revalidate(resp *http.Response) error {
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("%w to %s: status %s", ErrUnexpectedRedirect, resp.Request.URL.Redacted(), resp.Status)
+	}
+	cr, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return fmt.Errorf("%w to %s: %v", ErrUnexpectedRedirect, resp.Request.URL.Redacted(), err)
+	}
+	if cr.Size != d.size {
+		return fmt.Errorf("%w to %s: file has %d bytes, expected %d", ErrUnexpectedRedirect, resp.Request.URL.Redacted(), cr.Size, d.size)
+	}
+	d.debugf("Chunk request redirected to %s, same size\n", resp.Request.URL.Redacted())
+	return nil
+}
+
+// WithChunkRedirects lets chunk requests follow redirects as long as they
+// lead to a file of the probed size. By default any redirect of a chunk
+// request fails the download, since the probe already resolved the url
+func WithChunkRedirects(enabled bool) Option {
+	return func(d *Downloader) {
+		d.chunkRedirects = enabled
+	}
+}
+
+// WithTrustedRedirects sets whether the credentials of a request, its
+// Authorization and Cookie headers, are sent on when a redirect leads to
+// another origin, i.e. another scheme, host or port, like curl's
+// --location-trusted. Disabled by default, they are dropped so a redirect
+// cannot leak them to a third party, and once the probe resolved the url to
+// another origin the requests to it leave them out too
+func WithTrustedRedirects(enabled bool) Option {
+	return func(d *Downloader) {
+		d.trustRedirects = enabled
+	}
+}
+
+// WithMaxRedirects caps the redirects one request may follow, failing it
+// with ErrTooManyRedirects beyond n, so a redirect loop or an overly long
+// chain is reported instead of followed. n <= 0 keeps the default of 10,
+// which the CheckRedirect of a client given with WithHTTPClient replaces;
+// n > 0 applies to such a client too
+func WithMaxRedirects(n int) Option {
+	return func(d *Downloader) {
+		d.maxRedirects = n
+	}
+}
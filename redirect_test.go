@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// credentialServer serves content at /file, recording the credentials of
+// every request for it, and redirects /to?url=... to the url
+type credentialServer struct {
+	*httptest.Server
+	mu   sync.Mutex
+	seen []string // the Authorization and Cookie of every request for /file
+}
+
+func newCredentialServer(t *testing.T, content []byte) *credentialServer {
+	t.Helper()
+	s := &credentialServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/to" {
+			http.Redirect(w, r, r.URL.Query().Get("url"), http.StatusFound)
+			return
+		}
+		s.mu.Lock()
+		s.seen = append(s.seen, r.Method+" "+r.Header.Get("Authorization")+" "+r.Header.Get("Cookie"))
+		s.mu.Unlock()
+		http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(content))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestRedirectCredentials(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	origin := newCredentialServer(t, content)
+	// another port, so another origin
+	other := newCredentialServer(t, content)
+	header := http.Header{"Authorization": {"Bearer secret"}, "Cookie": {"session=1"}}
+
+	tests := []struct {
+		name   string
+		server *credentialServer // the server the redirect leads to
+		opts   []Option
+		want   string // the credentials every request for the file must carry
+	}{
+		{name: "cross-origin drops them", server: other, want: " "},
+		{name: "same origin keeps them", server: origin, want: "Bearer secret session=1"},
+		{name: "trusted cross-origin keeps them", server: other, opts: []Option{WithTrustedRedirects(true)}, want: "Bearer secret session=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.server.mu.Lock()
+			tt.server.seen = nil
+			tt.server.mu.Unlock()
+			output := filepath.Join(t.TempDir(), "output")
+			opts := append([]Option{WithHeaders(header)}, tt.opts...)
+			d := NewDownloader(origin.URL+"/to?url="+tt.server.URL+"/file", output, 2, opts...)
+			if err := d.Download(); err != nil {
+				t.Fatalf("Download() = %v", err)
+			}
+
+			tt.server.mu.Lock()
+			defer tt.server.mu.Unlock()
+			// the HEAD probe is redirected, the chunks go to the resolved url
+			if len(tt.server.seen) < 3 {
+				t.Fatalf("got %d requests for the file, want the probe and 2 chunks: %q", len(tt.server.seen), tt.server.seen)
+			}
+			for _, got := range tt.server.seen {
+				method, credentials, _ := strings.Cut(got, " ")
+				if credentials != tt.want {
+					t.Errorf("%s carried credentials %q, want %q", method, credentials, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxRedirects(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	// /chain/n redirects to /chain/n-1 down to /chain/0, the file
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/chain/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if n > 0 {
+			http.Redirect(w, r, "/chain/"+strconv.Itoa(n-1), http.StatusFound)
+			return
+		}
+		http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	for _, limit := range []int{0, 3} {
+		want := limit
+		if want == 0 {
+			want = defaultMaxRedirects
+		}
+		t.Run("limit "+strconv.Itoa(limit), func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "output")
+			d := NewDownloader(srv.URL+"/chain/"+strconv.Itoa(want), output, 2, WithMaxRedirects(limit))
+			if err := d.Download(); err != nil {
+				t.Fatalf("Download() of a chain of %d = %v", want, err)
+			}
+			if res := d.Result(); res.RedirectChain != want || res.Redirects < want {
+				t.Errorf("Result() has Redirects %d and RedirectChain %d, want a chain of %d", res.Redirects, res.RedirectChain, want)
+			}
+
+			d = NewDownloader(srv.URL+"/chain/"+strconv.Itoa(want+1), output, 2, WithMaxRedirects(limit))
+			err := d.Download()
+			if !errors.Is(err, ErrTooManyRedirects) {
+				t.Fatalf("Download() of a chain of %d = %v, want %v", want+1, err, ErrTooManyRedirects)
+			}
+			if retryable(err) {
+				t.Errorf("retryable(%v) = true, want false", err)
+			}
+			if got := exitCode(err); got != exitRedirect {
+				t.Errorf("exitCode(%v) = %d, want %d", err, got, exitRedirect)
+			}
+		})
+	}
+}
+
+func TestRedirectToLocalScheme(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	srv := newCredentialServer(t, content)
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secret, []byte("a local secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []string{(&url.URL{Scheme: "file", Path: filepath.ToSlash(secret)}).String(), "data:,a%20local%20secret"} {
+		t.Run(target[:4], func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "output")
+			err := NewDownloader(srv.URL+"/to?url="+url.QueryEscape(target), output, 2).Download()
+			if !errors.Is(err, ErrUnexpectedRedirect) {
+				t.Fatalf("Download() redirected to %s = %v, want %v", target, err, ErrUnexpectedRedirect)
+			}
+			if retryable(err) {
+				t.Errorf("retryable(%v) = true, want false", err)
+			}
+			if data, err := os.ReadFile(output); err == nil && bytes.Contains(data, []byte("secret")) {
+				t.Errorf("the output holds %q, want the redirect refused", data)
+			}
+		})
+	}
+
+	// given directly, a file: url is still fetched
+	output := filepath.Join(dir, "output")
+	if err := NewDownloader((&url.URL{Scheme: "file", Path: filepath.ToSlash(secret)}).String(), output, 2).Download(); err != nil {
+		t.Fatalf("Download() of the file url = %v", err)
+	}
+}
+
+func TestChunkRedirect(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	other := bytes.Repeat([]byte("abcdefghij"), 500)
+	tests := []struct {
+		name string
+		to   []byte
+		opts []Option
+		ok   bool
+	}{
+		{name: "refused by default", to: content},
+		{name: "another file", to: other, opts: []Option{WithChunkRedirects(true)}},
+		{name: "the same size", to: content, opts: []Option{WithChunkRedirects(true)}, ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the probe sees /file, its chunk requests are redirected to /to
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/to":
+					http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(tt.to))
+				case r.Method == http.MethodGet:
+					http.Redirect(w, r, "/to", http.StatusFound)
+				default:
+					http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(content))
+				}
+			}))
+			defer srv.Close()
+			output := filepath.Join(t.TempDir(), "output")
+			err := NewDownloader(srv.URL+"/file", output, 4, tt.opts...).Download()
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("Download() = %v", err)
+				}
+				if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+					t.Errorf("output holds %d bytes, want the %d of the file", len(got), len(content))
+				}
+				return
+			}
+			if !errors.Is(err, ErrUnexpectedRedirect) {
+				t.Fatalf("Download() = %v, want %v", err, ErrUnexpectedRedirect)
+			}
+			if got, err := os.ReadFile(output); err == nil && bytes.Contains(got, other[:100]) {
+				t.Errorf("output holds bytes of the other file")
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRenameInTargetDir checks that every file renamed over its target is
+// written in the directory of the target, the one place sure to be on the
+// same volume, so the rename stays atomic. With the temporary directory of
+// the system unusable nothing may depend on it
+func TestRenameInTargetDir(t *testing.T) {
+	base := t.TempDir()
+	// mkdir makes a directory of base, t.TempDir would use TMPDIR
+	mkdir := func(name string) string {
+		dir := filepath.Join(base, name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	t.Setenv("TMPDIR", filepath.Join(base, "missing"))
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+
+	// seen records the hidden files next to the target while a GET is served
+	var mu sync.Mutex
+	var dir string
+	var seen []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			entries, _ := os.ReadDir(dir)
+			mu.Lock()
+			for _, e := range entries {
+				if strings.HasPrefix(e.Name(), ".") {
+					seen = append(seen, e.Name())
+				}
+			}
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		run  func(target string) error
+		temp string // the temporary file seen during the download, "" when there is none
+	}{
+		{name: "watch", temp: ".target.watch", run: func(target string) error {
+			w := &watcher{url: srv.URL, output: target, concurrency: 2, probe: NewDownloader(srv.URL, target, 1)}
+			return w.download(t.Context())
+		}},
+		{name: "extra output", temp: ".target.copy", run: func(target string) error {
+			return NewDownloader(srv.URL, filepath.Join(mkdir("extra"), "output"), 2, WithExtraOutputs(target)).Download()
+		}},
+		{name: "replaced link", run: func(target string) error {
+			if err := os.Symlink(filepath.Join(base, "elsewhere"), target); err != nil {
+				t.Skipf("cannot create a symbolic link: %v", err)
+			}
+			return NewDownloader(srv.URL, target, 2, WithSymlinkPolicy(SymlinkReplace)).Download()
+		}},
+		{name: "cache", run: func(target string) error {
+			c, err := LoadCache(target, time.Hour)
+			if err != nil {
+				return err
+			}
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.save()
+		}},
+		{name: "batch report", run: func(target string) error {
+			return NewBatchReport(target).write()
+		}},
+		{name: "batch state", run: func(target string) error {
+			s, err := LoadBatchState(target)
+			if err != nil {
+				return err
+			}
+			return s.save()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			dir, seen = mkdir(tt.name), nil
+			mu.Unlock()
+			target := filepath.Join(dir, "target")
+			if err := tt.run(target); err != nil {
+				t.Fatalf("writing %s = %v", target, err)
+			}
+			if info, err := os.Lstat(target); err != nil || !info.Mode().IsRegular() {
+				t.Fatalf("%s is %v, %v, want a regular file", target, info, err)
+			}
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 1 {
+				t.Errorf("%s holds %d files after the rename, want only the target", dir, len(entries))
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if tt.temp != "" && !strings.Contains(strings.Join(seen, " "), tt.temp) {
+				t.Errorf("the files next to the target while downloading were %q, want %s", seen, tt.temp)
+			}
+		})
+	}
+}
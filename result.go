@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// DownloadResult holds statistics about a download
+type DownloadResult struct {
+	Size          int64             // the size of the file in bytes
+	Transferred   int64             // the bytes read from the network, including probes and retried data
+	Retired       int               // the workers retired early by the tail ramp-down
+	Tail          time.Duration     // the time from the first retirement to the last chunk finishing
+	Connections   []ConnectionStats // the work of every chunk worker, each using one connection at a time, none for the stream strategy
+	Timing        TimingStats       // the latency breakdown of all requests, with WithTraceTiming
+	Retries       int               // the chunk requests retried
+	Waited        time.Duration     // the time spent waiting before retries, summed over the workers
+	Useful        int64             // the bytes of the file this download received, without repeats or bytes kept from an earlier run, once completed
+	Elapsed       time.Duration     // the wall time of the download so far
+	Merge         time.Duration     // the time spent merging temporary files into the output
+	Resumable     int64             // the bytes of the output a failed download left for WithContinue, 0 when it cannot be continued
+	Redirects     int               // the redirects followed by all requests
+	RedirectChain int               // the most redirects one request followed
+}
+
+// ConnectionStats holds what one chunk worker transferred. A worker much
+// slower than the others points at a throttled path or an unbalanced split
+type ConnectionStats struct {
+	Worker int           // the index of the worker
+	Chunks int           // the chunks the worker completed
+	Bytes  int64         // the bytes of chunk bodies the worker read
+	Active time.Duration // the time the worker spent on completed and failed chunks
+	Timing TimingStats   // the latency breakdown of the requests of the worker, with WithTraceTiming
+}
+
+// Rate returns the bytes per second of the worker while it was active
+func (s ConnectionStats) Rate() float64 {
+	if s.Active <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Active.Seconds()
+}
+
+// workerStats accumulates the ConnectionStats of a worker, accessed atomically
+type workerStats struct {
+	chunks int32
+	bytes  int64
+	active int64 // nanoseconds
+	timing timingCounters
+}
+
+// workerKey is the context key of the workerStats of a chunk request
+type workerKey struct{}
+
+// countWorker makes body count its bytes for the worker running ctx, if any
+func countWorker(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	if ws, ok := ctx.Value(workerKey{}).(*workerStats); ok {
+		return &countingReader{ReadCloser: body, n: &ws.bytes}
+	}
+	return body
+}
+
+// logConnections logs a table of the work of every worker
+func logConnections(conns []ConnectionStats) {
+	if len(conns) == 0 {
+		return
+	}
+	log.Println("Worker  Chunks       Bytes      Active      MiB/s")
+	for _, c := range conns {
+		log.Printf("%6d  %6d  %10d  %10v  %9.2f\n", c.Worker, c.Chunks, c.Bytes, c.Active.Round(time.Millisecond), c.Rate()/(1<<20))
+	}
+}
+
+// Overhead returns the bytes transferred per byte of the file. Values well
+// above 1 reveal data wasted on retries and re-downloads
+func (r DownloadResult) Overhead() float64 {
+	if r.Size <= 0 {
+		return 0
+	}
+	return float64(r.Transferred) / float64(r.Size)
+}
+
+// Result returns the statistics of the download so far
+func (d *Downloader) Result() DownloadResult {
+	res := DownloadResult{
+		Size:          d.size,
+		Transferred:   atomic.LoadInt64(&d.transferred),
+		Retired:       int(atomic.LoadInt32(&d.retired)),
+		Timing:        d.timing.stats(),
+		Retries:       int(atomic.LoadInt32(&d.retries)),
+		Waited:        time.Duration(atomic.LoadInt64(&d.waited)),
+		Merge:         time.Duration(atomic.LoadInt64(&d.merged)),
+		Resumable:     d.resumable,
+		Redirects:     int(atomic.LoadInt32(&d.redirects)),
+		RedirectChain: int(atomic.LoadInt32(&d.redirectChain)),
+	}
+	if !d.started.IsZero() {
+		if d.ended.IsZero() {
+			res.Elapsed = d.clock.Now().Sub(d.started)
+		} else {
+			res.Elapsed = d.ended.Sub(d.started)
+			res.Useful = d.size - d.kept
+		}
+	}
+	for i, ws := range d.workers {
+		res.Connections = append(res.Connections, ConnectionStats{
+			Worker: i,
+			Chunks: int(atomic.LoadInt32(&ws.chunks)),
+			Bytes:  atomic.LoadInt64(&ws.bytes),
+			Active: time.Duration(atomic.LoadInt64(&ws.active)),
+			Timing: ws.timing.stats(),
+		})
+	}
+	if res.Retired > 0 && !d.finished.IsZero() {
+		res.Tail = d.finished.Sub(d.tailStart)
+	}
+	return res
+}
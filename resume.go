@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// continueOutput completes an output of which the first offset bytes are
+// already on disk, without any metadata about where they came from. A fresh
+// probe provides a validator that is sent as If-Range with a request for
+// the rest of the file, so the server only returns the missing bytes when the
+// file did not change since the probe. A 206 response is appended, a 200
+// response means the partial output cannot be trusted and it is replaced.
+// A server that no longer supports ranges, e.g. another mirror than the one
+// the partial output came from, cannot send the missing bytes alone, so
+// the partial output is discarded and the file downloaded from the start
+func (d *Downloader) continueOutput(ctx context.Context, offset int64) error {
+	log.Printf("Found %d bytes of %s, checking the server...\n", offset, d.output)
+	probeErr := d.checkSupportRange(ctx)
+	if probeErr != nil && probeErr != ErrRangeNotSupported {
+		return probeErr
+	}
+	if err := d.confirm(probeErr == nil); err != nil {
+		return err
+	}
+	if d.size > 0 {
+		if err := d.checkExpectedSize(d.size); err != nil {
+			return err
+		}
+	}
+	var err error
+	if probeErr == ErrRangeNotSupported {
+		// verifying the partial output would need ranges as well
+		log.Printf("Server does not support range requests any more, discarding the %d bytes of %s and downloading it with a single stream\n", offset, d.output)
+		offset = 0
+	} else if offset, err = d.verifyPartial(ctx, offset); err != nil {
+		return err
+	}
+	if d.size > 0 && offset == d.size {
+		log.Println("Output is already complete")
+		d.kept = offset
+		return nil
+	}
+	if d.size > 0 && offset > d.size {
+		log.Printf("Output is larger than the %d byte file, downloading it again\n", d.size)
+		offset = 0
+	}
+
+	req, err := d.newRequest(ctx, "GET")
+	if err != nil {
+		return err
+	}
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// a weak ETag cannot be used with If-Range
+		if d.etag != "" && !strings.HasPrefix(d.etag, "W/") {
+			req.Header.Set("If-Range", d.etag)
+		} else if d.modified != "" {
+			req.Header.Set("If-Range", d.modified)
+		}
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	body := d.wrapBody(resp.Body, chunkRequest)
+	defer body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		cr, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return err
+		}
+		if cr.Start != offset {
+			return fmt.Errorf("server resumed at %d instead of %d", cr.Start, offset)
+		}
+		log.Printf("Continuing at byte %d\n", offset)
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		if offset > 0 {
+			log.Println("Server sent the whole file, the file changed or ranges are not supported, starting over")
+		}
+		offset = 0
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		cr, err := parseContentRange(strings.Replace(resp.Header.Get("Content-Range"), "*", "0-0", 1))
+		if err == nil && cr.Size == offset {
+			d.size, d.kept = offset, offset
+			log.Println("Output is already complete")
+			return nil
+		}
+		return fmt.Errorf("cannot continue at byte %d: %s", offset, resp.Status)
+	default:
+		return statusError(resp)
+	}
+
+	file, err := os.OpenFile(d.output, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	d.wroteOutput = true
+	n, err := io.Copy(d.diskFull(ctx, file), d.pausable(ctx, body))
+	if err != nil {
+		return err
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Errorf("received %d bytes, expected %d", n, resp.ContentLength)
+	}
+	d.size, d.kept = offset+n, offset
+	return file.Close()
+}
+
+// ResumeVerify selects how much of a partial output is checked before it
+// is continued
+type ResumeVerify string
+
+const (
+	// ResumeVerifyNone trusts every byte already on disk. It costs nothing
+	ResumeVerifyNone ResumeVerify = "none"
+	// ResumeVerifyLastBlock discards the block holding the last byte on
+	// disk and downloads it again, since an interrupted write most likely
+	// damaged the end of the output. It costs one block of transfer, the
+	// piece size of the piece hashes or 1MiB without them
+	ResumeVerifyLastBlock ResumeVerify = "last-block"
+	// ResumeVerifyFull hashes every complete piece on disk against the
+	// piece hashes and downloads the pieces that do not match again. It
+	// costs a read of the whole partial output plus the mismatched pieces
+	ResumeVerifyFull ResumeVerify = "full"
+)
+
+// defaultResumeBlock is the block discarded by ResumeVerifyLastBlock
+// without piece hashes
+const defaultResumeBlock = 1 << 20
+
+// ParseResumeVerify parses the name of a ResumeVerify level
+func ParseResumeVerify(s string) (ResumeVerify, error) {
+	switch v := ResumeVerify(s); v {
+	case ResumeVerifyNone, ResumeVerifyLastBlock, ResumeVerifyFull:
+		return v, nil
+	}
+	return "", fmt.Errorf("unknown resume verification %q, expected none, last-block or full", s)
+}
+
+// verifyPartial checks the first size bytes of the output according to the
+// resume verification level and returns how many of them can be trusted,
+// truncating the output to that size
+func (d *Downloader) verifyPartial(ctx context.Context, size int64) (int64, error) {
+	var pieces *PieceHashes
+	if d.resumePieces != "" {
+		p, err := loadPieceHashes(d.resumePieces)
+		if err != nil {
+			return 0, err
+		}
+		if d.size > 0 && p.Size != d.size {
+			return 0, fmt.Errorf("piece hashes are for a %d byte file, the server has %d bytes", p.Size, d.size)
+		}
+		pieces = p
+	}
+	if d.size > 0 && size > d.size {
+		return size, nil
+	}
+	var trusted int64
+	switch d.resumeVerify {
+	case ResumeVerifyLastBlock:
+		block := int64(defaultResumeBlock)
+		if pieces != nil {
+			block = pieces.PieceSize
+		}
+		trusted = (size - 1) / block * block
+		log.Printf("Discarding the last %d bytes to download them again\n", size-trusted)
+	case ResumeVerifyFull:
+		if pieces == nil {
+			return 0, errors.New("full resume verification needs piece hashes")
+		}
+		n, err := d.repairPieces(ctx, pieces, size)
+		if err != nil {
+			return 0, err
+		}
+		trusted = n
+	default:
+		return size, nil
+	}
+	return trusted, os.Truncate(d.output, trusted)
+}
+
+// repairPieces hashes the complete pieces among the first size bytes of
+// the output, downloads the mismatched ones again and returns the end of
+// the last complete piece
+func (d *Downloader) repairPieces(ctx context.Context, p *PieceHashes, size int64) (int64, error) {
+	file, err := os.OpenFile(d.output, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	log.Printf("Verifying the %d byte pieces of %s...\n", p.PieceSize, d.output)
+	var trusted int64
+	var repaired int
+	for i, r := range fixedRanges(p.Size, p.PieceSize) {
+		if r[1] >= size {
+			break
+		}
+		ok, err := pieceMatches(file, r, p.Algorithm, p.Pieces[i])
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			log.Printf("Piece %d does not match, downloading it again\n", i)
+			if err := d.fetchRange(ctx, r, &rangeWriter{w: file, r: r}); err != nil {
+				return 0, err
+			}
+			if ok, err := pieceMatches(file, r, p.Algorithm, p.Pieces[i]); err != nil {
+				return 0, err
+			} else if !ok {
+				return 0, fmt.Errorf("piece %d: %w, the file changed on the server", i, ErrChecksumMismatch)
+			}
+			repaired++
+		}
+		trusted = r[1] + 1
+	}
+	log.Printf("Verified %d bytes, %d pieces downloaded again\n", trusted, repaired)
+	return trusted, file.Close()
+}
+
+// WithResumeVerification checks a partial output at the given level before
+// WithContinue continues it. piecesPath names the PieceHashes of the
+// complete file, e.g. published next to it, and may be empty for the
+// none and last-block levels
+func WithResumeVerification(level ResumeVerify, piecesPath string) Option {
+	return func(d *Downloader) {
+		d.resumeVerify = level
+		d.resumePieces = piecesPath
+	}
+}
+
+// WithContinue continues an existing partial output, e.g. left by wget,
+// by requesting only the bytes after its current size instead of
+// downloading the file again. It uses a single stream
+func WithContinue(enabled bool) Option {
+	return func(d *Downloader) {
+		d.cont = enabled
+	}
+}
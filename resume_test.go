@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestContinueWithoutRanges(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	// a mirror ignoring Range, recording the Range of every GET but the
+	// probe for the first byte
+	var mu sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if r.Method != http.MethodGet {
+			return
+		}
+		if rng := r.Header.Get("Range"); rng != "bytes=0-0" {
+			mu.Lock()
+			ranges = append(ranges, rng)
+			mu.Unlock()
+		}
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	for _, partial := range [][]byte{content[:4000], bytes.Repeat([]byte("x"), 4000)} {
+		t.Run(string(partial[:1]), func(t *testing.T) {
+			mu.Lock()
+			ranges = nil
+			mu.Unlock()
+			output := filepath.Join(t.TempDir(), "output")
+			if err := os.WriteFile(output, partial, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			d := NewDownloader(srv.URL, output, 4, WithContinue(true))
+			if err := d.Download(); err != nil {
+				t.Fatalf("Download() = %v", err)
+			}
+			if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+				t.Errorf("output holds %d bytes %.20q, want the %d of the file", len(got), got, len(content))
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(ranges) != 1 || ranges[0] != "" {
+				t.Errorf("got GETs with ranges %q, want a single stream from the start", ranges)
+			}
+			if res := d.Result(); res.Useful != int64(len(content)) {
+				t.Errorf("Result() has %d useful bytes, want the %d of the file, none kept", res.Useful, len(content))
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy decides how often and after how long a failed chunk request
+// is tried again. The zero value does not retry
+type RetryPolicy struct {
+	Retries    int           // the retries per chunk after its first attempt
+	Backoff    time.Duration // the wait before the first retry, doubling for every further one, 1s by default
+	MaxBackoff time.Duration // the longest wait between two attempts, 0 for no limit
+}
+
+const (
+	defaultBackoff = time.Second
+	// retryJitter is the fraction of a wait drawn at random, so that the
+	// workers failing together do not retry in lockstep
+	retryJitter = 0.5
+)
+
+// wait returns the backoff before the retry following the failed attempt
+// n, 1 for the first. The doubling is capped at MaxBackoff before the
+// jitter shortens it, so the jitter applies under the cap as well and the
+// wait never exceeds MaxBackoff. Without a cap the doubling stops before
+// the wait overflows
+func (p RetryPolicy) wait(n int) time.Duration {
+	wait := p.Backoff
+	if wait <= 0 {
+		wait = defaultBackoff
+	}
+	for i := 1; i < n && (p.MaxBackoff <= 0 || wait < p.MaxBackoff) && wait <= math.MaxInt64/2; i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	return wait - time.Duration(rand.Float64()*retryJitter*float64(wait))
+}
+
+// retryable reports whether a chunk that failed with err may succeed when
+// tried again: network errors, timeouts and the statuses of an overloaded
+// or failing server. The errors of this downloader's own checks are final
+func retryable(err error) bool {
+	var status *StatusError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, ErrQuotaExceeded), errors.Is(err, ErrDiskFull),
+		errors.Is(err, ErrUnexpectedRedirect), errors.Is(err, ErrTooManyRedirects), errors.Is(err, ErrMemoryBudget):
+		return false
+	case errors.As(err, &status):
+		return status.Code == http.StatusRequestTimeout || status.Code == http.StatusTooManyRequests || status.Code >= 500
+	case errors.As(err, &netErr), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, ErrStalled):
+		return true
+	}
+	return false
+}
+
+// backoff waits before retrying after the failed attempt n, counting the
+// time in the stats of the download
+func (d *Downloader) backoff(ctx context.Context, n int) error {
+	wait := d.retry.wait(n)
+	atomic.AddInt32(&d.retries, 1)
+	d.traceRetry(n, wait)
+	start := d.clock.Now()
+	err := d.sleep(ctx, wait)
+	if err != nil {
+		wait = d.clock.Now().Sub(start)
+	}
+	atomic.AddInt64(&d.waited, int64(wait))
+	return err
+}
+
+// sleep waits for wait or until ctx is done. A function set with
+// WithSleepFunc does the waiting instead, without being interrupted by ctx
+func (d *Downloader) sleep(ctx context.Context, wait time.Duration) error {
+	if d.sleepFunc != nil {
+		d.sleepFunc(wait)
+		return ctx.Err()
+	}
+	select {
+	case <-d.clock.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// withRetries calls attempt until it succeeds, fails for good or the
+// retries of the policy are used up
+func (d *Downloader) withRetries(ctx context.Context, what string, attempt func() error) error {
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil || n > d.retry.Retries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		log.Printf("Retrying %s after attempt %d failed: %v\n", what, n, err)
+		if err := d.backoff(ctx, n); err != nil {
+			return err
+		}
+	}
+}
+
+// WithRetryPolicy retries failed chunk requests as p says. A chunk written
+// in place or buffered continues after the bytes it already received, one
+// in a temporary file starts over
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(d *Downloader) {
+		d.retry = p
+	}
+}
+
+// WithSleepFunc makes the downloader wait with fn instead of a timer
+// between retries and while the disk is full, e.g. for tests asserting
+// the backoff sequence without waiting for it. The durations fn gets are
+// counted as waited in full
+func WithSleepFunc(fn func(time.Duration)) Option {
+	return func(d *Downloader) {
+		d.sleepFunc = fn
+	}
+}
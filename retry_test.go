@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryWaitCap(t *testing.T) {
+	tests := []struct {
+		policy RetryPolicy
+		max    time.Duration // the longest wait allowed
+	}{
+		{policy: RetryPolicy{Backoff: time.Second, MaxBackoff: 30 * time.Second}, max: 30 * time.Second},
+		{policy: RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}, max: time.Second},
+		{policy: RetryPolicy{MaxBackoff: 5 * time.Second}, max: 5 * time.Second},
+		{policy: RetryPolicy{Backoff: 10 * time.Second, MaxBackoff: time.Second}, max: time.Second}, // a cap below the first wait
+		{policy: RetryPolicy{Backoff: time.Second}, max: time.Duration(1<<63 - 1)},                  // no cap, no overflow
+	}
+	for _, tt := range tests {
+		for n := 1; n <= 100; n++ {
+			jittered := false
+			for i := 0; i < 20; i++ {
+				wait := tt.policy.wait(n)
+				if wait <= 0 || wait > tt.max {
+					t.Fatalf("%+v: wait(%d) = %v, want 0 to %v", tt.policy, n, wait, tt.max)
+				}
+				jittered = jittered || wait != tt.policy.wait(n)
+			}
+			if !jittered {
+				t.Errorf("%+v: wait(%d) is always the same, want the jitter under the cap", tt.policy, n)
+			}
+		}
+	}
+}
+
+func TestRetryWaitsReported(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	// every range fails three times before it is served
+	var mu sync.Mutex
+	failures := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rng := r.Header.Get("Range"); rng != "" && r.Method == http.MethodGet {
+			mu.Lock()
+			failures[rng]++
+			n := failures[rng]
+			mu.Unlock()
+			if n <= 3 && rng != "bytes=0-0" {
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	var mu2 sync.Mutex
+	var waits []time.Duration
+	output := filepath.Join(t.TempDir(), "output")
+	d := NewDownloader(srv.URL, output, 2,
+		WithRetryPolicy(RetryPolicy{Retries: 5, Backoff: time.Second, MaxBackoff: 1500 * time.Millisecond}),
+		WithSleepFunc(func(wait time.Duration) {
+			mu2.Lock()
+			waits = append(waits, wait)
+			mu2.Unlock()
+		}))
+	if err := d.Download(); err != nil {
+		t.Fatalf("Download() = %v", err)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+		t.Errorf("output holds %d bytes, want the %d of the file", len(got), len(content))
+	}
+	var total time.Duration
+	for _, wait := range waits {
+		if wait > 1500*time.Millisecond {
+			t.Errorf("waited %v before a retry, want at most the cap of 1.5s", wait)
+		}
+		total += wait
+	}
+	res := d.Result()
+	if len(waits) != 6 || res.Retries != 6 || res.Waited != total {
+		t.Errorf("Result() has %d retries and waited %v, want the %d waits of %v", res.Retries, res.Waited, len(waits), total)
+	}
+}
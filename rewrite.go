@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// URLRewriter maps the url of the file to the url actually requested, e.g.
+// to send requests for a public registry to an internal mirror
+type URLRewriter func(string) (string, error)
+
+// rewriteURL applies the configured URLRewriter to url
+func (d *Downloader) rewriteURL(url string) (string, error) {
+	if d.rewriter == nil {
+		return url, nil
+	}
+	rewritten, err := d.rewriter(url)
+	if err != nil {
+		return "", fmt.Errorf("rewriting %s: %w", url, err)
+	}
+	if rewritten != url {
+		d.debugf("Rewrote %s to %s\n", url, rewritten)
+	}
+	return rewritten, nil
+}
+
+// PrefixRewriter returns a URLRewriter replacing url prefixes, given as
+// "prefix=replacement". The longest matching prefix wins and urls matching
+// none are left untouched
+func PrefixRewriter(rules []string) (URLRewriter, error) {
+	type rule struct{ from, to string }
+	var parsed []rule
+	for _, r := range rules {
+		from, to, ok := strings.Cut(r, "=")
+		if !ok || from == "" {
+			return nil, fmt.Errorf("rewrite rule %q must be given as prefix=replacement", r)
+		}
+		parsed = append(parsed, rule{from, to})
+	}
+	return func(url string) (string, error) {
+		best := -1
+		for i, r := range parsed {
+			if strings.HasPrefix(url, r.from) && (best < 0 || len(r.from) > len(parsed[best].from)) {
+				best = i
+			}
+		}
+		if best < 0 {
+			return url, nil
+		}
+		return parsed[best].to + strings.TrimPrefix(url, parsed[best].from), nil
+	}, nil
+}
+
+// WithURLRewriter rewrites the url before the probe and every chunk request
+func WithURLRewriter(rewriter URLRewriter) Option {
+	return func(d *Downloader) {
+		d.rewriter = rewriter
+	}
+}
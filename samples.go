@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// speedSample is a throughput measurement over one sampling interval
+type speedSample struct {
+	Time        time.Time `json:"time"`               // the end of the interval
+	Rate        float64   `json:"bytes_per_second"`   // the aggregate rate over the interval
+	Active      int32     `json:"active_connections"` // the chunk requests in flight
+	Transferred int64     `json:"bytes_transferred"`  // the bytes transferred so far
+}
+
+// startSampling writes a throughput sample to the samples file every
+// sampling interval until the returned function is called, which writes a
+// last sample and closes the file. Files ending in .csv get
+//
+//	time,bytes_per_second,active_connections,bytes_transferred
+//
+// rows, other names get one JSON object per line with the same fields.
+// Samples are flushed as they are taken so the file can be followed live
+func (d *Downloader) startSampling() (func(), error) {
+	file, err := os.Create(d.samples)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	csv := strings.HasSuffix(strings.ToLower(d.samples), ".csv")
+	if csv {
+		fmt.Fprintln(w, "time,bytes_per_second,active_connections,bytes_transferred")
+	}
+	write := func(s speedSample) {
+		if csv {
+			fmt.Fprintf(w, "%s,%.0f,%d,%d\n", s.Time.Format(time.RFC3339Nano), s.Rate, s.Active, s.Transferred)
+		} else {
+			data, _ := json.Marshal(s)
+			w.Write(append(data, '\n'))
+		}
+		if err := w.Flush(); err != nil {
+			log.Printf("Error writing %s: %v\n", d.samples, err)
+		}
+	}
+
+	interval := d.sampleEvery
+	if interval <= 0 {
+		interval = time.Second
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		last, lastBytes := d.clock.Now(), atomic.LoadInt64(&d.transferred)
+		sample := func(now time.Time) {
+			bytes := atomic.LoadInt64(&d.transferred)
+			elapsed := now.Sub(last).Seconds()
+			s := speedSample{Time: now, Active: atomic.LoadInt32(&d.active), Transferred: bytes}
+			if elapsed > 0 {
+				s.Rate = float64(bytes-lastBytes) / elapsed
+			}
+			write(s)
+			last, lastBytes = now, bytes
+		}
+		for {
+			select {
+			case now := <-d.clock.After(interval):
+				sample(now)
+			case <-stop:
+				sample(d.clock.Now())
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		file.Close()
+	}, nil
+}
+
+// WithSpeedSamples writes the aggregate throughput and the number of
+// active connections to path every interval, for graphing how the
+// throughput evolved during the download
+func WithSpeedSamples(path string, interval time.Duration) Option {
+	return func(d *Downloader) {
+		d.samples = path
+		d.sampleEvery = interval
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Scheduler admits the chunk requests of many downloaders under one cap on
+// the connections they hold together, e.g. for a server running many
+// downloads at once. A downloader given one with WithScheduler submits every
+// chunk request to it and holds a slot from sending the request until the
+// body is read.
+//
+// Slots are granted fairly between downloads, not requests: while requests
+// wait, a freed slot goes to the next download in turn, round-robin in the
+// order the downloads started waiting, and the download served moves to
+// the back of the turn. A download with many workers thus gets no more
+// slots than one with a single worker while both wait, so one huge
+// download cannot starve small ones, and requests of a download are served
+// in the order they were submitted. A request whose context is done stops
+// waiting
+type Scheduler struct {
+	limit int
+
+	mu      sync.Mutex
+	active  int
+	waiting map[*Downloader][]*schedWaiter
+	turn    []*Downloader // the downloads with waiting requests, next first
+}
+
+// schedWaiter is a request waiting for a slot
+type schedWaiter struct {
+	ready   chan struct{} // closed when granted
+	granted bool
+}
+
+// DefaultScheduler is unbounded, it admits every request at once. It is
+// what downloaders without WithScheduler use
+var DefaultScheduler = NewScheduler(0)
+
+// NewScheduler returns a scheduler holding at most limit connections at a
+// time, 0 for no limit
+func NewScheduler(limit int) *Scheduler {
+	return &Scheduler{limit: limit, waiting: map[*Downloader][]*schedWaiter{}}
+}
+
+// Submit runs fn for a request of d once the scheduler grants it a slot,
+// which it holds until fn returns. It returns the error of fn, or that of
+// ctx when it is done before a slot was granted
+func (s *Scheduler) Submit(ctx context.Context, d *Downloader, fn func(ctx context.Context) error) error {
+	release, err := s.acquire(ctx, d)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn(ctx)
+}
+
+// Active returns the slots held
+func (s *Scheduler) Active() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active
+}
+
+// Waiting returns the requests waiting for a slot
+func (s *Scheduler) Waiting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, q := range s.waiting {
+		n += len(q)
+	}
+	return n
+}
+
+// acquire waits for a slot for a request of d and returns the function
+// releasing it
+func (s *Scheduler) acquire(ctx context.Context, d *Downloader) (func(), error) {
+	if s.limit <= 0 {
+		return func() {}, nil
+	}
+	s.mu.Lock()
+	if s.active < s.limit && len(s.turn) == 0 {
+		s.active++
+		s.mu.Unlock()
+		return s.releaser(), nil
+	}
+	w := &schedWaiter{ready: make(chan struct{})}
+	if len(s.waiting[d]) == 0 {
+		s.turn = append(s.turn, d)
+	}
+	s.waiting[d] = append(s.waiting[d], w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return s.releaser(), nil
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	if w.granted {
+		// granted while giving up, pass the slot on
+		s.mu.Unlock()
+		s.release()
+		return nil, ctx.Err()
+	}
+	q := s.waiting[d]
+	for i := range q {
+		if q[i] == w {
+			q = append(q[:i], q[i+1:]...)
+			break
+		}
+	}
+	if len(q) == 0 {
+		delete(s.waiting, d)
+		for i := range s.turn {
+			if s.turn[i] == d {
+				s.turn = append(s.turn[:i], s.turn[i+1:]...)
+				break
+			}
+		}
+	} else {
+		s.waiting[d] = q
+	}
+	s.mu.Unlock()
+	return nil, ctx.Err()
+}
+
+// releaser returns a function releasing a slot once, however often it is called
+func (s *Scheduler) releaser() func() {
+	var once sync.Once
+	return func() { once.Do(s.release) }
+}
+
+// release hands a slot to the first waiting request of the download whose
+// turn it is, or frees it
+func (s *Scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.turn) == 0 {
+		s.active--
+		return
+	}
+	d := s.turn[0]
+	s.turn = s.turn[1:]
+	q := s.waiting[d]
+	w := q[0]
+	if len(q) > 1 {
+		s.waiting[d] = q[1:]
+		s.turn = append(s.turn, d)
+	} else {
+		delete(s.waiting, d)
+	}
+	w.granted = true
+	close(w.ready)
+}
+
+// WithScheduler submits the chunk requests to s, which caps the
+// connections of all downloaders sharing it and shares them fairly
+func WithScheduler(s *Scheduler) Option {
+	return func(d *Downloader) {
+		d.scheduler = s
+	}
+}
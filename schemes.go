@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// defaultSchemes are the handlers for the schemes other than http and https
+// that a downloader building its own client understands:
+//
+//   - file:///path reads a local file. It answers HEAD and ranged GETs
+//     like a web server, so parallel ranged copies, checksums, the size
+//     checks, continuing and progress work as for http. There is no ETag,
+//     the modification time is sent as Last-Modified
+//   - data:[<type>][;base64],<data> decodes the inline data, which is held
+//     in memory anyway, so parallel ranges bring nothing but do work
+var defaultSchemes = map[string]http.RoundTripper{
+	"file": roundTripperFunc(fileRoundTrip),
+	"data": roundTripperFunc(dataRoundTrip),
+}
+
+// supportedScheme reports whether a downloader building its own client
+// fetches urls of scheme, http, https or one of defaultSchemes
+func supportedScheme(scheme string) bool {
+	scheme = strings.ToLower(scheme)
+	return webScheme(scheme) || defaultSchemes[scheme] != nil
+}
+
+// fileRoundTrip serves a file: url from the local filesystem
+func fileRoundTrip(req *http.Request) (*http.Response, error) {
+	path := req.URL.Path
+	if req.URL.Host != "" && req.URL.Host != "localhost" {
+		return nil, fmt.Errorf("file url %s: only local files are supported", req.URL)
+	}
+	// file:///C:/dir/file on Windows
+	if runtime.GOOS == "windows" && len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	file, err := os.Open(filepath.FromSlash(path))
+	if os.IsNotExist(err) {
+		return textResponse(req, http.StatusNotFound, err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		if err == nil {
+			err = fmt.Errorf("%s is a directory", path)
+		}
+		return nil, err
+	}
+	resp := contentResponse(req, file, info.Size(), "application/octet-stream")
+	resp.Header.Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent || req.Method == http.MethodHead {
+		file.Close()
+		return resp, nil
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{resp.Body, file}
+	return resp, nil
+}
+
+// dataRoundTrip serves the content of a data: url
+func dataRoundTrip(req *http.Request) (*http.Response, error) {
+	spec, data, ok := strings.Cut(req.URL.Opaque, ",")
+	if !ok {
+		return nil, fmt.Errorf("invalid data url: no comma")
+	}
+	data, err := url.PathUnescape(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data url: %v", err)
+	}
+	content := []byte(data)
+	mediaType, isBase64 := strings.CutSuffix(spec, ";base64")
+	if isBase64 {
+		if content, err = base64.StdEncoding.DecodeString(data); err != nil {
+			return nil, fmt.Errorf("invalid data url: %v", err)
+		}
+	}
+	if mediaType == "" {
+		mediaType = "text/plain;charset=US-ASCII"
+	} else if _, _, err := mime.ParseMediaType(mediaType); err != nil {
+		return nil, fmt.Errorf("invalid data url media type %q", mediaType)
+	}
+	return contentResponse(req, bytes.NewReader(content), int64(len(content)), mediaType), nil
+}
+
+// contentResponse answers req with content of size bytes like a web server
+// supporting single byte ranges. A multi-range request gets the whole content
+func contentResponse(req *http.Request, content io.ReaderAt, size int64, contentType string) *http.Response {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return textResponse(req, http.StatusMethodNotAllowed, req.Method+" is not supported")
+	}
+	status, start, end := http.StatusOK, int64(0), size-1
+	if r := req.Header.Get("Range"); r != "" {
+		s, e, ok := parseRangeHeader(r, size)
+		if !ok {
+			resp := textResponse(req, http.StatusRequestedRangeNotSatisfiable, "range not satisfiable")
+			resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			return resp
+		}
+		if s >= 0 {
+			status, start, end = http.StatusPartialContent, s, e
+		}
+	}
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		ContentLength: end - start + 1,
+		Request:       req,
+		Body:          http.NoBody,
+	}
+	resp.Header.Set("Accept-Ranges", "bytes")
+	resp.Header.Set("Content-Type", contentType)
+	resp.Header.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	if status == http.StatusPartialContent {
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	}
+	if req.Method == http.MethodGet {
+		resp.Body = io.NopCloser(io.NewSectionReader(content, start, resp.ContentLength))
+	}
+	return resp
+}
+
+// parseRangeHeader parses a single range "bytes=a-b", "bytes=a-" or
+// "bytes=-n" against size bytes. It returns start -1 for a range it does not
+// support, which is answered with the whole content, and ok false for one
+// that cannot be satisfied
+func parseRangeHeader(r string, size int64) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(r, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return -1, -1, true
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return -1, -1, true
+	}
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end = size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
+
+// textResponse answers req with a plain text error
+func textResponse(req *http.Request, status int, text string) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		ContentLength: int64(len(text)),
+		Body:          io.NopCloser(strings.NewReader(text)),
+		Request:       req,
+	}
+}
+
+// WithSchemeHandler makes the downloader fetch urls of scheme with rt, in
+// addition to or instead of the file and data handlers, e.g. for an
+// s3 scheme. rt sees the same requests as an http server would, so it needs
+// to answer HEAD and ranged GETs for parallel downloads. The handlers are
+// part of the client the downloader builds and have no effect with
+// WithHTTPClient
+func WithSchemeHandler(scheme string, rt http.RoundTripper) Option {
+	return func(d *Downloader) {
+		if d.transport.schemes == nil {
+			d.transport.schemes = map[string]http.RoundTripper{}
+		}
+		d.transport.schemes[strings.ToLower(scheme)] = rt
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"errors"
+	"io"
+)
+
+// DownloadTo downloads the file in order into the writer returned by open,
+// without an output file. open is called once the size of the file is known,
+// -1 when the server does not report it, and before any byte is written.
+// The download streams the segments in order like StrategyStream, so memory
+// use is the concurrency times the segment size, or temporary files of that
+// size next to the output given to NewDownloader with StrategyTempFiles, and
+// piece hashes, split outputs and continuing are not available. The output
+// itself is never written
+func (d *Downloader) DownloadTo(ctx context.Context, open func(size int64) (io.Writer, error)) error {
+	d.sink = open
+	return d.DownloadContext(ctx)
+}
+
+// TarEntry returns an opener for DownloadTo writing hdr to tw with the size
+// of the file, so the file is downloaded straight into an entry of a tar
+// archive. A tar header holds the size of the entry, so it fails when the
+// probe does not report the size:
+//
+//	tw := tar.NewWriter(archive)
+//	d := NewDownloader(url, "", 4)
+//	err := d.DownloadTo(ctx, TarEntry(tw, &tar.Header{Name: "bin/tool", Mode: 0755}))
+//	...
+//	err = tw.Close()
+func TarEntry(tw *tar.Writer, hdr *tar.Header) func(size int64) (io.Writer, error) {
+	return func(size int64) (io.Writer, error) {
+		if size < 0 {
+			return nil, errors.New("a tar entry needs the size of the file, which the server did not report")
+		}
+		h := *hdr
+		h.Size = size
+		if h.Typeflag == 0 {
+			h.Typeflag = tar.TypeReg
+		}
+		if err := tw.WriteHeader(&h); err != nil {
+			return nil, err
+		}
+		return tw, nil
+	}
+}
+
+// ZipEntry returns an opener for DownloadTo creating an entry of zw with
+// hdr, so the file is downloaded straight into an entry of a zip archive,
+// compressed with the method of hdr. Zip records the size of an entry
+// after its data, so unlike TarEntry it works without a known size
+func ZipEntry(zw *zip.Writer, hdr *zip.FileHeader) func(size int64) (io.Writer, error) {
+	return func(size int64) (io.Writer, error) {
+		h := *hdr
+		return zw.CreateHeader(&h)
+	}
+}
+
+// DownloadReader returns a reader of the file that downloads it in order
+// while it is read, like DownloadTo. Errors of the download are returned by
+// Read, and closing the reader early aborts the download. With
+// WithReadAhead the segments after the one being read are fetched while
+// the consumer is busy, so a consumer that is occasionally slow does not
+// stall the connections
+func (d *Downloader) DownloadReader(ctx context.Context) io.ReadCloser {
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	go func() {
+		err := d.DownloadTo(ctx, func(size int64) (io.Writer, error) {
+			return pw, nil
+		})
+		pw.CloseWithError(err)
+	}()
+	return &downloadReader{PipeReader: pr, cancel: cancel}
+}
+
+// downloadReader is the reader of DownloadReader
+type downloadReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+// Close aborts the download if it is still running
+func (r *downloadReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
+
+// WithReadAhead keeps up to n segments after the one being written fetched
+// or in flight for the streamed outputs of DownloadReader, DownloadTo and
+// standard output, instead of as many as the concurrency. Memory use is
+// bounded by n+1 segments, while the concurrency still bounds the requests
+// in flight
+func WithReadAhead(n int) Option {
+	return func(d *Downloader) {
+		d.readAhead = n
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// socketOptions are the TCP socket settings of the connections a Downloader
+// dials. They are best-effort, a setting the platform refuses is logged
+// with debugf and the connection is used as it is
+type socketOptions struct {
+	noDelay     *bool // whether Nagle's algorithm is disabled, nil for the Go default of disabled
+	readBuffer  int   // SO_RCVBUF in bytes, 0 for the system default
+	writeBuffer int   // SO_SNDBUF in bytes, 0 for the system default
+}
+
+// set reports whether any socket option differs from the defaults
+func (o socketOptions) set() bool {
+	return o.noDelay != nil || o.readBuffer > 0 || o.writeBuffer > 0
+}
+
+// control returns the Control function of the dialer, which sizes the
+// socket buffers before connecting so the TCP window scale is negotiated
+// for them. It is nil where the platform cannot, apply sets them after
+// connecting then
+func (o socketOptions) control(debugf func(string, ...interface{})) func(network, address string, c syscall.RawConn) error {
+	if !earlySocketBuffers || (o.readBuffer <= 0 && o.writeBuffer <= 0) {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		if err := setSocketBuffers(c, o.readBuffer, o.writeBuffer); err != nil {
+			debugf("Cannot size the socket buffers for %s: %v\n", address, err)
+		}
+		return nil
+	}
+}
+
+// apply sets the options that are set on a connected socket
+func (o socketOptions) apply(conn net.Conn, debugf func(string, ...interface{})) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if o.noDelay != nil {
+		if err := tcp.SetNoDelay(*o.noDelay); err != nil {
+			debugf("Cannot set TCP_NODELAY for %s: %v\n", conn.RemoteAddr(), err)
+		}
+	}
+	if earlySocketBuffers {
+		return
+	}
+	if o.readBuffer > 0 {
+		if err := tcp.SetReadBuffer(o.readBuffer); err != nil {
+			debugf("Cannot size the receive buffer for %s: %v\n", conn.RemoteAddr(), err)
+		}
+	}
+	if o.writeBuffer > 0 {
+		if err := tcp.SetWriteBuffer(o.writeBuffer); err != nil {
+			debugf("Cannot size the send buffer for %s: %v\n", conn.RemoteAddr(), err)
+		}
+	}
+}
+
+// WithTCPNoDelay sets TCP_NODELAY on the connections, false enabling
+// Nagle's algorithm again, which Go disables by default. Requests are
+// written in one piece, so this only matters for uploads through the
+// connection, e.g. a body sent with the requests
+func WithTCPNoDelay(enabled bool) Option {
+	return func(d *Downloader) {
+		d.transport.socket.noDelay = &enabled
+	}
+}
+
+// WithSocketBuffers sets the receive and send buffers of the connections
+// in bytes, 0 keeping the system default. On links with a high bandwidth
+// delay product a larger receive buffer lets every connection keep more
+// data in flight. On Unix systems the buffers are sized before connecting,
+// elsewhere after. The kernel may cap them, on Linux at net.core.rmem_max
+// and net.core.wmem_max, and double what it reports
+func WithSocketBuffers(read, write int) Option {
+	return func(d *Downloader) {
+		d.transport.socket.readBuffer = read
+		d.transport.socket.writeBuffer = write
+	}
+}
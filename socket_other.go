@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// earlySocketBuffers is false, the buffers are sized once connected
+const earlySocketBuffers = false
+
+// setSocketBuffers fails, the buffers are sized through the connection instead
+func setSocketBuffers(c syscall.RawConn, read, write int) error {
+	return errors.ErrUnsupported
+}
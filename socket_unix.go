@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// earlySocketBuffers reports whether setSocketBuffers can size the buffers
+// of a socket before it connects
+const earlySocketBuffers = true
+
+// setSocketBuffers sets SO_RCVBUF and SO_SNDBUF of the socket, 0 keeping one as it is
+func setSocketBuffers(c syscall.RawConn, read, write int) error {
+	var err error
+	ctlErr := c.Control(func(fd uintptr) {
+		if read > 0 {
+			if err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, read); err != nil {
+				return
+			}
+		}
+		if write > 0 {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, write)
+		}
+	})
+	if ctlErr != nil {
+		return ctlErr
+	}
+	return err
+}
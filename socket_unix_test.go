@@ -0,0 +1,80 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+)
+
+// sockopt reads an option of the socket of conn
+func sockopt(t *testing.T, conn net.Conn, level, opt int) int {
+	t.Helper()
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	var optErr error
+	if err := raw.Control(func(fd uintptr) { v, optErr = syscall.GetsockoptInt(int(fd), level, opt) }); err != nil {
+		t.Fatal(err)
+	}
+	if optErr != nil {
+		t.Fatal(optErr)
+	}
+	return v
+}
+
+func TestSocketOptions(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	dial := func(t *testing.T, opts ...Option) net.Conn {
+		t.Helper()
+		d := NewDownloader("http://"+ln.Addr().String()+"/file", "", 1, opts...)
+		conn, err := d.transport.newClient(t.Logf).Transport.(*http.Transport).DialContext(t.Context(), "tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	def := dial(t)
+	if got := sockopt(t, def, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); got == 0 {
+		t.Errorf("TCP_NODELAY of a default connection is off, want the Go default of on")
+	}
+	defaults := [2]int{sockopt(t, def, syscall.SOL_SOCKET, syscall.SO_RCVBUF), sockopt(t, def, syscall.SOL_SOCKET, syscall.SO_SNDBUF)}
+
+	// sizes unlike the defaults, which Linux reports doubled
+	const read, write = 45000, 23000
+	conn := dial(t, WithTCPNoDelay(false), WithSocketBuffers(read, write))
+	if got := sockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); got != 0 {
+		t.Errorf("TCP_NODELAY is %d, want it off", got)
+	}
+	for i, b := range []struct {
+		name string
+		opt  int
+		want int
+	}{
+		{name: "SO_RCVBUF", opt: syscall.SO_RCVBUF, want: read},
+		{name: "SO_SNDBUF", opt: syscall.SO_SNDBUF, want: write},
+	} {
+		if got := sockopt(t, conn, syscall.SOL_SOCKET, b.opt); got < b.want || got == defaults[i] {
+			t.Errorf("%s is %d, want at least %d and not the default of %d", b.name, got, b.want, defaults[i])
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SpeedBasis selects which bytes the average speed of the summary counts
+type SpeedBasis string
+
+const (
+	// SpeedUseful counts the bytes of the file this download received,
+	// the effective throughput
+	SpeedUseful SpeedBasis = "useful"
+	// SpeedRaw counts every byte transferred, including probes, retried
+	// and re-downloaded data, the throughput of the link
+	SpeedRaw SpeedBasis = "raw"
+)
+
+// ParseSpeedBasis parses the name of a SpeedBasis
+func ParseSpeedBasis(s string) (SpeedBasis, error) {
+	switch b := SpeedBasis(strings.ToLower(s)); b {
+	case SpeedUseful, SpeedRaw:
+		return b, nil
+	}
+	return "", fmt.Errorf("unknown speed basis %q, expected useful or raw", s)
+}
+
+// UsefulRate returns the bytes of the file received per second of the
+// download, 0 before it completed
+func (r DownloadResult) UsefulRate() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Useful) / r.Elapsed.Seconds()
+}
+
+// RawRate returns the bytes transferred per second of the download
+func (r DownloadResult) RawRate() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Transferred) / r.Elapsed.Seconds()
+}
+
+// rate returns the average speed on basis b
+func (r DownloadResult) rate(b SpeedBasis) float64 {
+	if b == SpeedRaw {
+		return r.RawRate()
+	}
+	return r.UsefulRate()
+}
+
+// WithSpeedBasis selects whether the summary logged after the download
+// shows the useful or the raw average speed, useful by default. Both are
+// in the DownloadResult
+func WithSpeedBasis(b SpeedBasis) Option {
+	return func(d *Downloader) {
+		d.speedBasis = b
+	}
+}
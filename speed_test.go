@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSpeedBasisCountsRetries(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	tests := []struct {
+		strategy Strategy
+		wasted   int64 // the bytes received twice
+	}{
+		{strategy: StrategyWriteAt},                  // continues after the bytes it received
+		{strategy: StrategyTempFiles, wasted: 25000}, // starts over
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			// the first request for the first chunk gets half its range before
+			// the connection drops, so the chunk is retried once
+			var once sync.Once
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				cut := false
+				if r.Method == http.MethodGet && strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+					once.Do(func() { cut = true })
+				}
+				if !cut {
+					http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(content))
+					return
+				}
+				w.Header().Set("Content-Range", "bytes 0-49999/100000")
+				w.Header().Set("Content-Length", "50000")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(content[:25000])
+				w.(http.Flusher).Flush()
+				if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+					conn.Close()
+				}
+			}))
+			defer srv.Close()
+
+			output := filepath.Join(t.TempDir(), "output")
+			d := NewDownloader(srv.URL, output, 2, WithStrategy(tt.strategy),
+				WithRetryPolicy(RetryPolicy{Retries: 2, Backoff: time.Second}), WithSleepFunc(func(time.Duration) {}))
+			if err := d.Download(); err != nil {
+				t.Fatalf("Download() = %v", err)
+			}
+			if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+				t.Fatalf("output holds %d bytes, want the %d of the file", len(got), len(content))
+			}
+			res := d.Result()
+			if res.Retries != 1 {
+				t.Errorf("Result() has %d retries, want the cut chunk retried once", res.Retries)
+			}
+			if res.Useful != int64(len(content)) || res.Transferred != res.Useful+tt.wasted {
+				t.Errorf("Result() transferred %d bytes, %d useful, want %d, %d useful", res.Transferred, res.Useful, int64(len(content))+tt.wasted, len(content))
+			}
+			if raw, useful := res.rate(SpeedRaw), res.rate(SpeedUseful); useful <= 0 || (raw > useful) != (tt.wasted > 0) {
+				t.Errorf("raw rate %.0f and useful rate %.0f, want the raw one higher only with wasted bytes", raw, useful)
+			}
+		})
+	}
+}
+
+func TestParseSpeedBasis(t *testing.T) {
+	tests := []struct {
+		in   string
+		want SpeedBasis
+		err  bool
+	}{
+		{in: "useful", want: SpeedUseful},
+		{in: "Raw", want: SpeedRaw},
+		{in: "fast", err: true},
+		{in: "", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSpeedBasis(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseSpeedBasis(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// SplitManifest describes how the parts written by -split-output are
+// reassembled. It is written next to the parts as output.manifest.json:
+//
+//	{
+//	  "url": "https://example.com/big.iso",
+//	  "output": "big.iso",
+//	  "size": 10485760,
+//	  "sha256": "…",
+//	  "parts": [
+//	    {"file": "big.iso.part0", "offset": 0, "size": 5242880, "sha256": "…"},
+//	    {"file": "big.iso.part1", "offset": 5242880, "size": 5242880, "sha256": "…"}
+//	  ]
+//	}
+//
+// Part files are relative to the directory of the manifest and concatenated
+// in order give the original file
+type SplitManifest struct {
+	URL    string      `json:"url"`    // the url the file was downloaded from
+	Output string      `json:"output"` // the name of the reassembled file
+	Size   int64       `json:"size"`   // the size of the reassembled file
+	SHA256 string      `json:"sha256"` // the hex sha256 of the reassembled file
+	Parts  []SplitPart `json:"parts"`  // the parts in order
+}
+
+// SplitPart is a single part of a split output
+type SplitPart struct {
+	File   string `json:"file"`   // the part file, relative to the manifest
+	Offset int64  `json:"offset"` // the offset of the part in the reassembled file
+	Size   int64  `json:"size"`   // the size of the part in bytes
+	SHA256 string `json:"sha256"` // the hex sha256 of the part
+}
+
+// splitManifestFile returns the name of the manifest for a split output
+func splitManifestFile(output string) string {
+	return output + ".manifest.json"
+}
+
+// finishSplit checks and hashes the downloaded parts and writes the split
+// manifest instead of merging them
+func (d *Downloader) finishSplit(sum *checksum) error {
+	m := SplitManifest{
+		URL:    d.url,
+		Output: filepath.Base(d.output),
+		Size:   d.size,
+	}
+	whole := sha256.New()
+	var full io.Writer = whole
+	var verify = whole
+	if sum != nil && sum.algo != "sha256" {
+		verify = sum.newHash()
+		full = io.MultiWriter(whole, verify)
+	}
+	for i, r := range d.ranges {
+		part, err := hashPart(d.chunkFile(i), full)
+		if err != nil {
+			return err
+		}
+		part.Offset = r[0]
+		if want := r[1] - r[0] + 1; part.Size != want {
+			return fmt.Errorf("part %s has %d bytes, expected %d", part.File, part.Size, want)
+		}
+		m.Parts = append(m.Parts, part)
+	}
+	m.SHA256 = hex.EncodeToString(whole.Sum(nil))
+	if sum != nil {
+		log.Printf("Verifying %s checksum...\n", sum.algo)
+		if err := sum.verify(verify.Sum(nil)); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(splitManifestFile(d.output), append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	log.Printf("Download completed as %d parts described by %s\n", len(m.Parts), splitManifestFile(d.output))
+	return nil
+}
+
+// hashPart returns the size and sha256 of a part file, also copying its
+// content to whole
+func hashPart(path string, whole io.Writer) (SplitPart, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return SplitPart{}, err
+	}
+	defer file.Close()
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(h, whole), file)
+	if err != nil {
+		return SplitPart{}, err
+	}
+	return SplitPart{
+		File:   filepath.Base(path),
+		Size:   n,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// WithSplitOutput keeps the download as n permanent part files named
+// output.part0 to output.part<n-1> plus a SplitManifest instead of merging
+// them. It replaces the concurrency, each part being fetched by its own
+// goroutine
+func WithSplitOutput(n int) Option {
+	return func(d *Downloader) {
+		if n > 0 {
+			d.split = n
+			d.concurrency = n
+		}
+	}
+}
+
+// joinSplit reassembles the parts described by the split manifest at
+// manifestPath into output, verifying the size and sha256 of every part and
+// of the result. An empty output writes the original name next to the manifest
+func joinSplit(manifestPath, output string) (err error) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return err
+	}
+	var m SplitManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("invalid split manifest %s: %v", manifestPath, err)
+	}
+	dir := filepath.Dir(manifestPath)
+	if output == "" {
+		if m.Output == "" {
+			return fmt.Errorf("split manifest %s has no output name, use -output", manifestPath)
+		}
+		output = filepath.Join(dir, filepath.Base(m.Output))
+	}
+
+	// check every part before writing anything
+	var offset int64
+	for _, part := range m.Parts {
+		if part.Offset != offset {
+			return fmt.Errorf("part %s starts at %d, expected %d", part.File, part.Offset, offset)
+		}
+		info, err := os.Stat(filepath.Join(dir, part.File))
+		if os.IsNotExist(err) {
+			return fmt.Errorf("missing part %s", part.File)
+		}
+		if err != nil {
+			return err
+		}
+		if info.Size() != part.Size {
+			return fmt.Errorf("part %s has %d bytes, expected %d", part.File, info.Size(), part.Size)
+		}
+		offset += part.Size
+	}
+	if offset != m.Size {
+		return fmt.Errorf("parts add up to %d bytes, expected %d", offset, m.Size)
+	}
+
+	log.Printf("Joining %d parts into %s...\n", len(m.Parts), output)
+	outputFile, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		outputFile.Close()
+		if err != nil {
+			os.Remove(output)
+		}
+	}()
+	whole := sha256.New()
+	w := io.MultiWriter(outputFile, whole)
+	for _, part := range m.Parts {
+		h := sha256.New()
+		if _, err := appendFile(io.MultiWriter(w, h), filepath.Join(dir, part.File), nil); err != nil {
+			return err
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); part.SHA256 != "" && got != part.SHA256 {
+			return fmt.Errorf("%w: part %s has sha256 %s, expected %s", ErrChecksumMismatch, part.File, got, part.SHA256)
+		}
+	}
+	if got := hex.EncodeToString(whole.Sum(nil)); m.SHA256 != "" && got != m.SHA256 {
+		return fmt.Errorf("%w: joined file has sha256 %s, expected %s", ErrChecksumMismatch, got, m.SHA256)
+	}
+	if err := outputFile.Close(); err != nil {
+		return err
+	}
+	log.Println("Join completed")
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Splitter decides how a file of size bytes is split into the ranges the
+// workers download, given the concurrency n. The ranges are the first and
+// last byte of each, in order, and must cover the file without gaps or
+// overlaps. More ranges than workers are queued for the workers
+type Splitter interface {
+	Split(size int64, n int) [][2]int64
+}
+
+// SplitterFunc adapts a function to a Splitter
+type SplitterFunc func(size int64, n int) [][2]int64
+
+// Split calls f
+func (f SplitterFunc) Split(size int64, n int) [][2]int64 {
+	return f(size, n)
+}
+
+// EqualSplitter splits the file into one range per worker, the last range
+// holding the remainder. It is the default
+type EqualSplitter struct{}
+
+// Split splits size bytes into n equal ranges, fewer for a file smaller than n bytes
+func (EqualSplitter) Split(size int64, n int) [][2]int64 {
+	if int64(n) > size {
+		n = int(size)
+	}
+	if n < 1 {
+		n = 1
+	}
+	return splitRanges(size, size/int64(n), n)
+}
+
+// FixedSplitter splits the file into ranges of Size bytes, the last one holding the rest
+type FixedSplitter struct {
+	Size int64
+}
+
+// Split splits size bytes into ranges of s.Size bytes, none for a Size
+// that is not positive
+func (s FixedSplitter) Split(size int64, n int) [][2]int64 {
+	if s.validate() != nil {
+		return nil
+	}
+	return fixedRanges(size, s.Size)
+}
+
+// validate checks that s.Size is positive
+func (s FixedSplitter) validate() error {
+	if s.Size <= 0 {
+		return fmt.Errorf("fixed splitter: chunk size %d must be positive", s.Size)
+	}
+	return nil
+}
+
+// SizeListSplitter splits the file into ranges of the given sizes in order,
+// repeating the last size until the end of the file, e.g. small ranges
+// first for a quick start and larger ones later
+type SizeListSplitter struct {
+	Sizes []int64
+}
+
+// Split splits size bytes into ranges of the listed sizes, none for an
+// empty list or one holding a size that is not positive
+func (s SizeListSplitter) Split(size int64, n int) [][2]int64 {
+	if s.validate() != nil {
+		return nil
+	}
+	var ranges [][2]int64
+	for start, i := int64(0), 0; start < size; i++ {
+		chunk := s.Sizes[len(s.Sizes)-1]
+		if i < len(s.Sizes) {
+			chunk = s.Sizes[i]
+		}
+		end := start + chunk - 1
+		if end >= size {
+			end = size - 1
+		}
+		ranges = append(ranges, [2]int64{start, end})
+		start = end + 1
+	}
+	return ranges
+}
+
+// validate checks that s lists at least one size and only positive ones
+func (s SizeListSplitter) validate() error {
+	if len(s.Sizes) == 0 {
+		return fmt.Errorf("size list splitter: no sizes")
+	}
+	for _, size := range s.Sizes {
+		if size <= 0 {
+			return fmt.Errorf("size list splitter: chunk size %d must be positive", size)
+		}
+	}
+	return nil
+}
+
+// ParseSizeList parses a comma separated list of sizes such as 256K,1M,8M
+// for a SizeListSplitter
+func ParseSizeList(s string) (SizeListSplitter, error) {
+	var sizes []int64
+	for _, f := range strings.Split(s, ",") {
+		size, err := parseSize(strings.TrimSpace(f))
+		if err != nil {
+			return SizeListSplitter{}, err
+		}
+		if size <= 0 {
+			return SizeListSplitter{}, fmt.Errorf("chunk size %q must be positive", f)
+		}
+		sizes = append(sizes, size)
+	}
+	return SizeListSplitter{Sizes: sizes}, nil
+}
+
+// checkRanges checks that ranges cover size bytes in order without gaps or overlaps
+func checkRanges(ranges [][2]int64, size int64) error {
+	if len(ranges) == 0 {
+		return fmt.Errorf("no ranges for %d bytes", size)
+	}
+	var next int64
+	for i, r := range ranges {
+		if r[0] != next || r[1] < r[0] {
+			return fmt.Errorf("range %d is %v, expected one starting at %d", i, r, next)
+		}
+		next = r[1] + 1
+	}
+	if next != size {
+		return fmt.Errorf("ranges end at %d, the file has %d bytes", next, size)
+	}
+	return nil
+}
+
+// WithSplitter splits the file with s instead of the default EqualSplitter,
+// or FixedSplitter with WithSegmentSize. A FixedSplitter or SizeListSplitter
+// without positive sizes makes the download fail before any request
+func WithSplitter(s Splitter) Option {
+	return func(d *Downloader) {
+		if v, ok := s.(interface{ validate() error }); ok {
+			if err := v.validate(); err != nil && d.optionErr == nil {
+				d.optionErr = fmt.Errorf("invalid splitter: %w", err)
+			}
+		}
+		d.splitter = s
+	}
+}
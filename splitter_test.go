@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitters(t *testing.T) {
+	tests := []struct {
+		name     string
+		splitter Splitter
+		size     int64
+		want     [][2]int64
+	}{
+		{name: "equal", splitter: EqualSplitter{}, size: 10, want: [][2]int64{{0, 2}, {3, 5}, {6, 9}}},
+		{name: "equal smaller than n", splitter: EqualSplitter{}, size: 2, want: [][2]int64{{0, 0}, {1, 1}}},
+		{name: "fixed", splitter: FixedSplitter{Size: 4}, size: 10, want: [][2]int64{{0, 3}, {4, 7}, {8, 9}}},
+		{name: "fixed zero", splitter: FixedSplitter{}, size: 10},
+		{name: "fixed negative", splitter: FixedSplitter{Size: -1}, size: 10},
+		{name: "size list", splitter: SizeListSplitter{Sizes: []int64{1, 2}}, size: 6, want: [][2]int64{{0, 0}, {1, 2}, {3, 4}, {5, 5}}},
+		{name: "size list empty", splitter: SizeListSplitter{}, size: 10},
+		{name: "size list zero", splitter: SizeListSplitter{Sizes: []int64{0}}, size: 10},
+		{name: "size list negative", splitter: SizeListSplitter{Sizes: []int64{4, -1}}, size: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.splitter.Split(tt.size, 3)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Split(%d, 3) = %v, want %v", tt.size, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Split(%d, 3) = %v, want %v", tt.size, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWithSplitterInvalid(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	srv := serveContent(t, content)
+	for _, s := range []Splitter{FixedSplitter{}, FixedSplitter{Size: -5}, SizeListSplitter{}, SizeListSplitter{Sizes: []int64{0}}, SizeListSplitter{Sizes: []int64{1024, -1}}} {
+		output := filepath.Join(t.TempDir(), "output")
+		if err := NewDownloader(srv.URL, output, 2, WithSplitter(s)).Download(); err == nil {
+			t.Errorf("Download() with %#v = nil, want an error", s)
+		}
+	}
+	output := filepath.Join(t.TempDir(), "output")
+	if err := NewDownloader(srv.URL, output, 2, WithSplitter(SizeListSplitter{Sizes: []int64{1024, 4096}})).Download(); err != nil {
+		t.Errorf("Download() with a valid size list = %v", err)
+	}
+}
+
+func TestParseSizeList(t *testing.T) {
+	got, err := ParseSizeList("256K, 1M")
+	if err != nil || len(got.Sizes) != 2 || got.Sizes[0] != 256<<10 || got.Sizes[1] != 1<<20 {
+		t.Errorf("ParseSizeList(%q) = %v, %v, want [262144 1048576]", "256K, 1M", got, err)
+	}
+	for _, s := range []string{"", "0", "1M,-1K", "1M,x"} {
+		if got, err := ParseSizeList(s); err == nil {
+			t.Errorf("ParseSizeList(%q) = %v, want an error", s, got)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+)
+
+// defaultSpotCheckSize is the size of the ranges of a spot check without
+// another size
+const defaultSpotCheckSize = 64 << 10
+
+// spotCheck downloads the spot check ranges again at random offsets of the
+// completed output and compares them with what is on disk. It catches
+// corruption a flaky path introduced into some chunks without the cost of
+// downloading or hashing the whole file again, but it is a sample: it
+// raises the confidence in the output, it proves nothing the way a
+// checksum does. A file changing on the server in the meantime fails it
+// as well. Only downloads fetched in ranges are checked
+func (d *Downloader) spotCheck(ctx context.Context) error {
+	if d.size <= 0 {
+		return nil
+	}
+	if len(d.ranges) == 0 {
+		log.Println("Not spot checking a single stream or continued download")
+		return nil
+	}
+	size := d.spotSize
+	if size <= 0 {
+		size = defaultSpotCheckSize
+	}
+	if size > d.size {
+		size = d.size
+	}
+	file, err := os.Open(d.output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	log.Printf("Spot checking %d ranges of %d bytes...\n", d.spotChecks, size)
+	disk := make([]byte, size)
+	for i := 0; i < d.spotChecks; i++ {
+		start := rand.Int63n(d.size - size + 1)
+		end := start + size - 1
+		remote, _, err := d.downloadRange(ctx, start, end)
+		if err != nil {
+			return fmt.Errorf("spot check of range %d-%d: %w", start, end, err)
+		}
+		if _, err := file.ReadAt(disk, start); err != nil {
+			return err
+		}
+		if !bytes.Equal(remote, disk) {
+			return fmt.Errorf("%w: range %d-%d of %s differs from the server", ErrChecksumMismatch, start, end, d.output)
+		}
+	}
+	return nil
+}
+
+// WithSpotCheck downloads ranges random ranges of size bytes again once
+// the output is complete and fails with ErrChecksumMismatch when any
+// differs from the output, a cheap check where no checksum is known. A
+// size of 0 checks ranges of 64KiB
+func WithSpotCheck(ranges int, size int64) Option {
+	return func(d *Downloader) {
+		d.spotChecks = ranges
+		d.spotSize = size
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// staleName matches the names of the files a download keeps next to its
+// output while it runs and leaves behind when it is killed: the parts of
+// WithSplit and the temporary file pool, the hidden chunk files of the
+// tempfiles strategy, and the hidden files that reports, the cache, copies
+// and watch write before renaming them. The first group that matched is the
+// output they belong to
+var staleName = regexp.MustCompile(`^(?:(.+)\.(?:part|pool)[0-9]+|\.(.+)\.(?:tmp|copy|watch|chunk[0-9]+))$`)
+
+// StaleFile is a file left behind by an interrupted download
+type StaleFile struct {
+	Path    string    // the path of the file
+	Size    int64     // its size in bytes
+	ModTime time.Time // when it was last written
+}
+
+// FindStale returns the files interrupted downloads left in dir that were
+// not written for at least age before now. The files of one output are only
+// stale together, so a download still writing one of its parts keeps all
+// of them. The parts of a split output whose manifest exists are complete
+// and never stale
+func FindStale(dir string, age time.Duration, now time.Time) ([]StaleFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string][]StaleFile)
+	fresh := make(map[string]bool)
+	for _, e := range entries {
+		m := staleName.FindStringSubmatch(e.Name())
+		if m == nil || !e.Type().IsRegular() {
+			continue
+		}
+		output := m[1] + m[2]
+		if m[1] != "" {
+			if _, err := os.Stat(filepath.Join(dir, splitManifestFile(output))); err == nil {
+				continue
+			}
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if now.Sub(info.ModTime()) < age {
+			fresh[output] = true
+		}
+		groups[output] = append(groups[output], StaleFile{Path: filepath.Join(dir, e.Name()), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	var stale []StaleFile
+	for output, files := range groups {
+		if !fresh[output] {
+			stale = append(stale, files...)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].Path < stale[j].Path })
+	return stale, nil
+}
+
+// cleanStale removes the files FindStale finds in dir, logging every one,
+// or only logs them with dryRun
+func cleanStale(dir string, age time.Duration, dryRun bool) error {
+	stale, err := FindStale(dir, age, time.Now())
+	if err != nil {
+		return fmt.Errorf("cleaning stale files: %w", err)
+	}
+	var total int64
+	for _, f := range stale {
+		if dryRun {
+			log.Printf("Would remove %s, %d bytes, last written %s\n", f.Path, f.Size, f.ModTime.Format(time.RFC3339))
+			total += f.Size
+			continue
+		}
+		if err := os.Remove(f.Path); err != nil {
+			log.Printf("Error removing %s: %v\n", f.Path, err)
+			continue
+		}
+		log.Printf("Removed %s, %d bytes, last written %s\n", f.Path, f.Size, f.ModTime.Format(time.RFC3339))
+		total += f.Size
+	}
+	if len(stale) > 0 {
+		log.Printf("Stale files in %s: %d, %d bytes\n", dir, len(stale), total)
+	}
+	return nil
+}
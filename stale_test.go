@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFindStale(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	old, fresh := now.Add(-2*time.Hour), now.Add(-time.Minute)
+	files := map[string]time.Time{
+		"a.iso.part0":          old, // the parts of an interrupted split
+		"a.iso.part1":          old,
+		"b.iso.part0":          old, // one part of b is still being written
+		"b.iso.part1":          fresh,
+		"c.iso.part0":          old, // a complete split with its manifest
+		"c.iso.manifest.json":  old,
+		"d.iso.pool3":          old,
+		".e.iso.chunk12":       old,
+		".f.iso.copy":          old,
+		".g.iso.chunk0":        fresh,
+		"h.iso":                old, // outputs and unrelated files are kept
+		"notes.part":           old,
+		".hidden":              old,
+		"i.iso.partial.part0x": old,
+	}
+	for name, mtime := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stale, err := FindStale(dir, time.Hour, now)
+	if err != nil {
+		t.Fatalf("FindStale() = %v", err)
+	}
+	var got []string
+	for _, f := range stale {
+		got = append(got, filepath.Base(f.Path))
+		if f.Size != int64(len(filepath.Base(f.Path))) || !f.ModTime.Equal(old) {
+			t.Errorf("%s has size %d and time %v, want %d and %v", f.Path, f.Size, f.ModTime, len(filepath.Base(f.Path)), old)
+		}
+	}
+	want := []string{".e.iso.chunk12", ".f.iso.copy", "a.iso.part0", "a.iso.part1", "d.iso.pool3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("FindStale() = %q, want %q", got, want)
+	}
+
+	// a dry run removes nothing, the cleanup only the stale files
+	if err := cleanStale(dir, time.Hour, true); err != nil {
+		t.Fatalf("cleanStale() dry run = %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != len(files) {
+		t.Errorf("the dry run left %d files, want all %d", len(entries), len(files))
+	}
+	if err := cleanStale(dir, time.Hour, false); err != nil {
+		t.Fatalf("cleanStale() = %v", err)
+	}
+	for name := range files {
+		_, err := os.Stat(filepath.Join(dir, name))
+		removed := os.IsNotExist(err)
+		if wanted := slices.Contains(want, name); removed != wanted {
+			t.Errorf("%s removed: %v, want %v", name, removed, wanted)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// ErrStalled is returned when a chunk response delivers no data for the
+// stall timeout
+var ErrStalled = errors.New("connection stalled")
+
+// maxStallReopens is how often the connection of a stalled chunk is reopened
+// before the chunk fails and is left to the retry policy
+const maxStallReopens = 3
+
+// fetchRange downloads the range r into w. With a stall timeout, a response
+// that delivers no data for that long is abandoned, which closes its
+// connection, and with stall recovery the rest of the range is requested
+// again on a fresh one, without the backoff of a retry
+func (d *Downloader) fetchRange(ctx context.Context, r [2]int64, w io.Writer) error {
+	if d.stallTimeout <= 0 {
+		return d.requestRange(ctx, r, w)
+	}
+	var received int64
+	cw := &countingWriter{w: w, n: &received}
+	for reopened := 0; ; reopened++ {
+		err := d.requestRange(ctx, [2]int64{r[0] + received, r[1]}, cw)
+		if !errors.Is(err, ErrStalled) || !d.stallRecovery || reopened == maxStallReopens {
+			return err
+		}
+		log.Printf("Range %v stalled after %d bytes, reopening the connection\n", r, received)
+	}
+}
+
+// watchStall returns a context of ctx canceled with ErrStalled when the
+// returned function, to be called whenever data arrives, is not called for
+// the stall timeout. Time the download spends paused is not counted
+func (d *Downloader) watchStall(ctx context.Context) (context.Context, func(), context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	var timer Timer
+	timer = d.clock.AfterFunc(d.stallTimeout, func() {
+		if d.paused() {
+			timer.Reset(d.stallTimeout)
+			return
+		}
+		cancel(ErrStalled)
+	})
+	progress := func() { timer.Reset(d.stallTimeout) }
+	return ctx, progress, func() {
+		timer.Stop()
+		cancel(nil)
+	}
+}
+
+// stalled returns the error of a request canceled by watchStall, err otherwise
+func (d *Downloader) stalled(ctx context.Context, err error) error {
+	if err != nil && errors.Is(context.Cause(ctx), ErrStalled) {
+		return fmt.Errorf("%w: no data for %v", ErrStalled, d.stallTimeout)
+	}
+	return err
+}
+
+// progressReader calls progress whenever it read data
+type progressReader struct {
+	io.ReadCloser
+	progress func()
+}
+
+// Read reads from the body and reports that data arrived
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.progress()
+	}
+	return n, err
+}
+
+// WithStallTimeout abandons a chunk response that delivers no data for
+// timeout. With reopen the rest of the chunk is requested again on a fresh
+// connection, up to three times, before the chunk fails and the retry
+// policy takes over
+func WithStallTimeout(timeout time.Duration, reopen bool) Option {
+	return func(d *Downloader) {
+		d.stallTimeout = timeout
+		d.stallRecovery = reopen
+	}
+}
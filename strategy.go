@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// Strategy selects how the downloaded chunks are assembled into the output
+type Strategy string
+
+const (
+	// StrategyWriteAt writes every chunk at its offset into the output,
+	// sized up front. It needs a seekable output on a filesystem that
+	// supports sparse or preallocated files, which is any local disk, and
+	// needs no extra space or merge step. This is the default
+	StrategyWriteAt Strategy = "writeat"
+	// StrategyTempFiles writes every chunk to its own temporary file and
+	// concatenates them at the end. It only needs files that can be
+	// appended to, at the cost of twice the disk space and a merge pass.
+	// For standard output and sinks the segments are written out in order
+	// as each completes, with a window of temporary files on disk instead
+	// of segments in memory
+	StrategyTempFiles Strategy = "tempfiles"
+	// StrategyStream keeps a window of at most concurrency segments in
+	// memory and writes them in order, so the output can be a pipe or
+	// standard output. Memory use is concurrency times the segment size
+	StrategyStream Strategy = "stream"
+)
+
+// defaultStreamSegment is the segment size of StrategyStream without WithSegmentSize
+const defaultStreamSegment = 4 << 20
+
+// ParseStrategy parses the name of a Strategy
+func ParseStrategy(s string) (Strategy, error) {
+	switch st := Strategy(s); st {
+	case StrategyWriteAt, StrategyTempFiles, StrategyStream:
+		return st, nil
+	}
+	return "", fmt.Errorf("unknown strategy %q, expected writeat, tempfiles or stream", s)
+}
+
+// nopWriteCloser is an io.WriteCloser whose Close does nothing
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// fileOutput reports whether the output is a file named by the output
+// rather than standard output, a sink or a region of a WriterAt
+func (d *Downloader) fileOutput() bool {
+	return !d.streamed() && d.writerAt == nil
+}
+
+// streamed reports whether the output goes to standard output or a sink
+// instead of a file, so it can only be written once and in order
+func (d *Downloader) streamed() bool {
+	return d.output == "-" || d.sink != nil
+}
+
+// createOutput creates the output file, or opens the sink of a streamed
+// output of size bytes, -1 if unknown, hashing what is written to it with
+// sum since it cannot be read back
+func (d *Downloader) createOutput(sum *checksum, size int64) (io.WriteCloser, error) {
+	if d.writerAt != nil {
+		return nopWriteCloser{io.NewOffsetWriter(d.writerAt, d.baseOffset)}, nil
+	}
+	if !d.streamed() {
+		file, err := os.Create(d.output)
+		if err != nil {
+			return nil, err
+		}
+		d.wroteOutput = true
+		return file, nil
+	}
+	var w io.Writer = os.Stdout
+	if d.sink != nil {
+		var err error
+		if w, err = d.sink(size); err != nil {
+			return nil, err
+		}
+	}
+	d.outBytes = 0
+	w = &countingWriter{w: w, n: &d.outBytes}
+	if sum == nil {
+		return nopWriteCloser{w}, nil
+	}
+	d.outHash = sum.newHash()
+	return nopWriteCloser{io.MultiWriter(w, d.outHash)}, nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+// Write writes p and adds the bytes written to the counter
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	*w.n += int64(n)
+	return n, err
+}
+
+// rangeWriter writes a chunk at its offset of a WriterAt and fails instead
+// of writing past the end of the chunk, which would corrupt the next one
+type rangeWriter struct {
+	w io.WriterAt
+	r [2]int64
+	n int64
+}
+
+// Write writes p after the bytes written so far
+func (w *rangeWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.r[1]-w.r[0]+1-w.n {
+		return 0, fmt.Errorf("server sent more than the %d bytes of range %v", w.r[1]-w.r[0]+1, w.r)
+	}
+	n, err := w.w.WriteAt(p, w.r[0]+w.n)
+	w.n += int64(n)
+	return n, err
+}
+
+// downloadWriteAt downloads the chunks straight into the output at their offsets
+func (d *Downloader) downloadWriteAt(ctx context.Context) error {
+	if d.writerAt != nil {
+		return d.writeRanges(ctx, &offsetWriterAt{w: d.writerAt, base: d.baseOffset})
+	}
+	file, err := os.OpenFile(d.output, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	// a device such as /dev/null can neither be sized nor removed
+	regular := info.Mode().IsRegular()
+	if regular {
+		if err := file.Truncate(d.size); err != nil {
+			return err
+		}
+	}
+	err = d.writeRanges(ctx, file)
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		file.Close()
+		if regular {
+			os.Remove(d.output)
+		}
+	}
+	return err
+}
+
+// writeRanges downloads the chunks into dst at their offsets
+func (d *Downloader) writeRanges(ctx context.Context, dst io.WriterAt) error {
+	// the writers keep the progress of every chunk, so that a chunk fetched
+	// again continues after the bytes it already wrote
+	writers := make([]*rangeWriter, len(d.ranges))
+	for i, r := range d.ranges {
+		writers[i] = &rangeWriter{w: dst, r: r}
+	}
+	fetch := func(ctx context.Context, i int, r [2]int64) error {
+		w := writers[i]
+		want := r[1] - r[0] + 1
+		if w.n < want {
+			if err := d.fetchRange(ctx, [2]int64{r[0] + w.n, r[1]}, w); err != nil {
+				return err
+			}
+		}
+		if w.n != want {
+			return fmt.Errorf("chunk %d: received %d bytes, expected %d", i, w.n, want)
+		}
+		return nil
+	}
+	if d.adaptWindow > 0 && d.concurrency > 1 {
+		return d.runAdaptive(ctx, fetch)
+	}
+	return d.runChunks(ctx, fetch)
+}
+
+// downloadOrdered downloads up to concurrency segments ahead in memory, or
+// the read-ahead with WithReadAhead, and writes them to the output in order
+func (d *Downloader) downloadOrdered(ctx context.Context, sum *checksum) error {
+	var largest int64
+	for _, r := range d.ranges {
+		if n := r[1] - r[0] + 1; n > largest {
+			largest = n
+		}
+	}
+	// the window holds the segment being written and those fetched ahead of it
+	size := d.concurrency
+	if d.readAhead > 0 {
+		size = d.readAhead + 1
+	}
+	if err := d.checkMemory(int64(size) * largest); err != nil {
+		return err
+	}
+	out, err := d.createOutput(sum, d.size)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	d.segments = make([]segmentState, len(d.ranges))
+	type result struct {
+		buf []byte
+		err error
+	}
+	results := make([]chan result, len(d.ranges))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+	// window holds a token for every segment fetched but not yet written,
+	// conns one for every request in flight
+	window := make(chan struct{}, size)
+	conns := make(chan struct{}, d.concurrency)
+	go func() {
+		for i, r := range d.ranges {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			idx := i
+			go func() {
+				select {
+				case conns <- struct{}{}:
+				case <-ctx.Done():
+					results[idx] <- result{nil, ctx.Err()}
+					return
+				}
+				defer func() { <-conns }()
+				w := &sliceWriter{buf: make([]byte, r[1]-r[0]+1)}
+				err := d.runSegment(ctx, idx, -1, func(ctx context.Context, i int, r [2]int64) error {
+					// a retry continues after the bytes received
+					if err := d.fetchRange(ctx, [2]int64{r[0] + int64(w.n), r[1]}, w); err != nil {
+						return err
+					}
+					if w.n != len(w.buf) {
+						return fmt.Errorf("chunk %d: received %d bytes, expected %d", i, w.n, len(w.buf))
+					}
+					return nil
+				})
+				results[idx] <- result{w.buf, err}
+			}()
+		}
+	}()
+
+	for i := range d.ranges {
+		var res result
+		select {
+		case res = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if res.err != nil {
+			log.Printf("Error downloading chunk %d: %v\n", i, res.err)
+			return res.err
+		}
+		if _, err := d.diskFull(ctx, out).Write(res.buf); err != nil {
+			return err
+		}
+		<-window
+	}
+	return out.Close()
+}
+
+// WithStrategy selects how the chunks are assembled into the output. Split
+// outputs always use temporary part files and standard output streams, in
+// memory unless the strategy is StrategyTempFiles
+func WithStrategy(s Strategy) Option {
+	return func(d *Downloader) {
+		d.strategy = s
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStrategies(t *testing.T) {
+	tests := []struct {
+		size        int
+		concurrency int
+		segment     int64
+	}{
+		{size: 0, concurrency: 4},
+		{size: 1, concurrency: 4},
+		{size: 3, concurrency: 4},
+		{size: 10000, concurrency: 1},
+		{size: 10000, concurrency: 4},
+		{size: 10007, concurrency: 3},
+		{size: 10007, concurrency: 4, segment: 1000},
+		{size: 1 << 20, concurrency: 8, segment: 64 << 10},
+	}
+	for _, strategy := range []Strategy{StrategyWriteAt, StrategyTempFiles, StrategyStream} {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/%d bytes/%d workers/%d segments", strategy, tt.size, tt.concurrency, tt.segment), func(t *testing.T) {
+				content := make([]byte, tt.size)
+				for i := range content {
+					content[i] = byte(i * 7 % 251)
+				}
+				srv := serveContent(t, content)
+				dir := t.TempDir()
+				output := filepath.Join(dir, "output")
+				opts := []Option{WithStrategy(strategy), WithSegmentSize(tt.segment)}
+				if err := NewDownloader(srv.URL, output, tt.concurrency, opts...).Download(); err != nil {
+					t.Fatalf("Download() = %v", err)
+				}
+				if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+					t.Errorf("output holds %d bytes, want the %d of the file", len(got), len(content))
+				}
+				if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+					t.Errorf("%s holds %d files, want only the output", dir, len(entries))
+				}
+
+				// the same without an output file
+				var buf bytes.Buffer
+				err := NewDownloader(srv.URL, filepath.Join(dir, "unused"), tt.concurrency, opts...).DownloadTo(t.Context(), func(int64) (io.Writer, error) { return &buf, nil })
+				if err != nil {
+					t.Fatalf("DownloadTo() = %v", err)
+				}
+				if !bytes.Equal(buf.Bytes(), content) {
+					t.Errorf("DownloadTo() wrote %d bytes, want the %d of the file", buf.Len(), len(content))
+				}
+			})
+		}
+	}
+}
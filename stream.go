@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// downloadStream downloads the whole file with a single GET, used when the
+// server does not support range requests. Without decompression identity
+// encoding is requested so the output holds exactly the bytes of the
+// resource. With decompression gzip and deflate are accepted and decoded, so
+// the output and its checksum are those of the decoded content and its size
+// will not match the Content-Length, which counts the encoded bytes
+func (d *Downloader) downloadStream(ctx context.Context, sum *checksum) error {
+	req, err := d.newRequest(ctx, "GET")
+	if err != nil {
+		return err
+	}
+	if d.decompress {
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	} else if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+
+	var body io.Reader = d.pausable(ctx, d.wrapBody(resp.Body, chunkRequest))
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	decoded := false
+	if d.decompress && encoding != "" && encoding != "identity" {
+		if body, err = decodeBody(body, encoding); err != nil {
+			return err
+		}
+		decoded = true
+		log.Printf("Decompressing %s response\n", encoding)
+	}
+	body = d.sniffed(body)
+	var md5Check *contentMD5
+	if !decoded {
+		// a decoded body no longer matches the digest of the encoded one
+		if md5Check = d.checkContentMD5(resp); md5Check != nil {
+			body = md5Check.reader(body)
+		}
+		if err := d.checkExpectedSize(resp.ContentLength); err != nil {
+			return err
+		}
+	}
+
+	size := resp.ContentLength
+	if decoded {
+		size = -1
+	}
+	file, err := d.createOutput(sum, size)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	n, err := io.Copy(d.diskFull(ctx, file), body)
+	if err != nil {
+		return err
+	}
+	if !decoded && resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Errorf("received %d bytes, expected %d", n, resp.ContentLength)
+	}
+	if err := d.checkExpectedSize(n); err != nil {
+		return err
+	}
+	if md5Check != nil {
+		if err := md5Check.verify(); err != nil {
+			return err
+		}
+	}
+	d.size = n
+	log.Printf("Received %d bytes\n", n)
+	return file.Close()
+}
+
+// decodeBody wraps body with a decoder for the given Content-Encoding
+func decodeBody(body io.Reader, encoding string) (io.Reader, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		// deflate is meant to be zlib wrapped but some servers send raw deflate
+		br := bufio.NewReader(body)
+		header, err := br.Peek(2)
+		if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	}
+	return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+}
+
+// WithDecompress decodes gzip and deflate responses in the single stream
+// fallback used when the server does not support range requests
+func WithDecompress(decompress bool) Option {
+	return func(d *Downloader) {
+		d.decompress = decompress
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxSuffix is the highest suffix tried by WithAutoSuffix
+const maxSuffix = 10000
+
+// doubleExtensions are the extensions a suffix is inserted before as a whole
+var doubleExtensions = []string{".tar.gz", ".tar.bz2", ".tar.xz", ".tar.zst"}
+
+// suffixedName returns name with the suffix n inserted before its
+// extension, e.g. file.1.zip, or appended when it has none, e.g. output.1
+func suffixedName(name string, n int) string {
+	base := filepath.Base(name)
+	ext := filepath.Ext(base)
+	for _, e := range doubleExtensions {
+		if strings.HasSuffix(strings.ToLower(base), e) {
+			ext = base[len(base)-len(e):]
+			break
+		}
+	}
+	// a dot file like .bashrc has no extension
+	if ext == base {
+		ext = ""
+	}
+	suffix := fmt.Sprintf(".%d%s", n, ext)
+	stem := name[:len(name)-len(ext)]
+	// keep the suffixed name within the file name limit
+	if over := len(base) - len(ext) + len(suffix) - maxNameBytes; over > 0 {
+		stem = truncateUTF8(stem, len(stem)-over)
+	}
+	return stem + suffix
+}
+
+// reserveOutput picks the first of output, output.1, output.2, ... that does
+// not exist and creates it empty, so that concurrent downloads into the same
+// directory cannot pick the same name
+func reserveOutput(output string) (string, error) {
+	for n := 0; n <= maxSuffix; n++ {
+		name := output
+		if n > 0 {
+			name = suffixedName(output, n)
+		}
+		file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return name, file.Close()
+	}
+	return "", fmt.Errorf("no free name for %s up to suffix %d", output, maxSuffix)
+}
+
+// WithAutoSuffix saves to output.1, output.2, ... when the output exists, like
+// wget, instead of overwriting it. The suffix goes before the extension, so
+// file.zip becomes file.1.zip. The chosen name is reserved by creating it
+// empty before the download starts, is removed again if the download fails
+// and is what Output returns. It cannot be combined with WithContinue
+func WithAutoSuffix(enabled bool) Option {
+	return func(d *Downloader) {
+		d.autoSuffix = enabled
+	}
+}
+
+// Output returns the name of the output, which may differ from the one
+// given to NewDownloader when WithAutoSuffix picked another one
+func (d *Downloader) Output() string {
+	return d.output
+}
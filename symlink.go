@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ErrSymlinkOutput is returned when the output is a symbolic link and the
+// SymlinkPolicy refuses it
+var ErrSymlinkOutput = errors.New("output is a symbolic link")
+
+// SymlinkPolicy selects what happens when the output is a symbolic link
+type SymlinkPolicy string
+
+const (
+	// SymlinkRefuse fails with ErrSymlinkOutput, so a link planted in a
+	// shared directory cannot redirect the download onto another file.
+	// It is the default
+	SymlinkRefuse SymlinkPolicy = "refuse"
+	// SymlinkFollow writes to the file the link points to
+	SymlinkFollow SymlinkPolicy = "follow"
+	// SymlinkReplace atomically replaces the link with a new regular file
+	// and leaves the file it pointed to untouched
+	SymlinkReplace SymlinkPolicy = "replace"
+)
+
+// ParseSymlinkPolicy parses the name of a SymlinkPolicy
+func ParseSymlinkPolicy(s string) (SymlinkPolicy, error) {
+	switch p := SymlinkPolicy(s); p {
+	case SymlinkRefuse, SymlinkFollow, SymlinkReplace:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown symlink policy %q, expected refuse, follow or replace", s)
+}
+
+// checkSymlink applies the symlink policy to the output
+func (d *Downloader) checkSymlink() error {
+	info, err := os.Lstat(d.output)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink == 0 {
+		return nil
+	}
+	switch d.symlinks {
+	case SymlinkFollow:
+		return nil
+	case SymlinkReplace:
+		return replaceSymlink(d.output)
+	}
+	return fmt.Errorf("%w: %s, use the follow or replace symlink policy to write it", ErrSymlinkOutput, d.output)
+}
+
+// replaceSymlink replaces the link at path with an empty regular file by
+// renaming a new file over it, so the path never goes missing
+func replaceSymlink(path string) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".new"+strconv.FormatInt(time.Now().UnixNano(), 36))
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// WithSymlinkPolicy selects what happens when the output is a symbolic
+// link, SymlinkRefuse by default
+func WithSymlinkPolicy(p SymlinkPolicy) Option {
+	return func(d *Downloader) {
+		d.symlinks = p
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymlinkPolicy(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	srv := serveContent(t, content)
+	target := []byte("the file the link points to")
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		err    error
+		output []byte // the content read through the output path
+		target []byte // the content of the link target afterwards
+		link   bool   // whether the output is still a link
+	}{
+		{name: "default refuses", err: ErrSymlinkOutput, output: target, target: target, link: true},
+		{name: "refuse", opts: []Option{WithSymlinkPolicy(SymlinkRefuse)}, err: ErrSymlinkOutput, output: target, target: target, link: true},
+		{name: "follow", opts: []Option{WithSymlinkPolicy(SymlinkFollow)}, output: content, target: content, link: true},
+		{name: "replace", opts: []Option{WithSymlinkPolicy(SymlinkReplace)}, output: content, target: target},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			targetPath := filepath.Join(dir, "target")
+			if err := os.WriteFile(targetPath, target, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			output := filepath.Join(dir, "output")
+			if err := os.Symlink(targetPath, output); err != nil {
+				t.Skipf("cannot create a symbolic link: %v", err)
+			}
+
+			err := NewDownloader(srv.URL, output, 2, tt.opts...).Download()
+			if tt.err == nil && err != nil {
+				t.Fatalf("Download() = %v, want nil", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("Download() = %v, want %v", err, tt.err)
+			}
+
+			info, err := os.Lstat(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if link := info.Mode()&os.ModeSymlink != 0; link != tt.link {
+				t.Errorf("output is a link: %v, want %v", link, tt.link)
+			}
+			if got, _ := os.ReadFile(output); !bytes.Equal(got, tt.output) {
+				t.Errorf("output holds %d bytes %.20q, want %d bytes %.20q", len(got), got, len(tt.output), tt.output)
+			}
+			if got, _ := os.ReadFile(targetPath); !bytes.Equal(got, tt.target) {
+				t.Errorf("target holds %d bytes %.20q, want %d bytes %.20q", len(got), got, len(tt.target), tt.target)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TimingStats breaks down where the time of the traced requests went,
+// summed over them, to tell slow DNS, connects or handshakes from a slow
+// server or transfer. Reused connections skip DNS, connect and TLS
+type TimingStats struct {
+	Requests  int           // the requests that got a connection
+	NewConns  int           // those for which a new connection was opened
+	DNS       time.Duration // resolving host names
+	Connect   time.Duration // establishing TCP connections
+	TLS       time.Duration // TLS handshakes
+	FirstByte time.Duration // from writing the request to the first byte of the response
+}
+
+// timingCounters accumulates TimingStats, accessed atomically
+type timingCounters struct {
+	requests, newConns           int32
+	dns, connect, tls, firstByte int64 // nanoseconds
+}
+
+// stats returns the TimingStats accumulated so far
+func (c *timingCounters) stats() TimingStats {
+	return TimingStats{
+		Requests:  int(atomic.LoadInt32(&c.requests)),
+		NewConns:  int(atomic.LoadInt32(&c.newConns)),
+		DNS:       time.Duration(atomic.LoadInt64(&c.dns)),
+		Connect:   time.Duration(atomic.LoadInt64(&c.connect)),
+		TLS:       time.Duration(atomic.LoadInt64(&c.tls)),
+		FirstByte: time.Duration(atomic.LoadInt64(&c.firstByte)),
+	}
+}
+
+// requestTiming is the breakdown of a single request, as the HAR recorder
+// reports it
+type requestTiming struct {
+	mu                         sync.Mutex
+	dns, connect, tls, waiting time.Duration
+	traced                     bool // whether a connection was obtained
+	reused                     bool
+}
+
+// requestTimingKey is the context key of the requestTiming of a request
+type requestTimingKey struct{}
+
+// timingOf returns the requestTiming of a traced request context, or nil
+func timingOf(ctx context.Context) *requestTiming {
+	t, _ := ctx.Value(requestTimingKey{}).(*requestTiming)
+	return t
+}
+
+// traced adds an httptrace to ctx recording the phases of its request into
+// the counters of the download, of the worker running ctx, if any, and the
+// requestTiming of the request
+func (d *Downloader) traced(ctx context.Context) context.Context {
+	counters := []*timingCounters{&d.timing}
+	if ws, ok := ctx.Value(workerKey{}).(*workerStats); ok {
+		counters = append(counters, &ws.timing)
+	}
+	rt := &requestTiming{}
+	add := func(field func(*timingCounters) *int64, phase *time.Duration, start time.Time) {
+		elapsed := time.Since(start)
+		for _, c := range counters {
+			atomic.AddInt64(field(c), int64(elapsed))
+		}
+		rt.mu.Lock()
+		*phase += elapsed
+		rt.mu.Unlock()
+	}
+	var dnsStart, connectStart, tlsStart, wrote time.Time
+	var mu sync.Mutex // connects of a dual-stack dial may overlap
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			add(func(c *timingCounters) *int64 { return &c.dns }, &rt.dns, dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			mu.Lock()
+			if connectStart.IsZero() {
+				connectStart = time.Now()
+			}
+			mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			mu.Lock()
+			start := connectStart
+			mu.Unlock()
+			if err == nil {
+				add(func(c *timingCounters) *int64 { return &c.connect }, &rt.connect, start)
+			}
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			add(func(c *timingCounters) *int64 { return &c.tls }, &rt.tls, tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			for _, c := range counters {
+				atomic.AddInt32(&c.requests, 1)
+				if !info.Reused {
+					atomic.AddInt32(&c.newConns, 1)
+				}
+			}
+			rt.mu.Lock()
+			rt.traced, rt.reused = true, info.Reused
+			rt.mu.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			mu.Lock()
+			wrote = time.Now()
+			mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			start := wrote
+			mu.Unlock()
+			add(func(c *timingCounters) *int64 { return &c.firstByte }, &rt.waiting, start)
+		},
+	}
+	return httptrace.WithClientTrace(context.WithValue(ctx, requestTimingKey{}, rt), trace)
+}
+
+// average returns d per request of t, 0 without requests
+func (t TimingStats) average(d time.Duration) time.Duration {
+	if t.Requests == 0 {
+		return 0
+	}
+	return (d / time.Duration(t.Requests)).Round(time.Microsecond)
+}
+
+// logTiming logs the latency breakdown of all requests and of every worker
+func logTiming(total TimingStats, conns []ConnectionStats) {
+	log.Printf("Timing of %d requests, %d on new connections, on average: DNS %v, connect %v, TLS %v, first byte %v\n",
+		total.Requests, total.NewConns, total.average(total.DNS), total.average(total.Connect), total.average(total.TLS), total.average(total.FirstByte))
+	if len(conns) == 0 {
+		return
+	}
+	log.Println("Worker  Requests  NewConns         DNS     Connect         TLS   FirstByte")
+	for _, c := range conns {
+		t := c.Timing
+		log.Printf("%6d  %8d  %8d  %10v  %10v  %10v  %10v\n", c.Worker, t.Requests, t.NewConns,
+			t.average(t.DNS), t.average(t.Connect), t.average(t.TLS), t.average(t.FirstByte))
+	}
+}
+
+// WithTraceTiming traces every request with net/http/httptrace and records
+// the time spent on DNS, connecting, the TLS handshake and waiting for the
+// first byte, reported in DownloadResult, in the log and, with a HAR
+// recorder, in the timings of its entries. It costs a little per request,
+// so it is off by default
+func WithTraceTiming(enabled bool) Option {
+	return func(d *Downloader) {
+		d.traceTiming = enabled
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Tracer starts the spans of a download, for distributed tracing. It is
+// small enough to adapt any tracing library without this package depending
+// on it, e.g. OpenTelemetry:
+//
+//	type otelTracer struct{ t trace.Tracer }
+//
+//	func (o otelTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+//		ctx, s := o.t.Start(ctx, name)
+//		return ctx, otelSpan{s}
+//	}
+//
+//	type otelSpan struct{ s trace.Span }
+//
+//	func (o otelSpan) SetAttributes(attrs ...Attribute) { o.s.SetAttributes(otelAttrs(attrs)...) }
+//	func (o otelSpan) AddEvent(name string, attrs ...Attribute) {
+//		o.s.AddEvent(name, trace.WithAttributes(otelAttrs(attrs)...))
+//	}
+//	func (o otelSpan) End(err error) {
+//		if err != nil {
+//			o.s.RecordError(err)
+//			o.s.SetStatus(codes.Error, err.Error())
+//		}
+//		o.s.End()
+//	}
+//
+// where otelAttrs converts the values with attribute.String, attribute.Int64
+// and attribute.Bool. The spans follow these conventions:
+//
+//	download        the whole DownloadContext call, a child of the span in its ctx
+//	  download.url          the url without credentials, set at the start
+//	  download.output       the output, set at the start and once named
+//	  download.size         the size of the file, set once probed, -1 when unknown
+//	  download.concurrency  the connections used, set at the end
+//	  download.transferred  the bytes read from the network, set at the end
+//	  download.retries      the chunk requests retried, set at the end
+//	  download.redirects    the redirects followed by all requests, set at the end
+//	  download.status       completed, failed, declined or cached, set at the end
+//	  events: size_known with download.size, retry with retry.attempt and retry.wait_ms,
+//	          redirect with redirect.count, the redirects of the request so far, and redirect.url
+//	download.chunk  one attempt at a chunk, a child of download whose context
+//	                the chunk request carries, so an instrumented transport
+//	                nests the HTTP span under it
+//	  chunk.id      the index of the chunk
+//	  chunk.start   the first byte of the chunk
+//	  chunk.end     the last byte of the chunk
+//	  chunk.worker  the worker fetching it, -1 for the stream strategy
+//
+// A span ends with the error of what it covers, nil on success
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer. Its methods are called from several
+// goroutines at once
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	AddEvent(name string, attrs ...Attribute)
+	End(err error)
+}
+
+// Attribute is a key and value of a span or event. The value is a string,
+// an int64 or a bool
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// startTrace starts the download span when a tracer is set and returns the
+// context carrying it and the function ending it with the outcome
+func (d *Downloader) startTrace(ctx context.Context) (context.Context, func(err error)) {
+	if d.tracer == nil {
+		return ctx, func(error) {}
+	}
+	ctx, d.span = d.tracer.Start(ctx, "download")
+	d.span.SetAttributes(
+		Attribute{"download.url", redactURL(d.url)},
+		Attribute{"download.output", d.output},
+	)
+	return ctx, func(err error) {
+		res := d.Result()
+		status := "completed"
+		switch {
+		case err != nil:
+			status = "failed"
+		case d.declined:
+			status = "declined"
+		case d.cached:
+			status = "cached"
+		}
+		d.span.SetAttributes(
+			Attribute{"download.output", d.output},
+			Attribute{"download.concurrency", int64(d.concurrency)},
+			Attribute{"download.transferred", res.Transferred},
+			Attribute{"download.retries", int64(res.Retries)},
+			Attribute{"download.redirects", int64(res.Redirects)},
+			Attribute{"download.status", status},
+		)
+		d.span.End(err)
+	}
+}
+
+// traceEvent adds an event to the download span, if any
+func (d *Downloader) traceEvent(name string, attrs ...Attribute) {
+	if d.span != nil {
+		d.span.AddEvent(name, attrs...)
+	}
+}
+
+// traceSizeKnown records the probed size on the download span
+func (d *Downloader) traceSizeKnown() {
+	if d.span != nil {
+		d.span.SetAttributes(Attribute{"download.size", d.size})
+		d.span.AddEvent("size_known", Attribute{"download.size", d.size})
+	}
+}
+
+// traceRetry records a retry after attempt n failed, waiting wait
+func (d *Downloader) traceRetry(n int, wait time.Duration) {
+	d.traceEvent("retry", Attribute{"retry.attempt", int64(n)}, Attribute{"retry.wait_ms", wait.Milliseconds()})
+}
+
+// traceChunks wraps fn in a download.chunk span per attempt when a tracer is set
+func (d *Downloader) traceChunks(worker int, fn func(ctx context.Context, i int, r [2]int64) error) func(ctx context.Context, i int, r [2]int64) error {
+	if d.tracer == nil {
+		return fn
+	}
+	return func(ctx context.Context, i int, r [2]int64) error {
+		ctx, span := d.tracer.Start(ctx, "download.chunk")
+		span.SetAttributes(
+			Attribute{"chunk.id", int64(i)},
+			Attribute{"chunk.start", r[0]},
+			Attribute{"chunk.end", r[1]},
+			Attribute{"chunk.worker", int64(worker)},
+		)
+		err := fn(ctx, i, r)
+		span.End(err)
+		return err
+	}
+}
+
+// WithTracer records the download in spans started by t, see Tracer for
+// the spans and attributes. The download span is a child of the span in
+// the context given to DownloadContext
+func WithTracer(t Tracer) Option {
+	return func(d *Downloader) {
+		d.tracer = t
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// transportOptions are the settings of the http.Client a Downloader builds
+// for itself, ignored when a client is supplied with WithHTTPClient
+type transportOptions struct {
+	connectTo      []ConnectTo                  // endpoints dialed instead of the requested ones
+	maxHeaderBytes int64                        // the largest response header accepted, 0 for the net/http default of 1MB
+	proxyConnect   http.Header                  // the headers sent on CONNECT requests to the proxy
+	jar            http.CookieJar               // stores the cookies set by responses, nil to drop them
+	schemes        map[string]http.RoundTripper // handlers for schemes besides http and https, over defaultSchemes
+	localAddrs     []net.IP                     // the addresses the connections are bound to, none to let the system pick
+	maxDNS         int                          // the most DNS lookups at a time, 0 for no limit
+	socket         socketOptions                // TCP_NODELAY and the socket buffers of the connections
+}
+
+// newClient builds an http.Client honouring the options, logging details with debugf
+func (o *transportOptions) newClient(debugf func(string, ...interface{})) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   o.socket.control(debugf),
+	}
+	if o.maxHeaderBytes > 0 {
+		transport.MaxResponseHeaderBytes = o.maxHeaderBytes
+	}
+	if len(o.proxyConnect) > 0 {
+		transport.ProxyConnectHeader = o.proxyConnect.Clone()
+	}
+	dial := dialer.DialContext
+	if len(o.localAddrs) > 0 {
+		dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return o.dialLocal(ctx, *dialer, network, address)
+		}
+	}
+	if o.socket.set() {
+		connect := dial
+		dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := connect(ctx, network, address)
+			if err == nil {
+				o.socket.apply(conn, debugf)
+			}
+			return conn, err
+		}
+	}
+	var dns *dnsLimiter
+	if o.maxDNS > 0 {
+		dns = newDNSLimiter(o.maxDNS)
+	}
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		address = o.dialAddress(address, debugf)
+		if dns != nil {
+			return dns.dial(ctx, network, address, dial)
+		}
+		return dial(ctx, network, address)
+	}
+	for scheme, rt := range defaultSchemes {
+		if o.schemes[scheme] == nil {
+			transport.RegisterProtocol(scheme, rt)
+		}
+	}
+	for scheme, rt := range o.schemes {
+		transport.RegisterProtocol(scheme, rt)
+	}
+	return &http.Client{Transport: transport, Jar: o.jar}
+}
+
+// WithMaxResponseHeaderBytes bounds the size of the response headers
+// accepted for the probe and chunk requests, so an untrusted server cannot
+// make the client buffer huge headers before the body is even read
+func WithMaxResponseHeaderBytes(n int64) Option {
+	return func(d *Downloader) {
+		d.transport.maxHeaderBytes = n
+	}
+}
+
+// WithProxyConnectHeaders sends header on the CONNECT request that opens a
+// tunnel through the proxy, e.g. the identity token of a zero-trust proxy.
+// Unlike the headers of WithHeaders, which reach the origin server inside
+// the tunnel, these are only seen by the proxy. They are used for every
+// https request through a proxy from the environment, while plain http
+// requests through a proxy do not open a tunnel and do not carry them
+func WithProxyConnectHeaders(header http.Header) Option {
+	return func(d *Downloader) {
+		d.transport.proxyConnect = header
+	}
+}
+
+// WithCookieJar stores the cookies set by any response in jar and sends
+// them on the later requests, e.g. a session cookie set by the probe that
+// the server requires on every chunk request. A jar from
+// net/http/cookiejar can be shared by several downloaders
+func WithCookieJar(jar http.CookieJar) Option {
+	return func(d *Downloader) {
+		d.transport.jar = jar
+	}
+}
+
+// wrapClient returns a copy of client whose transport is wrapped by the
+// middleware, the first one being the innermost
+func wrapClient(client *http.Client, middleware []func(http.RoundTripper) http.RoundTripper) *http.Client {
+	c := *client
+	if c.Transport == nil {
+		c.Transport = http.DefaultTransport
+	}
+	for _, m := range middleware {
+		c.Transport = m(c.Transport)
+	}
+	return &c
+}
+
+// WithHTTPClient makes the downloader use client for every request instead
+// of building its own, in which case the transport options have no effect
+func WithHTTPClient(client *http.Client) Option {
+	return func(d *Downloader) {
+		d.client = client
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCookieGatedServer(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	// the probe gets a session cookie that every chunk request must carry
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t", Path: "/"})
+		} else if c, err := r.Cookie("session"); err != nil || c.Value != "s3cr3t" {
+			http.Error(w, "no session", http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	output := filepath.Join(t.TempDir(), "output")
+	err := NewDownloader(srv.URL, output, 4).Download()
+	var status *StatusError
+	if !errors.As(err, &status) || status.Code != http.StatusForbidden {
+		t.Fatalf("Download() without a cookie jar = %v, want a 403", err)
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewDownloader(srv.URL, output, 4, WithCookieJar(jar)).Download(); err != nil {
+		t.Fatalf("Download() with a cookie jar = %v", err)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+		t.Errorf("output holds %d bytes, want the %d of the file", len(got), len(content))
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits maps the accepted size suffixes to their multiplier
+var sizeUnits = map[string]int64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+// parseSize parses a byte count such as 1024, 500K, 2M, 1.5G or 5GB.
+// Suffixes are binary multiples and case-insensitive. A fraction is rounded
+// to the nearest byte, and one that would round to 0 bytes is refused, so
+// 0.5 cannot silently turn into 0, which many options take as unlimited
+func parseSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "B"), "I")
+	i := strings.IndexFunc(str, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(str)
+	}
+	mult, ok := sizeUnits[str[i:]]
+	if !ok || i == 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	n, err := strconv.ParseFloat(str[:i], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	size := math.Round(n * float64(mult))
+	if size == 0 && n > 0 {
+		return 0, fmt.Errorf("invalid size %q: less than one byte", s)
+	}
+	return int64(size), nil
+}
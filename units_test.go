@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  bool
+	}{
+		{in: "1024", want: 1024},
+		{in: "500K", want: 500 << 10},
+		{in: "2m", want: 2 << 20},
+		{in: "5GB", want: 5 << 30},
+		{in: "1GiB", want: 1 << 30},
+		{in: " 1.5M ", want: 3 << 19},
+		{in: "0.5K", want: 512},
+		{in: "1.6", want: 2},
+		{in: "0", want: 0},
+		{in: "0.5", want: 1},
+		{in: "0.4", err: true},
+		{in: "0.0001K", err: true},
+		{in: "-1K", err: true},
+		{in: "K", err: true},
+		{in: "12X", err: true},
+		{in: "", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseSize(%q) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// validators identify a version of a resource, as sent by the server
+type validators struct {
+	etag     string
+	modified string
+	size     int64 // -1 when unknown
+}
+
+// known reports whether v can tell versions apart at all
+func (v validators) known() bool {
+	return v.etag != "" || v.modified != "" || v.size >= 0
+}
+
+// watcher mirrors a url into an output, see Watch
+type watcher struct {
+	url, output string
+	concurrency int
+	opts        []Option
+	probe       *Downloader // sends the conditional HEAD requests
+	last        validators  // of the mirrored version, unknown before the first download
+	hasLast     bool
+}
+
+// Watch keeps output a copy of url until ctx is done, checking every
+// interval with a conditional HEAD request whether the resource changed and
+// downloading it again when it did. A new version is downloaded next to the
+// output and renamed over it, so readers always see a complete file, and
+// the output gets the Last-Modified time of the server. An output left by an
+// earlier run is only replaced once the server reports a version modified
+// after it. Errors are logged and retried at the next check. A server
+// sending no ETag, Last-Modified or Content-Length cannot be compared, so
+// its resource is downloaded at every check. The interval is timed on the
+// clock of WithClock
+func Watch(ctx context.Context, url, output string, concurrency int, interval time.Duration, opts ...Option) error {
+	if output == "" || output == "-" {
+		return errors.New("watching needs an output file")
+	}
+	w := &watcher{url: url, output: output, concurrency: concurrency, opts: opts, probe: NewDownloader(url, output, 1, opts...)}
+	if info, err := os.Stat(output); err == nil {
+		w.last = validators{modified: info.ModTime().UTC().Format(http.TimeFormat), size: -1}
+		if c := w.probe.cache; c != nil {
+			// the cache knows the validators of the version the output holds
+			if e := c.entry(url, output); e != nil && e.Size == info.Size() && e.MTime.Equal(info.ModTime()) {
+				w.last, w.hasLast = validators{etag: e.ETag, modified: e.Modified, size: e.Size}, true
+			}
+		}
+	}
+	for {
+		if err := w.check(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("Watching %s: %v, retrying in %v\n", url, err, interval)
+		}
+		select {
+		case <-w.probe.clock.After(interval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// check downloads the resource when it changed since the last version
+func (w *watcher) check(ctx context.Context) error {
+	if c := w.probe.cache; c != nil && c.fresh(w.url, w.output) {
+		w.probe.debugf("%s was checked within the cache TTL\n", w.url)
+		return nil
+	}
+	changed, current, err := w.changed(ctx)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		if w.probe.logUnchanged {
+			log.Printf("%s is unchanged\n", w.url)
+		} else {
+			w.probe.debugf("%s is unchanged\n", w.url)
+		}
+		if w.probe.ipc != nil {
+			w.probe.ipc.send(ipcEvent{Type: "unchanged", URL: w.url, Output: w.output})
+		}
+		w.remember(current)
+		return nil
+	}
+	if w.hasLast && !current.known() {
+		log.Printf("%s sends no validators, downloading it again\n", w.url)
+	} else if w.hasLast || w.last.modified != "" {
+		log.Printf("%s changed, downloading it again\n", w.url)
+	}
+	return w.download(ctx)
+}
+
+// changed asks the server with a conditional HEAD whether the resource
+// differs from the last version
+func (w *watcher) changed(ctx context.Context) (bool, validators, error) {
+	req, err := w.probe.newRequest(ctx, "HEAD")
+	if err != nil {
+		return false, validators{}, err
+	}
+	if w.last.etag != "" {
+		req.Header.Set("If-None-Match", w.last.etag)
+	}
+	if w.last.modified != "" {
+		req.Header.Set("If-Modified-Since", w.last.modified)
+	}
+	resp, err := w.probe.client.Do(req)
+	if err != nil {
+		return false, validators{}, err
+	}
+	w.probe.wrapBody(resp.Body, probeRequest).Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return false, w.last, nil
+	case http.StatusOK:
+	default:
+		return false, validators{}, statusError(resp)
+	}
+	current := validators{etag: resp.Header.Get("ETag"), modified: resp.Header.Get("Last-Modified"), size: resp.ContentLength}
+	if !w.hasLast {
+		// only the time of an earlier output is known, a server ignoring
+		// If-Modified-Since is compared against it
+		if w.last.modified != "" && current.modified != "" && !laterThan(current.modified, w.last.modified) {
+			w.last, w.hasLast = current, true
+			return false, current, nil
+		}
+		return true, current, nil
+	}
+	// a server ignoring the conditional headers still has to differ
+	return !current.known() || current != w.last, current, nil
+}
+
+// laterThan reports whether the HTTP date a is after b, true when either
+// cannot be parsed
+func laterThan(a, b string) bool {
+	ta, errA := http.ParseTime(a)
+	tb, errB := http.ParseTime(b)
+	return errA != nil || errB != nil || ta.After(tb)
+}
+
+// download fetches the resource into a temporary file next to the output
+// and renames it over the output
+func (w *watcher) download(ctx context.Context) error {
+	tmp := filepath.Join(filepath.Dir(w.output), "."+filepath.Base(w.output)+".watch")
+	d := NewDownloader(w.url, tmp, w.concurrency, w.opts...)
+	// the cache records the output, not the temporary file
+	d.cache = nil
+	if err := d.DownloadContext(ctx); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if d.Declined() {
+		return nil
+	}
+	if mtime, err := http.ParseTime(d.modified); err == nil {
+		if err := os.Chtimes(tmp, time.Now(), mtime); err != nil {
+			log.Printf("Could not set the time of %s: %v\n", w.output, err)
+		}
+	}
+	if err := os.Rename(tmp, w.output); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replacing %s: %w", w.output, err)
+	}
+	w.last, w.hasLast = validators{etag: d.etag, modified: d.modified, size: d.size}, true
+	w.remember(w.last)
+	log.Printf("Updated %s\n", w.output)
+	return nil
+}
+
+// remember records in the cache, if any, that the output holds the version
+// with the validators v
+func (w *watcher) remember(v validators) {
+	if w.probe.cache != nil {
+		w.probe.cache.record(w.url, w.output, v.etag, v.modified)
+	}
+}
+
+// WithLogUnchanged makes Watch log every check that finds the resource
+// unchanged, as a heartbeat of a long-running mirror, instead of only in
+// verbose mode
+func WithLogUnchanged(enabled bool) Option {
+	return func(d *Downloader) {
+		d.logUnchanged = enabled
+	}
+}
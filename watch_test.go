@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchInterval(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	var heads int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			atomic.AddInt32(&heads, 1)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	clock := NewManualClock(time.Unix(0, 0))
+	output := filepath.Join(t.TempDir(), "output")
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 1)
+	go func() { done <- Watch(ctx, srv.URL, output, 2, time.Hour, WithClock(clock)) }()
+
+	// checks waits for the watch to wait for its next check, the n-th
+	checks := func(n int32) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); clock.Waiting() == 0 || atomic.LoadInt32(&heads) < n; {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d checks, want %d", atomic.LoadInt32(&heads), n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	checks(1)
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, content) {
+		t.Fatalf("output holds %d bytes, want the %d of the file", len(got), len(content))
+	}
+	first := atomic.LoadInt32(&heads)
+	clock.Advance(59 * time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&heads); got != first {
+		t.Fatalf("checked again after 59 minutes, %d HEAD requests, want %d", got, first)
+	}
+	clock.Advance(time.Minute)
+	checks(first + 1)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Watch() = %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"time"
+)
+
+// The extended attributes recorded on the output by WithXattr. The origin
+// url uses the freedesktop.org name that browsers and wget also set, the
+// others are specific to this downloader
+const (
+	xattrOriginURL = "user.xdg.origin.url" // the url the file was downloaded from, without credentials
+	xattrTime      = "user.download.time"  // when the download completed, in RFC 3339 format
+	xattrETag      = "user.download.etag"  // the ETag reported by the server, if any
+)
+
+// writeProvenance records where the output came from in its extended
+// attributes. Failures are only logged, since the download itself succeeded
+func (d *Downloader) writeProvenance() {
+	attrs := [][2]string{{xattrOriginURL, redactURL(d.url)}, {xattrTime, time.Now().UTC().Format(time.RFC3339)}}
+	if d.etag != "" {
+		attrs = append(attrs, [2]string{xattrETag, d.etag})
+	}
+	for _, a := range attrs {
+		if err := setxattr(d.output, a[0], a[1]); err != nil {
+			log.Printf("Warning: cannot record provenance in extended attributes: %v\n", err)
+			return
+		}
+	}
+	d.debugf("Recorded provenance in the extended attributes of %s\n", d.output)
+}
+
+// redactURL removes the credentials from rawURL
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	u.User = nil
+	return u.String()
+}
+
+// WithXattr records the source url, the completion time and the ETag of
+// the download as extended attributes of the output, see xattrOriginURL.
+// Where extended attributes are not supported a warning is logged
+func WithXattr(enabled bool) Option {
+	return func(d *Downloader) {
+		d.xattr = enabled
+	}
+}
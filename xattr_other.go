@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// setxattr fails, extended attributes are only supported on Linux
+func setxattr(path, name, value string) error {
+	return &os.PathError{Op: "setxattr", Path: path, Err: errors.ErrUnsupported}
+}